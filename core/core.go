@@ -14,8 +14,32 @@ import (
 )
 
 // DataHandler transport payload handler
+// ts 为触发本次回调的数据包抓取时间, 离线回放时为原始抓包时间,
+// 返回值为已使用的数据长度, 未使用部分将保留并与后续数据合并;
+// data 为会话缓存的切片, 仅在本次调用期间有效, 需保留时应自行拷贝
 type DataHandler func(session *Session, ts time.Time, data []byte) (int, error)
 
+// Packet 交付给 PacketHandler 的会话数据及元数据, 仅在回调期间有效, 需保留时应自行拷贝
+type Packet struct {
+	Session *Session
+	// Key 会话五元组, 可经 Canonical 归一双向会话
+	Key FlowKey
+	Src net.Addr
+	Dst net.Addr
+	// Data 会话累积的待处理数据, 语义与 DataHandler 的 data 相同
+	Data      []byte
+	Timestamp time.Time
+	Protocol  TransProto
+	// FlowHash 会话哈希, 双向会话哈希值相同, 算法见 pcap.FlowInfo 的 Hash 字段
+	FlowHash  uint64
+	Direction Direction
+	// FromHandshake 与 pcap.FlowInfo 的同名字段相同, 会话中途开始重组时可能缺失起始数据
+	FromHandshake bool
+}
+
+// PacketHandler 以 Packet 接收会话数据, 返回值语义与 DataHandler 相同
+type PacketHandler func(pkt *Packet) (int, error)
+
 var (
 	EtherHeaderSize    = binary.Size(EtherHeader{})
 	IPv4HeaderBaseSize = binary.Size(IPv4Header{})
@@ -99,8 +123,11 @@ type TransProto byte
 
 //go:generate stringer -type TransProto -linecomment
 const (
-	TCP TransProto = 0x06 // tcp
-	UDP TransProto = 0x11 // udp
+	ICMP   TransProto = 0x01 // icmp
+	TCP    TransProto = 0x06 // tcp
+	UDP    TransProto = 0x11 // udp
+	ICMPv6 TransProto = 0x3a // icmpv6
+	SCTP   TransProto = 0x84 // sctp
 )
 
 // IPv4Header ip v4 header
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"net/netip"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+)
+
+// FlowKey 会话的协议及双向端点, 可比较, 可直接作为 map 键,
+// IPv4 映射的 IPv6 地址统一转换为 IPv4 地址
+type FlowKey struct {
+	Proto   TransProto
+	SrcIP   netip.Addr
+	SrcPort uint16
+	DstIP   netip.Addr
+	DstPort uint16
+}
+
+// Key 返回会话的 FlowKey, 不包含 VLAN 标签
+func (s *Session) Key() FlowKey {
+	return FlowKey{
+		Proto:   s.Proto,
+		SrcIP:   toAddr(s.SrcIP),
+		SrcPort: uint16(s.SrcPort),
+		DstIP:   toAddr(s.DstIP),
+		DstPort: uint16(s.DstPort),
+	}
+}
+
+// ConversationKey 以双向端点地址构造归一后的 FlowKey, 请求与响应方向结果相同,
+// 支持 Packet.Src 及 Packet.Dst 可能取值的全部地址类型, 两端地址类型需一致
+func ConversationKey(src, dst net.Addr) (FlowKey, error) {
+	srcKey, err := endpoint(src)
+	if err != nil {
+		return FlowKey{}, err
+	}
+
+	dstKey, err := endpoint(dst)
+	if err != nil {
+		return FlowKey{}, err
+	}
+
+	if srcKey.Proto != dstKey.Proto {
+		return FlowKey{}, errors.Errorf(
+			"conversation address mismatch: %s, %s", src.Network(), dst.Network(),
+		)
+	}
+
+	return FlowKey{
+		Proto:   srcKey.Proto,
+		SrcIP:   srcKey.SrcIP,
+		SrcPort: srcKey.SrcPort,
+		DstIP:   dstKey.SrcIP,
+		DstPort: dstKey.SrcPort,
+	}.Canonical(), nil
+}
+
+// endpoint 解析单个端点地址, 结果保存在 FlowKey 的源端点
+func endpoint(addr net.Addr) (FlowKey, error) {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		return FlowKey{Proto: TCP, SrcIP: toAddr(v.IP), SrcPort: uint16(v.Port)}, nil
+	case *net.UDPAddr:
+		return FlowKey{Proto: UDP, SrcIP: toAddr(v.IP), SrcPort: uint16(v.Port)}, nil
+	case *SCTPAddr:
+		return FlowKey{Proto: SCTP, SrcIP: toAddr(v.IP), SrcPort: uint16(v.Port)}, nil
+	case *net.IPAddr:
+		ip := toAddr(v.IP)
+		if ip.Is6() {
+			return FlowKey{Proto: ICMPv6, SrcIP: ip}, nil
+		}
+
+		return FlowKey{Proto: ICMP, SrcIP: ip}, nil
+	case nil:
+		return FlowKey{}, errors.New("conversation address is nil")
+	default:
+		return FlowKey{}, errors.Errorf("unsupported conversation address: %T", addr)
+	}
+}
+
+func toAddr(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// Reverse 返回反方向的 FlowKey
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{
+		Proto:   k.Proto,
+		SrcIP:   k.DstIP,
+		SrcPort: k.DstPort,
+		DstIP:   k.SrcIP,
+		DstPort: k.SrcPort,
+	}
+}
+
+// Canonical 返回端点排序后的 FlowKey, 较小端点在前, 双向会话结果相同
+func (k FlowKey) Canonical() FlowKey {
+	if c := k.SrcIP.Compare(k.DstIP); c > 0 || c == 0 && k.SrcPort > k.DstPort {
+		return k.Reverse()
+	}
+
+	return k
+}
+
+// Hash 返回与抓包时会话哈希一致的 FastHash, 双向会话哈希值相同;
+// 计算快但算法由 gopacket 实现, 不保证跨版本一致, 且网络层与传输层哈希以 hash*31 线性组合,
+// 地址端口对称交换的不同会话易发生碰撞, 不宜持久化, 需跨运行关联会话时使用 StableHash
+func (k FlowKey) Hash() (hash uint64) {
+	if network, err := gopacket.FlowFromEndpoints(
+		layers.NewIPEndpoint(k.SrcIP.AsSlice()), layers.NewIPEndpoint(k.DstIP.AsSlice()),
+	); err == nil {
+		hash = network.FastHash()
+	}
+
+	var src, dst gopacket.Endpoint
+
+	switch k.Proto {
+	case TCP:
+		src = layers.NewTCPPortEndpoint(layers.TCPPort(k.SrcPort))
+		dst = layers.NewTCPPortEndpoint(layers.TCPPort(k.DstPort))
+	case UDP:
+		src = layers.NewUDPPortEndpoint(layers.UDPPort(k.SrcPort))
+		dst = layers.NewUDPPortEndpoint(layers.UDPPort(k.DstPort))
+	case SCTP:
+		src = layers.NewSCTPPortEndpoint(layers.SCTPPort(k.SrcPort))
+		dst = layers.NewSCTPPortEndpoint(layers.SCTPPort(k.DstPort))
+	default:
+		return
+	}
+
+	if transport, err := gopacket.FlowFromEndpoints(src, dst); err == nil {
+		hash = hash*31 + transport.FastHash()
+	}
+
+	return
+}
+
+// StableHash 返回以 FNV-1a 64 位计算的稳定哈希, 双向会话哈希值相同, 结果不随运行或版本变化, 可持久化;
+// 输入为 Canonical 后依次排列的 IP 协议号 (1 字节), 源地址 (16 字节, IPv4 为映射的 IPv6 地址), 源端口 (2 字节大端),
+// 目的地址及目的端口, 共 37 字节. FNV 为非加密哈希, 按生日界约 2^32 个会话时碰撞概率达到 50%, 不能抵御刻意构造的碰撞
+func (k FlowKey) StableHash() uint64 {
+	k = k.Canonical()
+
+	buf := make([]byte, 0, 37)
+	buf = append(buf, byte(k.Proto))
+
+	for _, ep := range []netip.AddrPort{
+		netip.AddrPortFrom(k.SrcIP, k.SrcPort), netip.AddrPortFrom(k.DstIP, k.DstPort),
+	} {
+		addr := ep.Addr().As16()
+		buf = binary.BigEndian.AppendUint16(append(buf, addr[:]...), ep.Port())
+	}
+
+	h := fnv.New64a()
+	h.Write(buf)
+
+	return h.Sum64()
+}
+
+func (k FlowKey) String() string {
+	return "[" + k.Proto.String() + "] " +
+		netip.AddrPortFrom(k.SrcIP, k.SrcPort).String() + " -> " +
+		netip.AddrPortFrom(k.DstIP, k.DstPort).String()
+}
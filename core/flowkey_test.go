@@ -0,0 +1,114 @@
+package core_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestFlowKey(t *testing.T) {
+	session := core.Session{
+		Proto:   core.TCP,
+		SrcIP:   net.ParseIP("192.168.1.2"),
+		SrcPort: 80,
+		DstIP:   net.ParseIP("192.168.1.1").To4(),
+		DstPort: 1000,
+	}
+
+	key := session.Key()
+	reverse := session.Reverse().Key()
+
+	if key == reverse {
+		t.Fatalf("directional key should differ: %s", key)
+	}
+
+	if key.Canonical() != reverse.Canonical() {
+		t.Errorf("canonical mismatch: %s, %s", key.Canonical(), reverse.Canonical())
+	}
+
+	if canonical := key.Canonical().String(); canonical != "[tcp] 192.168.1.1:1000 -> 192.168.1.2:80" {
+		t.Errorf("canonical key mismatch: %s", canonical)
+	}
+
+	if key.Hash() != reverse.Hash() {
+		t.Errorf("hash mismatch between directions: %d, %d", key.Hash(), reverse.Hash())
+	}
+
+	ip := layers.IPv4{
+		Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.ParseIP("192.168.1.2").To4(), DstIP: net.ParseIP("192.168.1.1").To4(),
+	}
+	tcp := layers.TCP{SrcPort: 80, DstPort: 1000}
+	tcp.SetNetworkLayerForChecksum(&ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(
+		buffer, gopacket.SerializeOptions{FixLengths: true}, &ip, &tcp,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	pkt := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	expect := pkt.NetworkLayer().NetworkFlow().FastHash()*31 + pkt.TransportLayer().TransportFlow().FastHash()
+
+	if key.Hash() != expect {
+		t.Errorf("hash mismatch with packet flow: %d, expected %d", key.Hash(), expect)
+	}
+}
+
+func TestConversationKey(t *testing.T) {
+	session := core.Session{
+		Proto:   core.UDP,
+		SrcIP:   net.ParseIP("192.168.1.2"),
+		SrcPort: 53,
+		DstIP:   net.ParseIP("192.168.1.1").To4(),
+		DstPort: 1000,
+	}
+
+	request, err := core.ConversationKey(session.SrcAddr(), session.DstAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := core.ConversationKey(session.DstAddr(), session.SrcAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request != response || request != session.Key().Canonical() {
+		t.Errorf("conversation key mismatch: %s, %s", request, response)
+	}
+
+	if _, err := core.ConversationKey(
+		&net.TCPAddr{IP: session.SrcIP, Port: 53}, session.DstAddr(),
+	); err == nil {
+		t.Error("mismatched address types should fail")
+	}
+}
+
+func TestFlowKeyStableHash(t *testing.T) {
+	session := core.Session{
+		Proto:   core.TCP,
+		SrcIP:   net.ParseIP("192.168.1.2"),
+		SrcPort: 80,
+		DstIP:   net.ParseIP("192.168.1.1").To4(),
+		DstPort: 1000,
+	}
+
+	key := session.Key()
+
+	// 固定值用于确认算法未发生变化
+	if hash := key.StableHash(); hash != 0x118e2bf853936763 || hash != session.Reverse().Key().StableHash() {
+		t.Errorf("stable hash mismatch: %#x, reverse: %#x", hash, session.Reverse().Key().StableHash())
+	}
+
+	udp := key
+	udp.Proto = core.UDP
+
+	if udp.StableHash() == key.StableHash() {
+		t.Error("stable hash should differ between protocols")
+	}
+}
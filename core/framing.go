@@ -0,0 +1,57 @@
+package core
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	origin_errors "errors"
+)
+
+// ErrInvalidMessageLength LengthPrefixedHandler 的 lengthFn 返回的消息长度小于头部长度
+var ErrInvalidMessageLength = origin_errors.New("invalid message length")
+
+// MessageHandler 完整消息回调, msg 包含头部, 为会话缓存的切片, 仅在本次调用期间有效, 需保留时应自行拷贝
+type MessageHandler func(src, dst net.Addr, msg []byte)
+
+// LengthPrefixedHandler 以头部长度字段分帧的 DataHandler, 适用于消息头部携带消息长度的二进制协议;
+// 每个消息的前 headerLen 字节交由 lengthFn 返回包含头部的消息总长度, 完整的消息依次交由 onMessage,
+// 不完整的头部或消息保留在会话缓存中与后续数据合并, 返回已交付消息的长度之和.
+// lengthFn 返回值小于 headerLen 时返回 ErrInvalidMessageLength, 此前的完整消息仍正常交付,
+// 后续处理由抓包的错误处理策略决定; headerLen <= 0 时 panic
+func LengthPrefixedHandler(headerLen int, lengthFn func(header []byte) int, onMessage MessageHandler) DataHandler {
+	if headerLen <= 0 {
+		panic(errors.Errorf("invalid message header length: %d", headerLen))
+	}
+
+	return func(session *Session, _ time.Time, data []byte) (int, error) {
+		var (
+			used     int
+			src, dst net.Addr
+		)
+
+		for len(data)-used >= headerLen {
+			size := lengthFn(data[used : used+headerLen])
+			if size < headerLen {
+				return used, errors.Wrapf(
+					ErrInvalidMessageLength,
+					"message length %d less than header length %d", size, headerLen,
+				)
+			}
+
+			if len(data)-used < size {
+				break
+			}
+
+			if src == nil {
+				src, dst = session.SrcAddr(), session.DstAddr()
+			}
+
+			onMessage(src, dst, data[used:used+size])
+			used += size
+		}
+
+		return used, nil
+	}
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"encoding/binary"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestLengthPrefixedHandler(t *testing.T) {
+	session := Session{
+		Proto:   TCP,
+		SrcIP:   net.ParseIP("192.168.1.1"),
+		SrcPort: 1000,
+		DstIP:   net.ParseIP("192.168.1.2"),
+		DstPort: 2000,
+	}
+
+	var messages []string
+
+	handler := LengthPrefixedHandler(2, func(header []byte) int {
+		return int(binary.BigEndian.Uint16(header))
+	}, func(src, dst net.Addr, msg []byte) {
+		if src.String() != "192.168.1.1:1000" || dst.String() != "192.168.1.2:2000" {
+			t.Fatalf("unexpected message address: %s -> %s", src, dst)
+		}
+
+		messages = append(messages, string(msg[2:]))
+	})
+
+	frame := func(body string) []byte {
+		return append(binary.BigEndian.AppendUint16(nil, uint16(2+len(body))), body...)
+	}
+
+	stream := slices.Concat(frame("first"), frame("second"), frame(""), frame("third"))
+
+	// 按不同边界切分数据流, 模拟头部及消息跨越多个报文
+	for _, step := range []int{1, 2, 3, 7, len(stream)} {
+		messages = messages[:0]
+
+		var cache []byte
+
+		for offset := 0; offset < len(stream); offset += step {
+			cache = append(cache, stream[offset:min(offset+step, len(stream))]...)
+
+			used, err := handler(&session, time.Now(), cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cache = cache[used:]
+		}
+
+		if len(cache) != 0 || !slices.Equal(messages, []string{"first", "second", "", "third"}) {
+			t.Fatalf("step %d messages mismatch: %q, remain: %v", step, messages, cache)
+		}
+	}
+
+	messages = messages[:0]
+
+	used, err := handler(&session, time.Now(), append(frame("valid"), 0, 1, 'x'))
+	if !errors.Is(err, ErrInvalidMessageLength) || used != 7 || !slices.Equal(messages, []string{"valid"}) {
+		t.Fatalf("invalid length not reported: %d %v %q", used, err, messages)
+	}
+}
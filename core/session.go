@@ -2,6 +2,7 @@ package core
 
 import (
 	"net"
+	"slices"
 	"strconv"
 
 	"github.com/valyala/bytebufferpool"
@@ -13,6 +14,58 @@ type Session struct {
 	SrcPort int
 	DstIP   net.IP
 	DstPort int
+	// 802.1Q VLAN ID, QinQ 时外层标签在前, 未携带标签时为空
+	VLANs []uint16
+	// 数据方向, 仅在观察到 TCP 握手时可知, 否则为 DirectionUnknown
+	Direction Direction
+}
+
+// Direction 会话数据方向
+type Direction byte
+
+//go:generate stringer -type Direction -linecomment
+const (
+	DirectionUnknown        Direction = iota // unknown
+	DirectionClientToServer                  // client_to_server
+	DirectionServerToClient                  // server_to_client
+)
+
+// Reverse 返回反方向
+func (d Direction) Reverse() Direction {
+	switch d {
+	case DirectionClientToServer:
+		return DirectionServerToClient
+	case DirectionServerToClient:
+		return DirectionClientToServer
+	default:
+		return d
+	}
+}
+
+// Reverse 返回反方向的会话
+func (s *Session) Reverse() *Session {
+	return &Session{
+		Proto:     s.Proto,
+		SrcIP:     s.DstIP,
+		SrcPort:   s.DstPort,
+		DstIP:     s.SrcIP,
+		DstPort:   s.SrcPort,
+		VLANs:     s.VLANs,
+		Direction: s.Direction.Reverse(),
+	}
+}
+
+// Clone 返回会话的深拷贝, 不再引用原始数据包中的地址数据
+func (s *Session) Clone() *Session {
+	return &Session{
+		Proto:     s.Proto,
+		SrcIP:     slices.Clone(s.SrcIP),
+		SrcPort:   s.SrcPort,
+		DstIP:     slices.Clone(s.DstIP),
+		DstPort:   s.DstPort,
+		VLANs:     slices.Clone(s.VLANs),
+		Direction: s.Direction,
+	}
 }
 
 func (s *Session) SrcAddr() net.Addr {
@@ -21,6 +74,10 @@ func (s *Session) SrcAddr() net.Addr {
 		return &net.TCPAddr{IP: s.SrcIP, Port: s.SrcPort}
 	case UDP:
 		return &net.UDPAddr{IP: s.SrcIP, Port: s.SrcPort}
+	case SCTP:
+		return &SCTPAddr{IP: s.SrcIP, Port: s.SrcPort}
+	case ICMP, ICMPv6:
+		return &net.IPAddr{IP: s.SrcIP}
 	default:
 		return nil
 	}
@@ -32,11 +89,29 @@ func (s *Session) DstAddr() net.Addr {
 		return &net.TCPAddr{IP: s.DstIP, Port: s.DstPort}
 	case UDP:
 		return &net.UDPAddr{IP: s.DstIP, Port: s.DstPort}
+	case SCTP:
+		return &SCTPAddr{IP: s.DstIP, Port: s.DstPort}
+	case ICMP, ICMPv6:
+		return &net.IPAddr{IP: s.DstIP}
 	default:
 		return nil
 	}
 }
 
+// SCTPAddr SCTP 端点地址, 标准库未提供 SCTP 地址类型
+type SCTPAddr struct {
+	IP   net.IP
+	Port int
+}
+
+func (a *SCTPAddr) Network() string {
+	return "sctp"
+}
+
+func (a *SCTPAddr) String() string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
 func (s *Session) String() string {
 	buff := bytebufferpool.Get()
 	defer bytebufferpool.Put(buff)
@@ -44,13 +119,23 @@ func (s *Session) String() string {
 	buff.WriteByte('[')
 	buff.WriteString(s.Proto.String())
 	buff.WriteString("] ")
-	buff.WriteString(s.SrcIP.String())
-	buff.WriteByte(':')
-	buff.WriteString(strconv.Itoa(s.SrcPort))
+	writeHostPort(buff, s.SrcIP, s.SrcPort)
 	buff.WriteString(" -> ")
-	buff.WriteString(s.DstIP.String())
-	buff.WriteByte(':')
-	buff.WriteString(strconv.Itoa(s.DstPort))
+	writeHostPort(buff, s.DstIP, s.DstPort)
 
 	return buff.String()
 }
+
+// writeHostPort IPv6地址以[]包裹, 与net.JoinHostPort格式一致
+func writeHostPort(buff *bytebufferpool.ByteBuffer, ip net.IP, port int) {
+	if ip.To4() == nil && len(ip) == net.IPv6len {
+		buff.WriteByte('[')
+		buff.WriteString(ip.String())
+		buff.WriteByte(']')
+	} else {
+		buff.WriteString(ip.String())
+	}
+
+	buff.WriteByte(':')
+	buff.WriteString(strconv.Itoa(port))
+}
@@ -11,6 +11,8 @@ type StreamCache struct {
 	offset int
 	used   int
 	buffer []byte
+	// init 初始容量, 扩容后的缓冲区消费完毕时恢复至该容量
+	init int
 }
 
 // Free 返回底层缓冲区的可用
@@ -36,7 +38,9 @@ func (cache *StreamCache) append(data []byte) {
 	}
 
 	if size > cache.Free() {
-		if len := cache.Len(); size+len <= cache.cap && len <= cache.used {
+		// 剩余数据与新数据可容纳于现有缓冲区时前移剩余数据, 以免小步消费的长会话持续扩容;
+		// copy 支持重叠区间, 因此无需限制剩余数据长度
+		if len := cache.Len(); size+len <= cache.cap {
 			slog.Debug(
 				"moving buffer forward for extra data:",
 				slog.Group(
@@ -117,6 +121,12 @@ func (cache *StreamCache) Rotate(used int, data []byte) {
 
 		cache.used = 0
 		cache.offset = 0
+
+		// 扩容后的缓冲区在数据消费完毕后换回初始大小, 以限制单个会话的内存占用
+		if cache.init > 0 && cache.cap > cache.init {
+			cache.buffer = allocBuffer(cache.init)
+			cache.cap = cache.init
+		}
 	} else {
 		slog.Debug(
 			"used size rotated:",
@@ -171,13 +181,54 @@ func (cache *StreamCache) Merge(data []byte) []byte {
 	return cache.Bytes()
 }
 
+// DefaultStreamCacheSize 数据缓存默认初始容量, 与缓冲池的缓冲区大小一致
+const DefaultStreamCacheSize = pool.MaxBytesSize
+
+// NewStreamCache 创建数据缓存, 底层缓冲区取自缓冲池, 可能残留历史数据,
+// 仅 Bytes 返回的 [used, offset) 区间为有效数据
 func NewStreamCache() *StreamCache {
+	return NewStreamCacheSize(DefaultStreamCacheSize)
+}
+
+// NewStreamCacheSize 创建指定初始容量的数据缓存, size <= 0 时使用 DefaultStreamCacheSize,
+// 仅默认容量的缓冲区取自缓冲池, 数据超出容量时自动扩容
+func NewStreamCacheSize(size int) *StreamCache {
+	if size <= 0 {
+		size = DefaultStreamCacheSize
+	}
+
 	return &StreamCache{
-		cap:    pool.MaxBytesSize,
-		buffer: make([]byte, pool.MaxBytesSize),
+		cap:    size,
+		buffer: allocBuffer(size),
+		init:   size,
 	}
 }
 
+func allocBuffer(size int) []byte {
+	if size == pool.MaxBytesSize {
+		return pool.GetByteSlice()
+	}
+
+	return make([]byte, size)
+}
+
+// Release 归还底层缓冲区至缓冲池, 扩容后的缓冲区不归还以免长期占用内存,
+// 释放后缓存为空, 再次写入时将重新分配
+func (cache *StreamCache) Release() {
+	if cache.buffer == nil {
+		return
+	}
+
+	if cache.cap == pool.MaxBytesSize {
+		pool.PutByteSlice(cache.buffer)
+	}
+
+	cache.buffer = nil
+	cache.cap = 0
+	cache.offset = 0
+	cache.used = 0
+}
+
 var (
 	defaultStreamCaches = map[string]*StreamCache{}
 )
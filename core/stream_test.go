@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"log/slog"
 	"net"
 	"slices"
@@ -52,3 +53,127 @@ func TestStreamCache(t *testing.T) {
 		t.Fatal("extend failed")
 	}
 }
+
+func TestStreamCacheRelease(t *testing.T) {
+	cache := NewStreamCache()
+	cache.Merge([]byte("stale payload"))
+	cache.Release()
+
+	if cache.Len() != 0 || len(cache.Bytes()) != 0 {
+		t.Fatalf("released cache not empty: %q", cache.Bytes())
+	}
+
+	reused := NewStreamCache()
+	if reused.Len() != 0 || len(reused.Bytes()) != 0 {
+		t.Fatalf("reused cache leaks stale data: %q", reused.Bytes())
+	}
+
+	data := []byte("new")
+	if buff := reused.Merge(data); !slices.Equal(buff, data) {
+		t.Fatalf("reused cache merge mismatch: %q", buff)
+	}
+
+	// 释放后的缓存再次写入时重新分配
+	if buff := cache.Merge(data); !slices.Equal(buff, data) {
+		t.Fatalf("released cache merge mismatch: %q", buff)
+	}
+}
+
+func TestStreamCacheCompact(t *testing.T) {
+	cache := NewStreamCache()
+	size := cache.Cap()
+
+	// 剩余数据始终多于已消费数据, 前移后仍可容纳时不应扩容
+	cache.Merge(make([]byte, size*3/4))
+
+	for i := 0; i < size*4; i++ {
+		cache.Rotate(1, []byte{byte(i)})
+	}
+
+	if cache.Cap() != size || cache.Len() != size*3/4 {
+		t.Fatalf("slow consumer cache grown: cap %d, len %d", cache.Cap(), cache.Len())
+	}
+
+	cache.Merge(make([]byte, size))
+	if cache.Cap() <= size {
+		t.Fatalf("cache not extended: %d", cache.Cap())
+	}
+
+	// 扩容后的缓冲区消费完毕后恢复默认大小
+	cache.Rotate(cache.Len(), nil)
+	if cache.Cap() != size {
+		t.Fatalf("extended cache not shrunk: %d", cache.Cap())
+	}
+}
+
+func BenchmarkStreamCacheSlowConsumer(b *testing.B) {
+	slog.SetLogLoggerLevel(slog.LevelInfo)
+
+	cache := NewStreamCache()
+	cache.Merge(make([]byte, cache.Cap()*3/4))
+
+	data := []byte{0}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		cache.Rotate(1, data)
+	}
+
+	b.ReportMetric(float64(cache.Cap()), "cap-bytes")
+}
+
+func TestStreamCacheSize(t *testing.T) {
+	cache := NewStreamCacheSize(16)
+	if cache.Cap() != 16 {
+		t.Fatalf("initial cap mismatch: %d", cache.Cap())
+	}
+
+	data := make([]byte, 100)
+	if buff := cache.Merge(data); len(buff) != len(data) || cache.Cap() < len(data) {
+		t.Fatalf("cache not extended: len %d, cap %d", len(buff), cache.Cap())
+	}
+
+	cache.Rotate(cache.Len(), nil)
+	if cache.Cap() != 16 {
+		t.Fatalf("extended cache not shrunk to initial size: %d", cache.Cap())
+	}
+}
+
+// BenchmarkStreamCacheProfiles 对比不同初始容量在短连接与大流量场景下的开销
+func BenchmarkStreamCacheProfiles(b *testing.B) {
+	slog.SetLogLoggerLevel(slog.LevelInfo)
+
+	profiles := []struct {
+		name    string
+		message int
+		count   int
+	}{
+		// 短连接: 每个会话仅交换少量小报文
+		{"churn", 128, 2},
+		// 大流量: 单个会话持续传输大报文, 消费滞后一个报文
+		{"bulk", 16384, 64},
+	}
+
+	for _, profile := range profiles {
+		for _, size := range []int{512, DefaultStreamCacheSize, 65536} {
+			b.Run(fmt.Sprintf("%s/%d", profile.name, size), func(b *testing.B) {
+				message := make([]byte, profile.message)
+
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					cache := NewStreamCacheSize(size)
+
+					for n := 0; n < profile.count; n++ {
+						buff := cache.Merge(message)
+						cache.Rotate(len(buff)-len(message), nil)
+					}
+
+					cache.Release()
+				}
+			})
+		}
+	}
+}
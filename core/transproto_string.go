@@ -8,21 +8,33 @@ func _() {
 	// An "invalid array index" compiler error signifies that the constant values have changed.
 	// Re-run the stringer command to generate them again.
 	var x [1]struct{}
+	_ = x[ICMP-1]
 	_ = x[TCP-6]
 	_ = x[UDP-17]
+	_ = x[ICMPv6-58]
+	_ = x[SCTP-132]
 }
 
 const (
-	_TransProto_name_0 = "tcp"
-	_TransProto_name_1 = "udp"
+	_TransProto_name_0 = "icmp"
+	_TransProto_name_1 = "tcp"
+	_TransProto_name_2 = "udp"
+	_TransProto_name_3 = "icmpv6"
+	_TransProto_name_4 = "sctp"
 )
 
 func (i TransProto) String() string {
 	switch {
-	case i == 6:
+	case i == 1:
 		return _TransProto_name_0
-	case i == 17:
+	case i == 6:
 		return _TransProto_name_1
+	case i == 17:
+		return _TransProto_name_2
+	case i == 58:
+		return _TransProto_name_3
+	case i == 132:
+		return _TransProto_name_4
 	default:
 		return "TransProto(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -6,10 +6,17 @@ require (
 	github.com/frozenpine/pool v0.0.2
 	github.com/google/gopacket v1.1.19
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.19.1
+	github.com/valyala/bytebufferpool v1.0.0
+	golang.org/x/net v0.20.0
+	golang.org/x/sys v0.17.0
 )
 
 require (
-	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	golang.org/x/net v0.7.0 // indirect
-	golang.org/x/sys v0.5.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
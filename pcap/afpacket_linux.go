@@ -0,0 +1,93 @@
+//go:build linux
+
+package pcap
+
+import (
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+)
+
+// afpacketSource 基于 AF_PACKET mmap 环形缓冲区的实时数据源, 支持零拷贝读取
+type afpacketSource struct {
+	*afpacket.TPacket
+	snapLen int
+}
+
+func openAFPacket(iface string, options *handlerOptions) (PacketSource, error) {
+	tpOpts := []interface{}{
+		afpacket.OptInterface(iface),
+		afpacket.OptPollTimeout(options.timeout),
+	}
+
+	if options.frameSize > 0 {
+		tpOpts = append(tpOpts, afpacket.OptFrameSize(options.frameSize))
+	}
+
+	if options.blockSize > 0 {
+		tpOpts = append(tpOpts, afpacket.OptBlockSize(options.blockSize))
+	}
+
+	if options.numBlocks > 0 {
+		tpOpts = append(tpOpts, afpacket.OptNumBlocks(options.numBlocks))
+	}
+
+	handle, err := afpacket.NewTPacket(tpOpts...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if options.fanout {
+		if err := handle.SetFanout(afpacket.FanoutHash, options.fanoutGroup); err != nil {
+			handle.Close()
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return &afpacketSource{TPacket: handle, snapLen: options.snapLen}, nil
+}
+
+// LinkType AF_PACKET 原始套接字返回包含 Ethernet 头的数据包
+func (src *afpacketSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// SetBPFFilter 使用 libpcap 编译过滤表达式后加载至内核, expr 为空时加载接受全部数据包的程序
+func (src *afpacketSource) SetBPFFilter(expr string) error {
+	if expr == "" {
+		raw, err := bpf.Assemble([]bpf.Instruction{bpf.RetConstant{Val: uint32(src.snapLen)}})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return errors.WithStack(src.TPacket.SetBPF(raw))
+	}
+
+	insts, err := libpcap.CompileBPFFilter(src.LinkType(), src.snapLen, expr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return src.SetBPFInstructionFilter(insts)
+}
+
+// SetBPFInstructionFilter 将预编译的过滤程序加载至内核, 程序需以 Ethernet 链路类型编译
+func (src *afpacketSource) SetBPFInstructionFilter(insts []libpcap.BPFInstruction) error {
+	return errors.WithStack(src.TPacket.SetBPF(rawInstructions(insts)))
+}
+
+// Stats 返回套接字累计统计信息, 仅包含接收及丢包数量
+func (src *afpacketSource) Stats() (*Stats, error) {
+	v2, v3, err := src.SocketStats()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// 统计结果仅在对应的 TPacket 版本中有效, 另一版本始终为零
+	return &Stats{
+		PacketsReceived: int(v2.Packets() + v3.Packets()),
+		PacketsDropped:  int(v2.Drops() + v3.Drops()),
+	}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package pcap
+
+import "github.com/pkg/errors"
+
+func openAFPacket(string, *handlerOptions) (PacketSource, error) {
+	return nil, errors.New("afpacket data source is only supported on linux")
+}
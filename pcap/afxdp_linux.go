@@ -0,0 +1,412 @@
+//go:build linux && afxdp
+
+package pcap
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// xdpFrameSize UMEM 单帧大小, 需为 2 的幂, 扣除 XDP 头部空间后约可接收 3.8KB 的帧, 不支持更大的巨帧
+	xdpFrameSize = 4096
+	// xdpNumFrames UMEM 帧数量, 同时作为填充环及接收环的长度
+	xdpNumFrames = 4096
+
+	// xdpPollInterval 单次等待接收环的最长时间, 读取在每次等待后检查数据源是否已关闭, 以限制 Close 的等待时间
+	xdpPollInterval = 100 * time.Millisecond
+
+	// bpfRedirectMap bpf_redirect_map 辅助函数编号
+	bpfRedirectMap = 51
+	// xdpPass 重定向失败时的默认动作, 交由内核协议栈处理
+	xdpPass = 2
+)
+
+// afxdpSource 基于 AF_XDP 套接字的实时数据源, 以 XDP 程序将接口单个接收队列的帧重定向至用户态 UMEM,
+// 绕过内核协议栈及 libpcap, 支持零拷贝读取; 未重定向的队列及重定向失败的帧仍交由内核协议栈处理
+type afxdpSource struct {
+	fd      int
+	umem    []byte
+	rx      xdpRing
+	fill    xdpRing
+	snapLen int
+	timeout time.Duration
+
+	// xsks, prog 及 link 为 XSKMAP, XDP 程序及其挂载的文件描述符
+	xsks, prog, link int
+
+	// frame 上次读取的帧地址, 下次读取时归还至填充环
+	frame    uint64
+	held     bool
+	received atomic.Uint64
+
+	// mu 串行化读取与 Close, 确保 Close 解除映射前进行中的读取已返回; closed 标记数据源已关闭
+	mu        sync.Mutex
+	closed    atomic.Bool
+	closeOnce sync.Once
+}
+
+// xdpRing AF_XDP 单生产者单消费者环形队列, 索引及描述符位于与内核共享的内存中
+type xdpRing struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	descs    unsafe.Pointer
+	mask     uint32
+}
+
+func mapRing(fd int, offset int64, off unix.XDPRingOffset, descSize uintptr) (xdpRing, error) {
+	mem, err := unix.Mmap(
+		fd, offset, int(off.Desc)+xdpNumFrames*int(descSize),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE,
+	)
+	if err != nil {
+		return xdpRing{}, errors.WithStack(err)
+	}
+
+	return xdpRing{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Pointer(&mem[off.Producer])),
+		consumer: (*uint32)(unsafe.Pointer(&mem[off.Consumer])),
+		descs:    unsafe.Pointer(&mem[off.Desc]),
+		mask:     xdpNumFrames - 1,
+	}, nil
+}
+
+// openAFXDP 打开接口 iface 指定接收队列的 AF_XDP 套接字, 并挂载将该队列重定向至套接字的 XDP 程序,
+// 驱动不支持原生 XDP 时内核以通用模式挂载
+func openAFXDP(iface string, options *handlerOptions) (PacketSource, error) {
+	dev, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	src := afxdpSource{
+		fd: -1, xsks: -1, prog: -1, link: -1,
+		snapLen: options.snapLen,
+		timeout: options.timeout,
+	}
+
+	if err := src.open(dev.Index, options.xdpQueue); err != nil {
+		src.Close()
+		return nil, err
+	}
+
+	return &src, nil
+}
+
+func (src *afxdpSource) open(ifindex, queue int) (err error) {
+	if src.fd, err = unix.Socket(unix.AF_XDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0); err != nil {
+		return errors.Wrap(err, "create xdp socket failed")
+	}
+
+	if src.umem, err = unix.Mmap(
+		-1, 0, xdpNumFrames*xdpFrameSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE,
+	); err != nil {
+		return errors.Wrap(err, "allocate umem failed")
+	}
+
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&src.umem[0]))),
+		Len:  uint64(len(src.umem)),
+		Size: xdpFrameSize,
+	}
+
+	if err = setsockoptXDP(src.fd, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return errors.Wrap(err, "register umem failed")
+	}
+
+	// 完成环仅用于发送, 内核要求绑定前同时设置
+	for _, opt := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING} {
+		if err = unix.SetsockoptInt(src.fd, unix.SOL_XDP, opt, xdpNumFrames); err != nil {
+			return errors.Wrap(err, "set xdp ring size failed")
+		}
+	}
+
+	var off unix.XDPMmapOffsets
+	size := uint32(unsafe.Sizeof(off))
+
+	if _, _, errno := unix.Syscall6(
+		unix.SYS_GETSOCKOPT, uintptr(src.fd), unix.SOL_XDP, unix.XDP_MMAP_OFFSETS,
+		uintptr(unsafe.Pointer(&off)), uintptr(unsafe.Pointer(&size)), 0,
+	); errno != 0 {
+		return errors.Wrap(errno, "get xdp ring offsets failed")
+	}
+
+	if src.rx, err = mapRing(src.fd, unix.XDP_PGOFF_RX_RING, off.Rx, unsafe.Sizeof(unix.XDPDesc{})); err != nil {
+		return err
+	}
+
+	if src.fill, err = mapRing(src.fd, unix.XDP_UMEM_PGOFF_FILL_RING, off.Fr, unsafe.Sizeof(uint64(0))); err != nil {
+		return err
+	}
+
+	// 全部帧交由内核接收
+	for idx := uint32(0); idx < xdpNumFrames; idx++ {
+		*src.fill.addr(idx) = uint64(idx) * xdpFrameSize
+	}
+	atomic.StoreUint32(src.fill.producer, xdpNumFrames)
+
+	if err = unix.Bind(src.fd, &unix.SockaddrXDP{Ifindex: uint32(ifindex), QueueID: uint32(queue)}); err != nil {
+		return errors.Wrap(err, "bind xdp socket failed")
+	}
+
+	return src.attach(ifindex, queue)
+}
+
+// attach 创建 XSKMAP 并挂载 XDP 程序, 程序按接收队列查找套接字重定向, 未找到时交由内核协议栈
+func (src *afxdpSource) attach(ifindex, queue int) (err error) {
+	mapAttr := struct {
+		mapType, keySize, valueSize, maxEntries, flags uint32
+	}{unix.BPF_MAP_TYPE_XSKMAP, 4, 4, uint32(queue + 1), 0}
+
+	if src.xsks, err = bpfSyscall(unix.BPF_MAP_CREATE, unsafe.Pointer(&mapAttr), unsafe.Sizeof(mapAttr)); err != nil {
+		return errors.Wrap(err, "create xskmap failed")
+	}
+
+	key, value := uint32(queue), uint32(src.fd)
+	updateAttr := struct {
+		fd          uint32
+		_           uint32
+		key, value  bpfPointer
+		updateFlags uint64
+	}{fd: uint32(src.xsks), key: bpfPointer{ptr: unsafe.Pointer(&key)}, value: bpfPointer{ptr: unsafe.Pointer(&value)}}
+
+	if _, err = bpfSyscall(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&updateAttr), unsafe.Sizeof(updateAttr)); err != nil {
+		return errors.Wrap(err, "register xdp socket failed")
+	}
+
+	// return bpf_redirect_map(&xsks, ctx->rx_queue_index, XDP_PASS)
+	insts := []bpfInsn{
+		{code: 0x61, regs: 0x12, off: 16},                                          // r2 = *(u32 *)(r1 + 16)
+		{code: 0x18, regs: 0x01 | unix.BPF_PSEUDO_MAP_FD<<4, imm: int32(src.xsks)}, // r1 = xsks
+		{},
+		{code: 0xb7, regs: 0x03, imm: xdpPass}, // r3 = XDP_PASS
+		{code: 0x85, imm: bpfRedirectMap},      // call bpf_redirect_map
+		{code: 0x95},                           // exit
+	}
+	license := []byte("GPL\x00")
+
+	progAttr := struct {
+		progType, insnCnt       uint32
+		insns, license          bpfPointer
+		logLevel, logSize       uint32
+		logBuf                  bpfPointer
+		kernVersion, progFlags  uint32
+		name                    [16]byte
+		ifindex, expectedAttach uint32
+	}{
+		progType: unix.BPF_PROG_TYPE_XDP,
+		insnCnt:  uint32(len(insts)),
+		insns:    bpfPointer{ptr: unsafe.Pointer(&insts[0])},
+		license:  bpfPointer{ptr: unsafe.Pointer(&license[0])},
+		name:     [16]byte{'p', 'k', 't', '4', 'g', 'o', '_', 'x', 's', 'k'},
+	}
+
+	if src.prog, err = bpfSyscall(unix.BPF_PROG_LOAD, unsafe.Pointer(&progAttr), unsafe.Sizeof(progAttr)); err != nil {
+		return errors.Wrap(err, "load xdp program failed")
+	}
+
+	linkAttr := struct {
+		progFd, targetIfindex, attachType, flags uint32
+	}{uint32(src.prog), uint32(ifindex), unix.BPF_XDP, 0}
+
+	if src.link, err = bpfSyscall(unix.BPF_LINK_CREATE, unsafe.Pointer(&linkAttr), unsafe.Sizeof(linkAttr)); err != nil {
+		return errors.Wrap(err, "attach xdp program failed")
+	}
+
+	return nil
+}
+
+// bpfPointer bpf_attr 中的 64 位指针字段, 以 unsafe.Pointer 保存使所指内存在系统调用期间有效
+type bpfPointer struct {
+	ptr unsafe.Pointer
+	_   [8 - unsafe.Sizeof(unsafe.Pointer(nil))]byte
+}
+
+// bpfInsn eBPF 指令, regs 低 4 位为目的寄存器, 高 4 位为源寄存器
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+func bpfSyscall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(fd), nil
+}
+
+func setsockoptXDP(fd, opt int, value unsafe.Pointer, size uintptr) error {
+	if _, _, errno := unix.Syscall6(
+		unix.SYS_SETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(opt), uintptr(value), size, 0,
+	); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func (r *xdpRing) addr(idx uint32) *uint64 {
+	return (*uint64)(unsafe.Add(r.descs, uintptr(idx&r.mask)*unsafe.Sizeof(uint64(0))))
+}
+
+func (r *xdpRing) desc(idx uint32) *unix.XDPDesc {
+	return (*unix.XDPDesc)(unsafe.Add(r.descs, uintptr(idx&r.mask)*unsafe.Sizeof(unix.XDPDesc{})))
+}
+
+func (src *afxdpSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	data, ci, err := src.read()
+
+	return slices.Clone(data), ci, err
+}
+
+// ZeroCopyReadPacketData 返回 UMEM 中的帧, 下次读取时帧归还内核, 接收环为空时等待至读超时并返回 EAGAIN,
+// 数据源关闭后返回 EBADF
+func (src *afxdpSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	return src.read()
+}
+
+func (src *afxdpSource) read() ([]byte, gopacket.CaptureInfo, error) {
+	if src.closed.Load() {
+		return nil, gopacket.CaptureInfo{}, unix.EBADF
+	}
+
+	src.recycle()
+
+	deadline := time.Now().Add(src.timeout)
+
+	for {
+		cons := atomic.LoadUint32(src.rx.consumer)
+
+		if atomic.LoadUint32(src.rx.producer) != cons {
+			desc := *src.rx.desc(cons)
+			atomic.StoreUint32(src.rx.consumer, cons+1)
+
+			src.frame, src.held = desc.Addr, true
+			src.received.Add(1)
+
+			data := src.umem[desc.Addr : desc.Addr+uint64(desc.Len)]
+			ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+
+			if len(data) > src.snapLen {
+				data, ci.CaptureLength = data[:src.snapLen], src.snapLen
+			}
+
+			return data, ci, nil
+		}
+
+		// 读超时 < 0 时持续等待
+		wait := xdpPollInterval
+		if src.timeout >= 0 {
+			if wait = min(time.Until(deadline), wait); wait <= 0 {
+				return nil, gopacket.CaptureInfo{}, unix.EAGAIN
+			}
+		}
+
+		fds := []unix.PollFd{{Fd: int32(src.fd), Events: unix.POLLIN}}
+
+		n, err := unix.Poll(fds, int(wait.Milliseconds()))
+		switch {
+		case src.closed.Load():
+			return nil, gopacket.CaptureInfo{}, unix.EBADF
+		case err == unix.EINTR || n == 0:
+			continue
+		case err != nil:
+			return nil, gopacket.CaptureInfo{}, errors.WithStack(err)
+		case fds[0].Revents&(unix.POLLERR|unix.POLLHUP|unix.POLLNVAL) != 0:
+			return nil, gopacket.CaptureInfo{}, unix.EBADF
+		}
+	}
+}
+
+// recycle 将上次读取的帧归还至填充环, 帧地址按帧大小对齐得到帧起始地址
+func (src *afxdpSource) recycle() {
+	if !src.held {
+		return
+	}
+
+	prod := atomic.LoadUint32(src.fill.producer)
+	*src.fill.addr(prod) = src.frame &^ (xdpFrameSize - 1)
+	atomic.StoreUint32(src.fill.producer, prod+1)
+
+	src.held = false
+}
+
+// LinkType AF_XDP 接收包含 Ethernet 头的完整帧
+func (src *afxdpSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// SnapLen 返回单个数据包最大抓取长度
+func (src *afxdpSource) SnapLen() int {
+	return src.snapLen
+}
+
+// Stats 返回接收数量及套接字统计的丢包数量, PacketsIfDropped 为填充环为空导致的丢包
+func (src *afxdpSource) Stats() (*Stats, error) {
+	if src.closed.Load() {
+		return nil, errors.WithStack(unix.EBADF)
+	}
+
+	var stats unix.XDPStatistics
+	size := uint32(unsafe.Sizeof(stats))
+
+	if _, _, errno := unix.Syscall6(
+		unix.SYS_GETSOCKOPT, uintptr(src.fd), unix.SOL_XDP, unix.XDP_STATISTICS,
+		uintptr(unsafe.Pointer(&stats)), uintptr(unsafe.Pointer(&size)), 0,
+	); errno != 0 {
+		return nil, errors.WithStack(errno)
+	}
+
+	return &Stats{
+		PacketsReceived:  int(src.received.Load()),
+		PacketsDropped:   int(stats.Rx_dropped + stats.Rx_ring_full),
+		PacketsIfDropped: int(stats.Rx_fill_ring_empty_descs),
+	}, nil
+}
+
+// Close 卸载 XDP 程序并释放套接字及 UMEM, 等待进行中的读取返回后解除映射,
+// 调用方在读取返回后仍持有的零拷贝数据随之失效
+func (src *afxdpSource) Close() error {
+	src.closed.Store(true)
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	src.closeOnce.Do(func() {
+		for _, fd := range []int{src.link, src.prog, src.xsks, src.fd} {
+			if fd >= 0 {
+				unix.Close(fd)
+			}
+		}
+
+		for _, mem := range [][]byte{src.rx.mem, src.fill.mem, src.umem} {
+			if mem != nil {
+				unix.Munmap(mem)
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux || !afxdp
+
+package pcap
+
+import "github.com/pkg/errors"
+
+func openAFXDP(string, *handlerOptions) (PacketSource, error) {
+	return nil, errors.New("afxdp data source requires linux and the afxdp build tag")
+}
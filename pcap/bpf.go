@@ -0,0 +1,37 @@
+package pcap
+
+import (
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+)
+
+// bpfProgramSetter 支持加载预编译 BPF 程序的数据源, *libpcap.Handle 满足该接口
+type bpfProgramSetter interface {
+	SetBPFInstructionFilter(insts []libpcap.BPFInstruction) error
+}
+
+// rawInstructions 转换为 x/net/bpf 的原始指令
+func rawInstructions(insts []libpcap.BPFInstruction) []bpf.RawInstruction {
+	raw := make([]bpf.RawInstruction, len(insts))
+	for idx, inst := range insts {
+		raw[idx] = bpf.RawInstruction{Op: inst.Code, Jt: inst.Jt, Jf: inst.Jf, K: inst.K}
+	}
+
+	return raw
+}
+
+// newBPFVM 创建进程内执行预编译程序的虚拟机, 无需 libpcap
+func newBPFVM(insts []libpcap.BPFInstruction) (*bpf.VM, error) {
+	program, ok := bpf.Disassemble(rawInstructions(insts))
+	if !ok {
+		return nil, errors.New("bpf program contains unknown instruction")
+	}
+
+	vm, err := bpf.NewVM(program)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return vm, nil
+}
@@ -2,10 +2,15 @@ package pcap
 
 import (
 	"context"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
-	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/frozenpine/pkt4go/core"
@@ -15,152 +20,1229 @@ import (
 	"github.com/pkg/errors"
 )
 
-var (
-	dataSourcePattern = regexp.MustCompile(`^(?P<proto>pcap|file)://(?P<source>.*)$`)
-	// sessionCache = map[string]
-)
+// CreateHandler 根据数据源创建抓包句柄, 数据源格式为 pcap://<iface|ip>, afpacket://<iface|ip>, afxdp://<iface|ip>, tcppcap://<host:port>[/iface] 或 file://<path>,
+// afpacket:// 仅支持 Linux, 以 AF_PACKET mmap 环形缓冲区抓包, 单包开销低于 libpcap;
+// afxdp:// 为实验性功能, 需以 afxdp 构建标签编译, 以 AF_XDP 套接字接收 WithXDPQueue 指定的单个接收队列,
+// 要求 Linux 5.9 及以上内核及 CAP_NET_ADMIN, CAP_BPF (或 CAP_SYS_ADMIN) 权限, 驱动不支持原生 XDP 时以通用模式运行;
+// tcppcap:// 连接远程抓包服务, 随到达读取其发送的 pcap 数据流; 离线文件支持 pcap 及 pcapng 格式, 路径包含通配符时按文件名顺序连续读取全部匹配文件;
+// 其他协议可由 RegisterScheme 注册
+func CreateHandler(dataSrc string, opts ...HandlerOption) (handle PacketSource, err error) {
+	proto, source, ok := splitScheme(dataSrc)
+	if !ok {
+		return nil, errors.WithStack(fmt.Errorf("%w: %s", ErrInvalidDataSource, dataSrc))
+	}
 
-func CreateHandler(dataSrc string) (handle *libpcap.Handle, err error) {
-	srcMatch := dataSourcePattern.FindStringSubmatch(dataSrc)
-	if srcMatch == nil {
-		return nil, errors.New("invalid data source: " + dataSrc)
+	if proto == "pcap" {
+		if err := checkNpcap(); err != nil {
+			return nil, err
+		}
 	}
 
-	var proto, source string
+	options := newHandlerOptions(opts...)
 
-	for idx, name := range dataSourcePattern.SubexpNames() {
-		switch name {
-		case "proto":
-			proto = srcMatch[idx]
-		case "source":
-			source = srcMatch[idx]
+	// 实时抓包数据源为 IP 地址时, 查找该地址所在的接口, Windows 下为 \Device\NPF_{GUID} 形式的设备名
+	if proto == "pcap" || proto == "afpacket" || proto == "afxdp" {
+		if ip := net.ParseIP(source); ip != nil && !options.deviceName {
+			if source, err = lookupInterface(proto, ip); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	// Find inteface name if source is an IP address
-	if ip, err := net.ResolveIPAddr("ip", source); err == nil {
-		ifaceList, err := libpcap.FindAllDevs()
-		if err != nil {
-			return nil, errors.WithStack(err)
+	open, exist := lookupScheme(proto)
+	if !exist {
+		return nil, errors.WithStack(fmt.Errorf("%w: %s", ErrUnknownProtocol, proto))
+	}
+
+	if handle, err = open(source, opts...); err != nil {
+		return nil, err
+	}
+
+	if options.info != nil {
+		device := source
+		if proto == "tcppcap" {
+			_, device, _ = strings.Cut(source, "/")
 		}
 
-	FIND_IFACE:
-		for _, iface := range ifaceList {
-			for _, addr := range iface.Addresses {
-				if addr.IP.Equal(ip.IP) {
-					source = iface.Name
-					break FIND_IFACE
-				}
-			}
+		*options.info = *describeSource(proto, device, handle, options)
+	}
+
+	return
+}
+
+// StartCapture 开始抓包, 将传输层数据按会话合并后交由 fn 处理,
+// 网络层通过解码结果查找, 不依赖具体的链路层类型(Ethernet, Linux SLL 等);
+// filter 为空时不设置 BPF 过滤, 抓取全部流量
+func StartCapture(ctx context.Context, handler PacketSource, filter string, fn core.DataHandler, opts ...CaptureOption) error {
+	c, err := NewCapture(handler, filter, opts...)
+	if err != nil {
+		c.close()
+		return err
+	}
+
+	return c.Run(ctx, fn)
+}
+
+// StartPacketCapture 与 StartCapture 相同, 以 PacketHandler 接收会话数据及元数据
+func StartPacketCapture(ctx context.Context, handler PacketSource, filter string, fn core.PacketHandler, opts ...CaptureOption) error {
+	c, err := NewCapture(handler, filter, opts...)
+	if err != nil {
+		c.close()
+		return err
+	}
+
+	return c.RunPacket(ctx, fn)
+}
+
+// Capture 抓包会话, 持有数据源及抓包参数, 可在抓包过程中动态更新 BPF 过滤, 读取统计或停止抓包;
+// StartCapture 等函数均以 Capture 实现
+type Capture struct {
+	source  PacketSource
+	reader  PacketSource
+	filter  bpfSetter
+	decoder gopacket.Decoder
+	opts    *captureOptions
+	mu      sync.Mutex
+
+	// rotation WithPcapRotation 的轮转写入, 每次 Run 结束时关闭当前文件
+	rotation *rotateWriter
+
+	// halted 在 Stop 时结束, 用于结束进行中或尚未开始的 Run
+	halted context.Context
+	halt   context.CancelFunc
+}
+
+// NewCapture 创建抓包会话, filter 为空时不调用数据源的 SetBPFFilter, 保留数据源当前的过滤状态,
+// 新建的数据源即抓取全部流量; 数据源不支持内核过滤时在进程内过滤
+func NewCapture(handler PacketSource, filter string, opts ...CaptureOption) (capture *Capture, err error) {
+	if err := checkSource(handler); err != nil {
+		return &Capture{opts: newCaptureOptions(opts...)}, err
+	}
+
+	c := Capture{
+		source: handler,
+		reader: handler,
+		opts:   newCaptureOptions(opts...),
+	}
+	c.halted, c.halt = context.WithCancel(context.Background())
+
+	// 数据源状态异常时 gopacket 可能在初始化过程中 panic, 此时不再关闭数据源
+	defer func() {
+		if r := recover(); r != nil {
+			capture = &Capture{opts: c.opts}
+			err = errors.Errorf("init packet source failed: %v", r)
 		}
+	}()
+
+	c.decoder = packetDecoder(handler, c.opts.logger)
+	if c.opts.decoder != nil {
+		c.decoder = c.opts.decoder
+	}
+
+	if setter, ok := handler.(bpfSetter); ok {
+		c.filter = setter
+	} else {
+		src := newFilterSource(handler)
+		c.reader, c.filter = src, src
 	}
 
-	switch proto {
-	case "pcap":
-		if handle, err = libpcap.OpenLive(source, 65535, true, time.Hour); err != nil {
-			return nil, errors.WithStack(err)
+	if c.opts.bpfProgram != nil {
+		if filter != "" {
+			return &c, errors.New("bpf filter and bpf program are mutually exclusive")
 		}
-	case "file":
-		if handle, err = libpcap.OpenOffline(source); err != nil {
-			return nil, errors.WithStack(err)
+
+		if err := c.SetFilterProgram(c.opts.bpfProgram); err != nil {
+			return &c, err
 		}
-	default:
-		return nil, errors.New("unknown pcap protocol: " + proto)
 	}
 
-	return
-}
+	if c.opts.routes != nil {
+		if err := c.opts.routes.compile(handler.LinkType()); err != nil {
+			return &c, err
+		}
+
+		if filter == "" && c.opts.bpfProgram == nil {
+			filter = c.opts.routes.union()
+		}
+	}
 
-func StartCapture(ctx context.Context, handler *libpcap.Handle, filter string, fn core.DataHandler) (err error) {
 	if filter != "" {
-		if err := handler.SetBPFFilter(filter); err != nil {
-			return errors.WithStack(err)
+		if err := c.SetFilter(filter); err != nil {
+			return &c, err
 		}
 	}
 
+	if c.opts.pcapWriter != nil {
+		src, err := newTeeSource(c.reader, c.opts.pcapWriter, c.opts.logger)
+		if err != nil {
+			return &c, err
+		}
+
+		c.reader = src
+	}
+
+	if c.opts.rotation != nil {
+		c.reader, c.rotation = newRotateSource(c.reader, c.opts.rotation, c.opts.logger)
+	}
+
+	return &c, nil
+}
+
+// SetFilter 更新 BPF 过滤, 可在抓包过程中调用, 对后续读取的数据包生效,
+// 编译失败时返回的错误包含过滤表达式及链路类型
+func (c *Capture) SetFilter(expr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.filter.SetBPFFilter(expr); err != nil {
+		return errors.Wrapf(
+			err, "invalid bpf filter %q for link type %s",
+			expr, c.source.LinkType(),
+		)
+	}
+
+	return nil
+}
+
+// SetFilterProgram 以预编译的 BPF 程序更新过滤, 可在抓包过程中调用, 替代 SetFilter 设置的过滤表达式;
+// 程序需以数据源的链路类型编译, 如 libpcap.CompileBPFFilter(handler.LinkType(), DefaultSnapLen, expr),
+// 在进程内过滤时对全部数据包执行, 不区分多接口文件中各数据包的链路类型
+func (c *Capture) SetFilterProgram(insts []libpcap.BPFInstruction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	setter, ok := c.filter.(bpfProgramSetter)
+	if !ok {
+		return errors.New("packet source does not support bpf program")
+	}
+
+	if err := setter.SetBPFInstructionFilter(insts); err != nil {
+		return errors.Wrapf(err, "invalid bpf program for link type %s", c.source.LinkType())
+	}
+
+	return nil
+}
+
+// Stop 停止抓包, 与 ctx 结束相同, 已缓存的会话数据将交付后 Run 返回; 在 Run 之前调用时 Run 将立即结束
+func (c *Capture) Stop() {
+	if c.halt != nil {
+		c.halt()
+	}
+}
+
+// Run 开始抓包直至 ctx 结束, 调用 Stop, 数据源读取完毕或 fn 返回错误, fn 返回 io.EOF 时正常结束
+func (c *Capture) Run(ctx context.Context, fn core.DataHandler) error {
+	return c.run(ctx, fn, c.opts)
+}
+
+// RunPacket 与 Run 相同, 以 PacketHandler 接收会话数据
+func (c *Capture) RunPacket(ctx context.Context, fn core.PacketHandler) error {
+	opts := *c.opts
+	opts.packetFn = fn
+
+	return c.run(ctx, nil, &opts)
+}
+
+func (c *Capture) run(ctx context.Context, fn core.DataHandler, opts *captureOptions) (err error) {
+	defer c.close()
+	defer c.rotation.close()
+
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
-	packets := gopacket.NewPacketSource(handler, handler.LinkType()).Packets()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if c.halted != nil {
+		defer context.AfterFunc(c.halted, cancel)()
+	}
+
+	if opts.statsFn != nil {
+		if src, ok := c.source.(statsReader); ok {
+			statsCtx, stop := context.WithCancel(ctx)
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				reportStats(statsCtx, src, opts)
+			}()
+
+			defer func() {
+				stop()
+				<-done
+			}()
+		} else {
+			opts.logger.Warn("packet source does not support stats, report skipped:")
+		}
+	}
+
+	reader := c.reader
+	if !opts.rangeStart.IsZero() || !opts.rangeEnd.IsZero() {
+		reader = newRangeSource(reader, opts.rangeStart, opts.rangeEnd)
+	}
+
+	if opts.replay > 0 {
+		reader = newReplaySource(ctx, reader, opts.replay)
+	}
+
+	if opts.result != nil {
+		counter := newCountSource(reader)
+		reader = counter
+
+		var stopped atomic.Bool
+		opts.stopped = &stopped
+
+		defer func() {
+			counter.report(ctx, err, stopped.Load(), opts.result)
+		}()
+	}
+
+	if opts.zeroCopy {
+		if src, ok := reader.(zeroCopySource); ok && opts.workers <= 1 && opts.decoder == nil {
+			return c.failed(newCapturer(fn, opts).runZeroCopy(ctx, src, c.source.LinkType()))
+		}
+
+		opts.logger.Warn("zero copy is not available, fallback to packet source:")
+	}
+
+	return c.failed(capture(
+		ctx,
+		packetQueue(ctx, gopacket.NewPacketSource(reader, c.decoder), opts.queueSize, opts.observer),
+		fn, opts,
+	))
+}
+
+// failedSource 可记录不可恢复错误的数据源, 读取时返回的错误无法经由读取协程传递
+type failedSource interface {
+	failure() error
+}
+
+// failed 抓包正常结束时返回数据源记录的不可恢复错误
+func (c *Capture) failed(err error) error {
+	if src, ok := c.source.(failedSource); ok && err == nil {
+		return src.failure()
+	}
+
+	return err
+}
+
+// close 开启 WithCloseOnExit 时关闭数据源
+func (c *Capture) close() {
+	if !c.opts.closeOnExit {
+		return
+	}
+
+	if err := closeSource(c.source); err != nil {
+		c.opts.logger.Warn(
+			"close packet source failed:",
+			slog.Any("error", err),
+		)
+	}
+}
+
+func capture(ctx context.Context, packets <-chan gopacket.Packet, fn core.DataHandler, opts *captureOptions) error {
+	if opts.workers > 1 {
+		return captureParallel(ctx, packets, fn, opts)
+	}
+
+	return newCapturer(fn, opts).run(ctx, packets)
+}
+
+type capturer struct {
+	fn    core.DataHandler
+	pfn   core.PacketHandler
+	pkt   core.Packet
+	opts  *captureOptions
+	flows *flowTable
+
+	defrag *defragmenter
+	dedup  *deduplicator
+	// ipID 当前数据包的 IPv4 Identification 或 IPv6 Flow Label, 供 WithDedup 区分重复发送的报文
+	ipID uint32
+
+	// tracked 多个工作协程共享的会话计数, 单协程时为 nil
+	tracked *atomic.Int64
+	last    int
+	// parent 工作协程的外部抓包上下文, 用于在队列关闭时判断是否因取消而结束, 单协程时为 nil
+	parent context.Context
+
+	truncated bool
+	// packets 读取的数据包计数, 用于按数据包抽样
+	packets uint64
+}
+
+func newCapturer(fn core.DataHandler, opts *captureOptions) *capturer {
+	c := capturer{
+		fn:    fn,
+		pfn:   opts.packetFn,
+		opts:  opts,
+		flows: newFlowTable(opts),
+	}
+
+	if opts.defrag > 0 {
+		c.defrag = newDefragmenter(opts.defrag)
+	}
+
+	if opts.dedup > 0 {
+		c.dedup = newDeduplicator(opts.dedup)
+	}
+
+	return &c
+}
+
+func (c *capturer) run(ctx context.Context, packets <-chan gopacket.Packet) error {
+	defer c.flows.Clear(CloseCaptureEnd)
+
+	var sweep <-chan time.Time
+	if interval := c.flows.SweepInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		sweep = ticker.C
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			return nil
+			return c.flush(c.opts.onFlush)
+		case now := <-sweep:
+			c.flows.Sweep(now)
 		case pkg := <-packets:
 			if pkg == nil {
-				return nil
+				// 读取协程在 ctx 结束时关闭队列
+				if ctx.Err() != nil || c.parent != nil && c.parent.Err() != nil {
+					return c.flush(c.opts.onFlush)
+				}
+
+				return c.flush(c.opts.onEOF)
 			}
 
-			if fn == nil {
+			if c.opts.rawHook != nil && !c.opts.rawHook(pkg) {
 				continue
 			}
 
-			ip := pkg.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
-			if ip == nil {
-				slog.Error("captured is not a valid IPv4 packet.")
+			if !c.samplePacket() || c.discard() {
 				continue
 			}
 
-			var (
-				session *core.Session
-				buffer  []byte
-				cache   *core.StreamCache
-			)
+			c.opts.observer.PacketProcessed(pkg.Metadata().CaptureLength)
+			c.checkTruncated(pkg.Metadata().CaptureInfo)
+			c.checkDecoded(pkg)
+			c.countTraffic(pkg)
+			c.flows.route = c.matchRoute(pkg.Metadata().CaptureInfo, pkg.Data())
 
-			switch ip.NextLayerType() {
-			case layers.LayerTypeTCP:
-				tcp, _ := pkg.Layer(layers.LayerTypeTCP).(*layers.TCP)
+			err := c.handlePacket(pkg)
 
-				if len(tcp.Payload) <= 0 {
-					continue
-				}
+			c.opts.observer.SessionsTracked(c.sessions())
 
-				session = &core.Session{
-					Proto:   core.TCP,
-					SrcIP:   ip.SrcIP,
-					SrcPort: int(tcp.SrcPort),
-					DstIP:   ip.DstIP,
-					DstPort: int(tcp.DstPort),
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					c.stopped()
+					return nil
 				}
 
-				cache = core.GetStreamCache(session)
-				buffer = cache.Merge(tcp.Payload)
-			case layers.LayerTypeUDP:
-				udp, _ := pkg.Layer(layers.LayerTypeUDP).(*layers.UDP)
+				return err
+			}
+		}
+	}
+}
 
-				if len(udp.Payload) <= 0 {
-					continue
-				}
+// TruncatedHandler 数据包截断回调, ci 中 CaptureLength 小于 Length
+type TruncatedHandler func(ci gopacket.CaptureInfo)
 
-				session = &core.Session{
-					Proto:   core.UDP,
-					SrcIP:   ip.SrcIP,
-					SrcPort: int(udp.SrcPort),
-					DstIP:   ip.DstIP,
-					DstPort: int(udp.DstPort),
-				}
+// RawPacketHook 原始数据包回调, 返回 false 时跳过该数据包
+type RawPacketHook func(pkg gopacket.Packet) bool
+
+// flush 将会话缓存中的剩余数据交由 fn, 抓包取消时为 WithFlushOnCancel 设置的处理函数,
+// 数据源读取完毕时为 WithFlushOnEOF 设置的处理函数
+func (c *capturer) flush(fn core.DataHandler) error {
+	if fn == nil {
+		return nil
+	}
+
+	var err error
+
+	c.flows.Each(func(f *flow) bool {
+		// 乱序缓存中的数据跳过空洞后合并入会话缓存, 结束时不再受 WithMaxSessionBuffer 限制
+		c.skipped(f, f.stream.Drain(func(data []byte) {
+			c.reassembled(f, len(data))
+			f.cache.Merge(data)
+		}))
+
+		if f.cache.Len() <= 0 {
+			return true
+		}
 
-				buffer = udp.Payload
-			default:
-				slog.Error(
-					"unsupported transport layer:",
-					slog.String("layer", ip.NextLayerType().String()),
+		data := f.cache.Bytes()
+		if c.opts.copyPayload {
+			data = slices.Clone(data)
+		}
+
+		if _, err = fn(f.session, f.lastPacket, data); err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.opts.logger.Error(
+					"flush session data failed:",
+					slog.String("session", f.key),
+					slog.Any("error", err),
 				)
 			}
 
-			used, err := fn(session, pkg.Metadata().Timestamp, buffer)
+			return false
+		}
+
+		return true
+	})
+
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+// checkTruncated 检查数据包是否因抓包长度不足被截断, 截断的数据包将导致重组数据不完整
+func (c *capturer) checkTruncated(ci gopacket.CaptureInfo) {
+	if ci.CaptureLength >= ci.Length {
+		return
+	}
+
+	if c.opts.onTruncated != nil {
+		c.opts.onTruncated(ci)
+		return
+	}
+
+	if !c.truncated {
+		c.truncated = true
+
+		c.opts.logger.Warn(
+			"captured packet truncated, snap length may be too small:",
+			slog.Int("capture_length", ci.CaptureLength),
+			slog.Int("length", ci.Length),
+		)
+	}
+}
 
+// DecodeErrorHandler 数据包解码失败回调, data 为原始报文, 仅在回调期间有效
+type DecodeErrorHandler func(err error, ci gopacket.CaptureInfo, data []byte)
+
+// checkDecoded 检查数据包是否解码失败, gopacket 不支持的协议类型不视为解码失败
+func (c *capturer) checkDecoded(pkg gopacket.Packet) {
+	failure := pkg.ErrorLayer()
+	if failure == nil {
+		return
+	}
+
+	err := failure.Error()
+
+	// 未知协议类型的错误由 gopacket 的占位解码器返回
+	if _, unsupported := err.(gopacket.Decoder); unsupported {
+		c.opts.logger.Debug(
+			"unsupported protocol in packet:",
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	if c.opts.onDecodeError != nil {
+		c.opts.onDecodeError(err, pkg.Metadata().CaptureInfo, pkg.Data())
+		return
+	}
+
+	c.opts.logger.Debug(
+		"packet decode failed:",
+		slog.Any("error", err),
+		slog.String("data", hex.EncodeToString(pkg.Data())),
+	)
+}
+
+// sessions 返回当前跟踪的会话总数
+func (c *capturer) sessions() int {
+	count := c.flows.Len()
+
+	if c.tracked == nil {
+		return count
+	}
+
+	delta := count - c.last
+	c.last = count
+
+	return int(c.tracked.Add(int64(delta)))
+}
+
+func (c *capturer) handlePacket(pkg gopacket.Packet) error {
+	c.flows.iface, c.flows.captured = pkg.Metadata().InterfaceIndex, pkg.Metadata().CaptureLength
+	c.flows.timestamp = pkg.Metadata().Timestamp
+	c.flows.handshake = false
+	c.flows.labels = mplsLabels(pkg)
+
+	vlans := vlanTags(pkg)
+
+	if !c.matchVLAN(vlans) {
+		return nil
+	}
+
+	var (
+		srcIP, dstIP net.IP
+		next         gopacket.LayerType
+	)
+
+	switch ip := pkg.NetworkLayer().(type) {
+	case *layers.IPv4:
+		if !c.acceptIPOptions(ip) {
+			return nil
+		}
+
+		if c.defrag != nil && isFragment(ip) {
+			whole, err := c.defrag.Defrag(ip, pkg.Metadata().Timestamp)
 			if err != nil {
-				if errors.Is(err, io.EOF) {
-					return nil
-				}
+				c.opts.logger.Warn(
+					"ipv4 defragment failed:",
+					slog.String("flow", ip.NetworkFlow().String()),
+					slog.Any("error", err),
+				)
+				return nil
+			}
+
+			if whole == nil {
+				return nil
+			}
+
+			ip, pkg = whole, decapsulate(pkg, whole.Payload, whole.NextLayerType())
+		}
+
+		srcIP, dstIP, next = ip.SrcIP, ip.DstIP, ip.NextLayerType()
+		c.ipID = uint32(ip.Id)
+	case *layers.IPv6:
+		srcIP, dstIP, next = ip.SrcIP, ip.DstIP, ipv6NextLayerType(pkg, ip)
+		c.ipID = ip.FlowLabel
+	default:
+		c.opts.logger.Debug("captured is not a valid IP packet, skipped.")
+		return nil
+	}
+
+	switch next {
+	case layers.LayerTypeTCP:
+		tcp, _ := pkg.Layer(layers.LayerTypeTCP).(*layers.TCP)
+
+		return c.handleTCP(pkg.Metadata().Timestamp, srcIP, dstIP, vlans, tcp)
+	case layers.LayerTypeUDP:
+		udp, _ := pkg.Layer(layers.LayerTypeUDP).(*layers.UDP)
+
+		return c.handleUDP(pkg, srcIP, dstIP, vlans, udp)
+	case layers.LayerTypeICMPv4, layers.LayerTypeICMPv6:
+		if c.opts.icmp {
+			return c.handleICMP(pkg, srcIP, dstIP, vlans, next)
+		}
+	case layers.LayerTypeSCTP:
+		sctp, _ := pkg.Layer(layers.LayerTypeSCTP).(*layers.SCTP)
+
+		return c.handleSCTP(pkg.Metadata().Timestamp, srcIP, dstIP, vlans, sctp)
+	case layers.LayerTypeGRE:
+		if c.opts.gre {
+			gre, _ := pkg.Layer(layers.LayerTypeGRE).(*layers.GRE)
+
+			return c.handleGRE(pkg, gre)
+		}
+	}
+
+	c.opts.logger.Error(
+		"unsupported transport layer:",
+		slog.String("layer", next.String()),
+	)
+
+	return nil
+}
+
+func (c *capturer) handleTCP(ts time.Time, srcIP, dstIP net.IP, vlans []uint16, tcp *layers.TCP) error {
+	if tcp == nil {
+		return nil
+	}
+
+	if c.dedup != nil && len(tcp.Payload) > 0 && c.dedup.Duplicate(ts, srcIP, dstIP, dedupHeader{
+		proto: core.TCP, srcPort: uint16(tcp.SrcPort), dstPort: uint16(tcp.DstPort),
+		ipID: c.ipID, seq: tcp.Seq, ack: tcp.Ack, flags: tcpFlags(tcp),
+	}, tcp.Payload) {
+		return nil
+	}
+
+	session := &core.Session{
+		Proto:   core.TCP,
+		SrcIP:   srcIP,
+		SrcPort: int(tcp.SrcPort),
+		DstIP:   dstIP,
+		DstPort: int(tcp.DstPort),
+		VLANs:   vlans,
+	}
+
+	// RST 即终止连接, 双向会话缓存均需清理
+	if tcp.RST {
+		c.flows.Delete(session, CloseRST)
+		c.flows.Delete(session.Reverse(), CloseRST)
+		return nil
+	}
+
+	if !tcp.SYN && len(tcp.Payload) <= 0 {
+		if !tcp.FIN {
+			return nil
+		}
 
+		if f := c.flows.Peek(session); f != nil {
+			return c.finish(f, ts)
+		}
+
+		return nil
+	}
+
+	if !c.sampleFlow(session) {
+		return nil
+	}
+
+	var f *flow
+
+	c.flows.handshake = tcp.SYN
+
+	if tcp.SYN || c.opts.midStream {
+		f = c.flows.Get(session, time.Now())
+	} else if f = c.flows.Lookup(session, time.Now()); f == nil {
+		c.opts.logger.Debug(
+			"segment without handshake skipped:",
+			slog.String("session", session.String()),
+		)
+		return nil
+	}
+
+	if tcp.SYN {
+		f.handshake = true
+		f.stream.Sync(tcp.Seq)
+
+		// 发起方发送 SYN, 响应方发送 SYN+ACK
+		if tcp.ACK {
+			f.session.Direction = core.DirectionServerToClient
+		} else {
+			f.session.Direction = core.DirectionClientToServer
+		}
+	}
+
+	// 未选择的方向不进行重组
+	if !c.matchDirection(f.session.Direction) {
+		if tcp.FIN {
+			c.flows.Remove(f, CloseFIN)
+		}
+
+		return nil
+	}
+
+	// 逐段交付时不进行重组, 数据段直接交由 fn 处理
+	if c.opts.rawSegments {
+		if len(tcp.Payload) > 0 {
+			c.reassembled(f, len(tcp.Payload))
+
+			if _, err := c.invoke(f, ts, tcp.Payload); err != nil {
 				return err
-			} else if cache != nil {
-				cache.Rotate(used, nil)
 			}
 		}
+
+		if tcp.FIN {
+			c.flows.Remove(f, CloseFIN)
+		}
+
+		return nil
+	}
+
+	// SYN 占用一个序列号, TCP Fast Open 等 SYN 携带的数据自 seq+1 开始
+	seq := tcp.Seq
+	if tcp.SYN {
+		seq++
+	}
+
+	var (
+		buffer   []byte
+		overflow bool
+	)
+
+	emit := func(data []byte) {
+		if !overflow {
+			buffer, overflow = c.merge(f, data)
+		}
+	}
+
+	c.skipped(f, f.stream.Push(seq, tcp.Payload, ts, c.opts.reassembly, emit))
+
+	// FIN 表示该方向数据已发送完毕, 乱序缓存中的数据跳过空洞后一并交付
+	if tcp.FIN {
+		c.skipped(f, f.stream.Drain(emit))
+	}
+
+	if overflow || c.exceeded(f, 0) {
+		return nil
+	}
+
+	if len(buffer) > 0 {
+		if err := c.deliver(f, ts, buffer); err != nil {
+			return err
+		}
+	}
+
+	if tcp.FIN {
+		c.flows.Remove(f, CloseFIN)
+	}
+
+	return nil
+}
+
+// finish 以不携带数据的 FIN 结束会话, 乱序缓存中的数据跳过空洞后交付
+func (c *capturer) finish(f *flow, ts time.Time) error {
+	var (
+		buffer   []byte
+		overflow bool
+	)
+
+	c.skipped(f, f.stream.Drain(func(data []byte) {
+		if !overflow {
+			buffer, overflow = c.merge(f, data)
+		}
+	}))
+
+	if overflow {
+		return nil
+	}
+
+	if len(buffer) > 0 {
+		if err := c.deliver(f, ts, buffer); err != nil {
+			return err
+		}
+	}
+
+	c.flows.Remove(f, CloseFIN)
+
+	return nil
+}
+
+// skipped 记录 TCP 重组跳过的序列号空洞
+func (c *capturer) skipped(f *flow, size int) {
+	if size <= 0 {
+		return
+	}
+
+	f.counters.LostBytes += uint64(size)
+
+	c.opts.logger.Debug(
+		"sequence gap skipped:",
+		slog.String("session", f.key),
+		slog.Int("size", size),
+		slog.Int("pending", f.stream.Pending()),
+	)
+}
+
+func (c *capturer) handleUDP(pkg gopacket.Packet, srcIP, dstIP net.IP, vlans []uint16, udp *layers.UDP) error {
+	if udp == nil || len(udp.Payload) <= 0 {
+		return nil
+	}
+
+	if c.isVXLAN(udp) {
+		return c.handlePacket(decapsulate(pkg, udp.Payload, layers.LayerTypeVXLAN))
+	}
+
+	return c.handleDatagram(pkg.Metadata().Timestamp, srcIP, dstIP, vlans, udp)
+}
+
+func (c *capturer) isVXLAN(udp *layers.UDP) bool {
+	_, exist := c.opts.vxlanPorts[int(udp.DstPort)]
+	return exist
+}
+
+func (c *capturer) handleDatagram(ts time.Time, srcIP, dstIP net.IP, vlans []uint16, udp *layers.UDP) error {
+	if !c.matchDirection(core.DirectionUnknown) {
+		return nil
+	}
+
+	if c.dedup != nil && c.dedup.Duplicate(ts, srcIP, dstIP, dedupHeader{
+		proto: core.UDP, srcPort: uint16(udp.SrcPort), dstPort: uint16(udp.DstPort), ipID: c.ipID,
+	}, udp.Payload) {
+		return nil
+	}
+
+	session := &core.Session{
+		Proto:   core.UDP,
+		SrcIP:   srcIP,
+		SrcPort: int(udp.SrcPort),
+		DstIP:   dstIP,
+		DstPort: int(udp.DstPort),
+		VLANs:   vlans,
+	}
+
+	if !c.sampleFlow(session) {
+		return nil
+	}
+
+	// UDP无握手过程, 首个数据报即创建会话缓存
+	f := c.flows.Get(session, time.Now())
+
+	buffer, overflow := c.merge(f, udp.Payload)
+	if overflow {
+		return nil
+	}
+
+	return c.deliver(f, ts, buffer)
+}
+
+// handleICMP 将完整的 ICMP 报文(含类型, 代码及校验和)交由 fn 处理, 不进行会话缓存
+func (c *capturer) handleICMP(pkg gopacket.Packet, srcIP, dstIP net.IP, vlans []uint16, typ gopacket.LayerType) error {
+	icmp := pkg.Layer(typ)
+	if icmp == nil || !c.matchDirection(core.DirectionUnknown) {
+		return nil
+	}
+
+	proto := core.ICMP
+	if typ == layers.LayerTypeICMPv6 {
+		proto = core.ICMPv6
+	}
+
+	session := &core.Session{
+		Proto: proto,
+		SrcIP: srcIP,
+		DstIP: dstIP,
+		VLANs: vlans,
+	}
+
+	data := append(slices.Clip(icmp.LayerContents()), icmp.LayerPayload()...)
+
+	if c.opts.payload != nil && !c.opts.payload.Match(data) {
+		return nil
+	}
+
+	if _, err := c.call(&flow{session: session, hash: c.flows.hash(session), route: c.flows.route}, pkg.Metadata().Timestamp, data); err != nil {
+		return c.handlerError(session.String(), err)
+	}
+
+	return nil
+}
+
+func (c *capturer) handleGRE(pkg gopacket.Packet, gre *layers.GRE) error {
+	if gre == nil {
+		return nil
+	}
+
+	inner := decapsulate(pkg, gre.Payload, gre.Protocol)
+
+	if inner.Layer(layers.LayerTypeGRE) != nil {
+		c.opts.logger.Warn(
+			"nested gre tunnel is not supported, skipped:",
+			slog.String("protocol", gre.Protocol.String()),
+		)
+		return nil
+	}
+
+	return c.handlePacket(inner)
+}
+
+// acceptIPOptions 检查 IPv4 报文是否携带选项 (IHL > 5), 携带时调试日志记录并累加 WithIPOptionsCounter 设置的计数器,
+// 开启 WithDropIPOptions 时返回 false; 传输层按 IHL 定位, 不受选项长度影响
+func (c *capturer) acceptIPOptions(ip *layers.IPv4) bool {
+	if ip.IHL <= 5 {
+		return true
+	}
+
+	c.opts.logger.Debug(
+		"ip packet with options:",
+		slog.String("flow", ip.NetworkFlow().String()),
+		slog.Int("header", int(ip.IHL)*4),
+		slog.Bool("dropped", c.opts.dropIPOptions),
+	)
+
+	if c.opts.ipOptions != nil {
+		c.opts.ipOptions.Add(1)
+	}
+
+	return !c.opts.dropIPOptions
+}
+
+func (c *capturer) matchDirection(dir core.Direction) bool {
+	return c.opts.direction.match(dir, c.opts.dropUnknown)
+}
+
+func (c *capturer) matchVLAN(vlans []uint16) bool {
+	if len(c.opts.vlans) <= 0 {
+		return true
+	}
+
+	for _, id := range vlans {
+		if _, exist := c.opts.vlans[id]; exist {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *capturer) deliver(f *flow, ts time.Time, buffer []byte) error {
+	used, err := c.invoke(f, ts, buffer)
+	if err != nil || used <= 0 {
+		return err
+	}
+
+	f.cache.Rotate(used, nil)
+
+	return nil
+}
+
+// invoke 调用数据处理函数, 并按错误策略处理返回的错误, 返回已使用的数据长度
+func (c *capturer) invoke(f *flow, ts time.Time, data []byte) (int, error) {
+	if c.opts.payload != nil && !c.opts.payload.Match(data) {
+		return 0, nil
+	}
+
+	if c.opts.framer != nil {
+		return c.invokeFrames(f, ts, data)
+	}
+
+	used, err := c.call(f, ts, data)
+	if err != nil {
+		return 0, c.flowError(f, err)
+	}
+
+	return used, nil
+}
+
+// invokeFrames 按 WithDelimiter 或 WithFixedFrameSize 拆分完整帧后逐帧调用数据处理函数,
+// 返回已处理完整帧的长度, 处理函数未用完当前帧或出错时停止, 已处理的帧不再重复交付
+func (c *capturer) invokeFrames(f *flow, ts time.Time, data []byte) (used int, err error) {
+	for used < len(data) {
+		size := c.opts.framer(data[used:])
+		if size <= 0 {
+			break
+		}
+
+		n, err := c.call(f, ts, data[used:used+size])
+		if err != nil {
+			// ErrorAbortFlow 时会话已删除, 缓存不可再使用
+			if err = c.flowError(f, err); err != nil || c.opts.errorPolicy == ErrorAbortFlow {
+				return 0, err
+			}
+
+			return used, nil
+		}
+
+		used += n
+		if n < size {
+			break
+		}
+	}
+
+	return used, nil
+}
+
+// flowError 按错误策略处理会话数据处理函数返回的错误, ErrorAbortFlow 时删除会话
+func (c *capturer) flowError(f *flow, err error) error {
+	if err = c.handlerError(f.key, err); err == nil && c.opts.errorPolicy == ErrorAbortFlow {
+		c.flows.Remove(f, CloseHandlerError)
+	}
+
+	return err
+}
+
+// merge 将数据合并入会话缓存并返回全部待处理数据, 超出 WithMaxSessionBuffer 限制时删除会话并返回 true
+func (c *capturer) merge(f *flow, data []byte) ([]byte, bool) {
+	if c.exceeded(f, len(data)) {
+		return nil, true
+	}
+
+	c.reassembled(f, len(data))
+
+	return f.cache.Merge(data), false
+}
+
+// exceeded 会话缓存与重组缓存中的数据再增加 size 后超出 WithMaxSessionBuffer 限制时删除会话并返回 true
+func (c *capturer) exceeded(f *flow, size int) bool {
+	limit := c.opts.maxBuffer
+	if limit <= 0 {
+		return false
+	}
+
+	pending := f.stream.Pending() + f.sctp.Pending()
+	if f.cache.Len()+pending+size <= limit {
+		return false
+	}
+
+	c.opts.logger.Warn(
+		"session buffer exceeds limit, session removed:",
+		slog.String("session", f.key),
+		slog.Int("remain", f.cache.Len()),
+		slog.Int("pending", pending),
+		slog.Int("limit", limit),
+	)
+
+	if observer, ok := c.opts.observer.(BufferObserver); ok {
+		observer.BufferOverflow()
+	}
+
+	c.flows.Remove(f, CloseOverflow)
+
+	return true
+}
+
+// reassembled 记录合并入会话缓存的数据长度
+func (c *capturer) reassembled(f *flow, size int) {
+	f.counters.PayloadBytes += uint64(size)
+	c.opts.observer.DataReassembled(size)
+}
+
+// stopped 记录数据处理函数要求结束抓包, 供 WithCaptureResult 区分结束原因
+func (c *capturer) stopped() {
+	if c.opts.stopped != nil {
+		c.opts.stopped.Store(true)
+	}
+}
+
+// samplePacket 按 WithSampling 判断是否处理当前数据包, 按会话抽样时均处理
+func (c *capturer) samplePacket() bool {
+	if c.opts.sampling <= 1 || c.opts.flowSampling {
+		return true
 	}
+
+	c.packets++
+
+	return c.packets%uint64(c.opts.sampling) == 1
+}
+
+// sampleFlow 按 WithSampling 判断是否处理会话, 双向会话哈希相同, 选取结果一致
+func (c *capturer) sampleFlow(session *core.Session) bool {
+	if c.opts.sampling <= 1 || !c.opts.flowSampling {
+		return true
+	}
+
+	return c.flows.hash(session)%uint64(c.opts.sampling) == 0
+}
+
+// discard 未设置数据处理函数时仅读取数据包, 不进行处理
+func (c *capturer) discard() bool {
+	return c.fn == nil && c.pfn == nil && len(c.opts.handlers) <= 0 && c.opts.routes == nil && c.opts.traffic == nil
+}
+
+// matchRoute 匹配 WithFilterHandler 设置的过滤, 未设置或均不匹配时返回 0
+func (c *capturer) matchRoute(ci gopacket.CaptureInfo, data []byte) int {
+	if c.opts.routes == nil {
+		return 0
+	}
+
+	return c.opts.routes.match(ci, data)
+}
+
+// call 调用数据处理函数, PacketHandler 的 Packet 在各次调用间复用, 开启 WithCopyPayload 时传入数据的拷贝,
+// 会话匹配的 WithFilterHandler 处理函数优先, 其次为 WithProtocolHandler 设置的协议处理函数
+func (c *capturer) call(f *flow, ts time.Time, data []byte) (int, error) {
+	if c.opts.copyPayload {
+		data = slices.Clone(data)
+	}
+
+	session := f.session
+
+	if fn := c.opts.routes.handler(f.route); fn != nil {
+		return fn(session, ts, data)
+	}
+
+	if fn, exist := c.opts.handlers[session.Proto]; exist {
+		return fn(session, ts, data)
+	}
+
+	if c.pfn == nil {
+		if c.fn == nil {
+			return len(data), nil
+		}
+
+		return c.fn(session, ts, data)
+	}
+
+	c.pkt = core.Packet{
+		Session:       session,
+		Key:           session.Key(),
+		Src:           session.SrcAddr(),
+		Dst:           session.DstAddr(),
+		Data:          data,
+		Timestamp:     ts,
+		Protocol:      session.Proto,
+		FlowHash:      f.hash,
+		Direction:     session.Direction,
+		FromHandshake: f.handshake,
+	}
+
+	return c.pfn(&c.pkt)
+}
+
+// handlerError 按错误策略处理数据处理函数返回的错误, 返回需结束抓包的错误
+func (c *capturer) handlerError(key string, err error) error {
+	if errors.Is(err, io.EOF) {
+		return err
+	}
+
+	c.opts.observer.HandlerFailed(err)
+	c.opts.logger.Error(
+		"data handler failed:",
+		slog.String("session", key),
+		slog.String("policy", c.opts.errorPolicy.String()),
+		slog.Any("error", err),
+	)
+
+	if c.opts.errorPolicy == ErrorStop {
+		return err
+	}
+
+	return nil
+}
+
+// ipv6NextLayerType 跳过IPv6扩展头, 返回实际的传输层类型
+func ipv6NextLayerType(pkg gopacket.Packet, ip *layers.IPv6) gopacket.LayerType {
+	next := ip.NextLayerType()
+
+	for _, layer := range pkg.Layers() {
+		if layer.LayerType() != next {
+			continue
+		}
+
+		switch ext := layer.(type) {
+		case *layers.IPv6HopByHop:
+			next = ext.NextHeader.LayerType()
+		case *layers.IPv6Routing:
+			next = ext.NextHeader.LayerType()
+		case *layers.IPv6Destination:
+			next = ext.NextHeader.LayerType()
+		}
+	}
+
+	return next
+}
+
+// vlanTags 遍历 802.1Q 标签, QinQ 时外层标签在前
+func vlanTags(pkg gopacket.Packet) (vlans []uint16) {
+	for _, layer := range pkg.Layers() {
+		if tag, ok := layer.(*layers.Dot1Q); ok {
+			vlans = append(vlans, tag.VLANIdentifier)
+		}
+	}
+
+	return
+}
+
+// mplsLabels 遍历 MPLS 标签栈, 外层标签在前
+func mplsLabels(pkg gopacket.Packet) (labels []uint32) {
+	for _, layer := range pkg.Layers() {
+		if mpls, ok := layer.(*layers.MPLS); ok {
+			labels = append(labels, mpls.Label)
+		}
+	}
+
+	return
+}
+
+// decapsulate 以 first 解码隧道负载, 内层数据包沿用外层的抓包信息
+func decapsulate(pkg gopacket.Packet, data []byte, first gopacket.Decoder) gopacket.Packet {
+	inner := gopacket.NewPacket(data, first, gopacket.Default)
+
+	ci := pkg.Metadata().CaptureInfo
+	ci.CaptureLength = len(data)
+	ci.Length = len(data)
+	inner.Metadata().CaptureInfo = ci
+
+	return inner
 }
@@ -0,0 +1,114 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"net"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket/layers"
+)
+
+// deduplicator 丢弃时间窗口内完全相同的数据包, 用于消除 SPAN 端口重复镜像的报文,
+// 以数据包抓取时间判断窗口, 离线回放时同样适用
+type deduplicator struct {
+	window time.Duration
+	seed   maphash.Seed
+	seen   map[uint64]time.Time
+	// queue 按抓取时间排列的已记录数据包, 用于淘汰窗口外的记录
+	queue []dedupEntry
+}
+
+type dedupEntry struct {
+	key uint64
+	ts  time.Time
+}
+
+func newDeduplicator(window time.Duration) *deduplicator {
+	return &deduplicator{
+		window: window,
+		seed:   maphash.MakeSeed(),
+		seen:   make(map[uint64]time.Time),
+	}
+}
+
+// dedupHeader 参与重复判断的报文头字段, SPAN 镜像的副本各字段均相同, 真实的重复发送通常 IP 标识不同
+type dedupHeader struct {
+	proto            core.TransProto
+	srcPort, dstPort uint16
+	// ipID IPv4 Identification 或 IPv6 Flow Label
+	ipID     uint32
+	seq, ack uint32
+	flags    uint8
+}
+
+// Duplicate 判断数据包是否与窗口内已记录的数据包相同, 以地址及报文头字段和负载区分数据包,
+// 非重复的数据包将被记录
+func (d *deduplicator) Duplicate(ts time.Time, srcIP, dstIP net.IP, hdr dedupHeader, payload []byte) bool {
+	d.expire(ts)
+
+	var h maphash.Hash
+	h.SetSeed(d.seed)
+
+	var buf [18]byte
+	buf[0] = byte(hdr.proto)
+	binary.BigEndian.PutUint16(buf[1:], hdr.srcPort)
+	binary.BigEndian.PutUint16(buf[3:], hdr.dstPort)
+	binary.BigEndian.PutUint32(buf[5:], hdr.ipID)
+	binary.BigEndian.PutUint32(buf[9:], hdr.seq)
+	binary.BigEndian.PutUint32(buf[13:], hdr.ack)
+	buf[17] = hdr.flags
+
+	h.Write(buf[:])
+	h.Write(srcIP.To16())
+	h.Write(dstIP.To16())
+	h.Write(payload)
+
+	key := h.Sum64()
+
+	if last, exist := d.seen[key]; exist && ts.Sub(last) <= d.window {
+		return true
+	}
+
+	d.seen[key] = ts
+	d.queue = append(d.queue, dedupEntry{key: key, ts: ts})
+
+	return false
+}
+
+// expire 淘汰抓取时间早于窗口的记录
+func (d *deduplicator) expire(ts time.Time) {
+	idx := 0
+
+	for ; idx < len(d.queue) && ts.Sub(d.queue[idx].ts) > d.window; idx++ {
+		entry := d.queue[idx]
+
+		if last, exist := d.seen[entry.key]; exist && last.Equal(entry.ts) {
+			delete(d.seen, entry.key)
+		}
+	}
+
+	if idx > 0 {
+		d.queue = append(d.queue[:0], d.queue[idx:]...)
+	}
+}
+
+// tcpFlags 以 core.TCPFlags 的位序返回 TCP 标志位
+func tcpFlags(tcp *layers.TCP) uint8 {
+	var flags core.TCPFlags
+
+	for _, bit := range [...]struct {
+		flag core.TCPFlags
+		set  bool
+	}{
+		{core.FIN, tcp.FIN}, {core.SYN, tcp.SYN}, {core.RST, tcp.RST}, {core.PUS, tcp.PSH},
+		{core.ACK, tcp.ACK}, {core.URG, tcp.URG}, {core.ECE, tcp.ECE}, {core.CWR, tcp.CWR},
+	} {
+		if bit.set {
+			flags |= bit.flag
+		}
+	}
+
+	return uint8(flags)
+}
@@ -0,0 +1,36 @@
+package pcap
+
+import (
+	"time"
+
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+)
+
+// defragmenter IPv4 分片重组, 以数据包抓取时间判断分片超时, 离线回放时同样适用
+type defragmenter struct {
+	*ip4defrag.IPv4Defragmenter
+	timeout   time.Duration
+	discarded time.Time
+}
+
+func newDefragmenter(timeout time.Duration) *defragmenter {
+	return &defragmenter{
+		IPv4Defragmenter: ip4defrag.NewIPv4Defragmenter(),
+		timeout:          timeout,
+	}
+}
+
+// Defrag 重组分片, 分片未收齐时返回 nil, 超时未收齐的分片将被丢弃
+func (d *defragmenter) Defrag(ip *layers.IPv4, ts time.Time) (*layers.IPv4, error) {
+	if ts.Sub(d.discarded) >= d.timeout {
+		d.DiscardOlderThan(ts.Add(-d.timeout))
+		d.discarded = ts
+	}
+
+	return d.DefragIPv4WithTimestamp(ip, ts)
+}
+
+func isFragment(ip *layers.IPv4) bool {
+	return ip.Flags&layers.IPv4MoreFragments != 0 || ip.FragOffset != 0
+}
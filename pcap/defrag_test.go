@@ -0,0 +1,115 @@
+package pcap
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func buildFragments(t *testing.T, id uint16, ts time.Time, payload []byte, size int) (frags []gopacket.Packet) {
+	t.Helper()
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(
+		buf, gopacket.SerializeOptions{FixLengths: true},
+		&layers.UDP{SrcPort: 5000, DstPort: 53},
+		gopacket.Payload(payload),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	datagram := buf.Bytes()
+
+	for offset := 0; offset < len(datagram); offset += size {
+		end := min(offset+size, len(datagram))
+
+		ip := &layers.IPv4{
+			Version:    4,
+			TTL:        64,
+			Id:         id,
+			Protocol:   layers.IPProtocolUDP,
+			SrcIP:      net.ParseIP("192.168.1.1").To4(),
+			DstIP:      net.ParseIP("192.168.1.2").To4(),
+			FragOffset: uint16(offset / 8),
+		}
+
+		if end < len(datagram) {
+			ip.Flags = layers.IPv4MoreFragments
+		}
+
+		frags = append(frags, buildPacket(
+			t, layers.LinkTypeEthernet, ts,
+			&layers.Ethernet{
+				SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+				DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+				EthernetType: layers.EthernetTypeIPv4,
+			},
+			ip, gopacket.Payload(datagram[offset:end]),
+		))
+	}
+
+	return
+}
+
+func TestCaptureIPv4Defrag(t *testing.T) {
+	now := time.Now()
+
+	complete := buildFragments(t, 1, now, []byte("a large dns response is split into three fragments"), 24)
+	if len(complete) != 3 {
+		t.Fatalf("unexpected fragment count: %d", len(complete))
+	}
+
+	// 第三个分片超时到达, 前两个分片已被丢弃
+	expired := buildFragments(t, 2, now, []byte("an expired datagram is split into three fragments..."), 24)
+	expired[2].Metadata().Timestamp = now.Add(time.Minute)
+
+	// 分片乱序到达
+	pkts := feedPackets(complete[2], complete[0], complete[1], expired[0], expired[1], expired[2])
+
+	var result []string
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return len(data), nil
+	}, newCaptureOptions(WithIPv4Defrag(time.Second))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"a large dns response is split into three fragments"}) {
+		t.Fatalf("defragment result mismatch: %q", result)
+	}
+}
+
+func TestCaptureIPv4DefragWorkers(t *testing.T) {
+	var pkts []gopacket.Packet
+
+	for id := uint16(1); id <= 8; id++ {
+		pkts = append(pkts, buildFragments(t, id, time.Now(), []byte("a large dns response is split into three fragments"), 24)...)
+	}
+
+	var (
+		mu    sync.Mutex
+		count int
+	)
+
+	if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		count++
+		return len(data), nil
+	}, newCaptureOptions(WithIPv4Defrag(0), WithWorkers(4))); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 8 {
+		t.Fatalf("defragmented datagram count mismatch: %d", count)
+	}
+}
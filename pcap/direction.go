@@ -0,0 +1,32 @@
+package pcap
+
+import "github.com/frozenpine/pkt4go/core"
+
+// DirectionFilter 按会话数据方向过滤交付的数据, 方向由 TCP 握手确定
+type DirectionFilter int
+
+//go:generate stringer -type DirectionFilter -linecomment
+const (
+	// DirectionAll 交付双向数据
+	DirectionAll DirectionFilter = iota // all
+	// DirectionInitiator 仅交付连接发起方(客户端至服务端)的数据
+	DirectionInitiator // initiator
+	// DirectionResponder 仅交付连接响应方(服务端至客户端)的数据
+	DirectionResponder // responder
+)
+
+// match 判断该方向的数据是否需要处理, 方向未知时由 dropUnknown 决定
+func (d DirectionFilter) match(dir core.Direction, dropUnknown bool) bool {
+	switch {
+	case d == DirectionAll:
+		return true
+	case dir == core.DirectionUnknown:
+		return !dropUnknown
+	case d == DirectionInitiator:
+		return dir == core.DirectionClientToServer
+	case d == DirectionResponder:
+		return dir == core.DirectionServerToClient
+	default:
+		return true
+	}
+}
@@ -0,0 +1,1506 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type delivery struct {
+	session string
+	data    string
+}
+
+func TestCaptureMixedFamily(t *testing.T) {
+	var result []delivery
+
+	pkts := feedPackets(
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			payload: []byte("v4-tcp"),
+		}),
+		buildSegment(t, testSegment{
+			src: "fe80::1", dst: "fe80::2", sport: 1000, dport: 2000,
+			payload: []byte("v6-tcp"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte("v4-udp"),
+		}),
+		buildSegment(t, testSegment{
+			src: "fe80::1", dst: "fe80::2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte("v6-udp"),
+		}),
+	)
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, delivery{session.String(), string(data)})
+		return len(data), nil
+	}, newCaptureOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []delivery{
+		{"[tcp] 192.168.1.1:1000 -> 192.168.1.2:2000", "v4-tcp"},
+		{"[tcp] [fe80::1]:1000 -> [fe80::2]:2000", "v6-tcp"},
+		{"[udp] 192.168.1.1:1000 -> 192.168.1.2:2000", "v4-udp"},
+		{"[udp] [fe80::1]:1000 -> [fe80::2]:2000", "v6-udp"},
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("delivery count mismatch: %v", result)
+	}
+
+	for idx, v := range expected {
+		if result[idx] != v {
+			t.Fatalf("delivery[%d] mismatch: %v != %v", idx, result[idx], v)
+		}
+	}
+}
+
+func TestCaptureSkipNonIP(t *testing.T) {
+	arp := buildPacket(
+		t, layers.LinkTypeEthernet, time.Now(),
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       layers.EthernetBroadcast,
+			EthernetType: layers.EthernetTypeARP,
+		},
+		&layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   []byte{0, 1, 2, 3, 4, 5},
+			SourceProtAddress: []byte{192, 168, 1, 1},
+			DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+			DstProtAddress:    []byte{192, 168, 1, 2},
+		},
+	)
+
+	pkts := feedPackets(
+		arp,
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte("after-arp"),
+		}),
+	)
+
+	var result []string
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return len(data), nil
+	}, newCaptureOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 1 || result[0] != "after-arp" {
+		t.Fatalf("capture loop not continued after arp: %v", result)
+	}
+}
+
+func TestCaptureUDPRemain(t *testing.T) {
+	pkts := feedPackets(
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 3000, dport: 4000,
+			udp: true, payload: []byte("first"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 3000, dport: 4000,
+			udp: true, payload: []byte("second"),
+		}),
+	)
+
+	var result []string
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		// only consume the first 3 bytes, remains should be kept
+		return 3, nil
+	}, newCaptureOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 2 || result[0] != "first" || result[1] != "stsecond" {
+		t.Fatalf("udp remain data mismatch: %v", result)
+	}
+}
+
+func TestCaptureTimestamp(t *testing.T) {
+	ts := time.Date(2023, 8, 12, 9, 30, 0, 123456000, time.Local)
+
+	pkts := feedPackets(
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 5000, dport: 6000,
+			payload: []byte("tick"), ts: ts,
+		}),
+	)
+
+	var captured time.Time
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		captured = ts
+		return len(data), nil
+	}, newCaptureOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !captured.Equal(ts) {
+		t.Fatalf("packet timestamp mismatch: %s != %s", captured, ts)
+	}
+}
+
+func TestCaptureVLAN(t *testing.T) {
+	pkts := feedPackets(
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte("vlan-10"), vlans: []uint16{10},
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1001, dport: 2000,
+			udp: true, payload: []byte("vlan-20"), vlans: []uint16{20},
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1002, dport: 2000,
+			udp: true, payload: []byte("qinq-100-20"), vlans: []uint16{100, 20},
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1003, dport: 2000,
+			udp: true, payload: []byte("untagged"),
+		}),
+	)
+
+	var result []delivery
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, delivery{fmt.Sprint(session.VLANs), string(data)})
+		return len(data), nil
+	}, newCaptureOptions(WithVLANFilter(20))); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []delivery{
+		{"[20]", "vlan-20"},
+		{"[100 20]", "qinq-100-20"},
+	}
+
+	if !slices.Equal(result, expected) {
+		t.Fatalf("vlan filter mismatch: %v", result)
+	}
+}
+
+// buildMPLS 以 MPLS 标签栈封装 inner 的网络层报文, 外层标签在前
+func buildMPLS(t *testing.T, inner gopacket.Packet, labels ...uint32) gopacket.Packet {
+	t.Helper()
+
+	stack := []gopacket.SerializableLayer{&layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+		EthernetType: layers.EthernetTypeMPLSUnicast,
+	}}
+	for idx, label := range labels {
+		stack = append(stack, &layers.MPLS{Label: label, StackBottom: idx == len(labels)-1, TTL: 64})
+	}
+	stack = append(stack,
+		gopacket.Payload(inner.NetworkLayer().LayerContents()),
+		gopacket.Payload(inner.NetworkLayer().LayerPayload()),
+	)
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, stack...); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	pkg.Metadata().CaptureInfo = inner.Metadata().CaptureInfo
+	pkg.Metadata().CaptureLength = len(buf.Bytes())
+	pkg.Metadata().Length = len(buf.Bytes())
+
+	return pkg
+}
+
+func TestCaptureVLANKey(t *testing.T) {
+	datagram := func(payload string, vlans ...uint16) gopacket.Packet {
+		return buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte(payload), vlans: vlans,
+		})
+	}
+
+	packets := func() []gopacket.Packet {
+		return []gopacket.Packet{
+			datagram("a", 10),
+			datagram("b", 20),
+			buildMPLS(t, datagram("c"), 100),
+			buildMPLS(t, datagram("d"), 200),
+		}
+	}
+
+	// 数据均不消费, 同一会话的数据将累积
+	run := func(opts ...CaptureOption) (result []string) {
+		if err := capture(context.TODO(), feedPackets(packets()...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return 0, nil
+		}, newCaptureOptions(opts...)); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	if result := run(); !slices.Equal(result, []string{"a", "ab", "abc", "abcd"}) {
+		t.Errorf("sessions without vlan key mismatch: %v", result)
+	}
+
+	if result := run(WithVLANKey()); !slices.Equal(result, []string{"a", "b", "c", "d"}) {
+		t.Errorf("sessions with vlan key mismatch: %v", result)
+	}
+}
+
+func buildVXLAN(t *testing.T, dport int, inner gopacket.Packet) gopacket.Packet {
+	t.Helper()
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(
+		buf, gopacket.SerializeOptions{},
+		&layers.VXLAN{ValidIDFlag: true, VNI: 42},
+		gopacket.Payload(inner.Data()),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	return buildSegment(t, testSegment{
+		src: "172.16.0.1", dst: "172.16.0.2", sport: 50000, dport: dport,
+		udp: true, payload: buf.Bytes(),
+	})
+}
+
+func TestCaptureVXLAN(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+
+	inner := buildSegment(t, testSegment{
+		src: "10.0.0.1", dst: "10.0.0.2", sport: 40000, dport: 80,
+		seq: 1, payload: []byte(request),
+	})
+
+	pkts := feedPackets(
+		buildVXLAN(t, DefaultVXLANPort, inner),
+		buildVXLAN(t, 8472, inner),
+	)
+
+	var result []delivery
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, delivery{session.String(), string(data)})
+		return len(data), nil
+	}, newCaptureOptions(WithVXLAN(DefaultVXLANPort, 8472))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second one is a retransmission of the same inner segment
+	expected := []delivery{
+		{"[tcp] 10.0.0.1:40000 -> 10.0.0.2:80", request},
+	}
+
+	if !slices.Equal(result, expected) {
+		t.Fatalf("vxlan decapsulation mismatch: %v", result)
+	}
+}
+
+func buildGRE(t *testing.T, depth int, payload string) gopacket.Packet {
+	t.Helper()
+
+	stack := []gopacket.SerializableLayer{
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+	}
+
+	for i := 0; i < depth; i++ {
+		stack = append(
+			stack,
+			&layers.IPv4{
+				Version:  4,
+				TTL:      64,
+				Protocol: layers.IPProtocolGRE,
+				SrcIP:    net.IP{172, 16, 0, byte(i + 1)},
+				DstIP:    net.IP{172, 16, 1, byte(i + 1)},
+			},
+			&layers.GRE{Protocol: layers.EthernetTypeIPv4},
+		)
+	}
+
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	udp := &layers.UDP{SrcPort: 1000, DstPort: 2000}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	stack = append(stack, ip, udp, gopacket.Payload(payload))
+
+	return buildPacket(t, layers.LinkTypeEthernet, time.Now(), stack...)
+}
+
+func TestCaptureGRE(t *testing.T) {
+	pkts := feedPackets(
+		buildGRE(t, 1, "gre"),
+		buildGRE(t, 2, "nested"),
+	)
+
+	var result []delivery
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, delivery{session.String(), string(data)})
+		return len(data), nil
+	}, newCaptureOptions(WithGRE())); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []delivery{
+		{"[udp] 10.0.0.1:1000 -> 10.0.0.2:2000", "gre"},
+	}
+
+	if !slices.Equal(result, expected) {
+		t.Fatalf("gre decapsulation mismatch: %v", result)
+	}
+}
+
+func TestCaptureLogger(t *testing.T) {
+	failed := errors.New("handler failed")
+
+	run := func(logger *slog.Logger) error {
+		return capture(context.TODO(), feedPackets(
+			buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+				udp: true, payload: []byte("data"),
+			}),
+		), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			return 0, failed
+		}, newCaptureOptions(WithLogger(logger)))
+	}
+
+	var buf bytes.Buffer
+
+	if err := run(slog.New(slog.NewTextHandler(&buf, nil))); !errors.Is(err, failed) {
+		t.Fatalf("handler error not returned: %v", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("data handler failed:")) {
+		t.Fatalf("handler error not logged: %q", buf.String())
+	}
+
+	if err := run(nil); !errors.Is(err, failed) {
+		t.Fatalf("handler error not returned with discard logger: %v", err)
+	}
+}
+
+type countingObserver struct {
+	packets, bytes, sessions, evicted, failures int
+}
+
+func (o *countingObserver) PacketProcessed(int)       { o.packets++ }
+func (o *countingObserver) DataReassembled(size int)  { o.bytes += size }
+func (o *countingObserver) SessionsTracked(count int) { o.sessions = count }
+func (o *countingObserver) SessionEvicted()           { o.evicted++ }
+func (o *countingObserver) HandlerFailed(error)       { o.failures++ }
+
+func TestCaptureObserver(t *testing.T) {
+	var pkts []gopacket.Packet
+
+	for port := 1000; port < 1003; port++ {
+		pkts = append(pkts, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: port, dport: 2000,
+			udp: true, payload: []byte("data"),
+		}))
+	}
+
+	observer := countingObserver{}
+
+	if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, newCaptureOptions(WithObserver(&observer), WithMaxSessions(2))); err != nil {
+		t.Fatal(err)
+	}
+
+	if observer != (countingObserver{packets: 3, bytes: 12, sessions: 2, evicted: 1}) {
+		t.Fatalf("observer mismatch: %+v", observer)
+	}
+}
+
+// queueObserver 在读取协程中记录队列深度
+type queueObserver struct {
+	countingObserver
+	mu       sync.Mutex
+	depth    int
+	capacity int
+	full     chan struct{}
+}
+
+func (o *queueObserver) QueueDepth(depth, capacity int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.depth, o.capacity = max(o.depth, depth), capacity
+
+	if depth >= capacity && o.full != nil {
+		close(o.full)
+		o.full = nil
+	}
+}
+
+func TestCaptureQueueDepth(t *testing.T) {
+	src := make(chanSource, 4)
+	for port := 1000; port < 1004; port++ {
+		src <- buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: port, dport: 2000,
+			udp: true, payload: []byte("data"),
+		})
+	}
+	close(src)
+
+	full := make(chan struct{})
+	observer := queueObserver{full: full}
+	calls := 0
+
+	// 首个数据包处理完成前队列将被填满
+	if err := StartCapture(context.TODO(), src, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		if calls++; calls == 1 {
+			select {
+			case <-full:
+			case <-time.After(5 * time.Second):
+				t.Error("queue not filled while handler is blocked")
+			}
+		}
+
+		return len(data), nil
+	}, WithObserver(&observer), WithQueueSize(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 4 || observer.packets != 4 {
+		t.Fatalf("delivery mismatch: %d calls, %d packets", calls, observer.packets)
+	}
+
+	if observer.depth != 2 || observer.capacity != 2 {
+		t.Fatalf("queue depth mismatch: %d/%d", observer.depth, observer.capacity)
+	}
+}
+
+func TestCapturePayloadFilter(t *testing.T) {
+	var pkts []gopacket.Packet
+
+	for _, payload := range []string{"GE", "T /index", "POST /"} {
+		pkts = append(pkts, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80,
+			udp: true, payload: []byte(payload),
+		}))
+	}
+
+	var result []string
+
+	if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return len(data), nil
+	}, newCaptureOptions(WithPayloadFilter(regexp.MustCompile(`^GET `)))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不匹配的 "GE" 保留在缓存中, 与后续数据合并后匹配
+	if !slices.Equal(result, []string{"GET /index"}) {
+		t.Fatalf("payload filter mismatch: %v", result)
+	}
+}
+
+func TestCaptureDirection(t *testing.T) {
+	pkts := feedPackets(
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true,
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 500, syn: true, ack: true,
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: []byte("request"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 501, ack: true, payload: []byte("response"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.3", dst: "192.168.1.2", sport: 2000, dport: 80, seq: 1, ack: true, payload: []byte("mid-stream"),
+		}),
+	)
+
+	var result []string
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, fmt.Sprintf("%s %s", data, session.Direction))
+		return len(data), nil
+	}, newCaptureOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"request client_to_server",
+		"response server_to_client",
+		"mid-stream unknown",
+	}
+
+	if !slices.Equal(result, expect) {
+		t.Fatalf("direction mismatch: %v", result)
+	}
+}
+
+func TestCaptureTruncated(t *testing.T) {
+	full := buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("full"),
+	})
+
+	truncated := buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("truncated"),
+	})
+	truncated.Metadata().Length += 100
+
+	var lengths []int
+
+	if err := capture(context.TODO(), feedPackets(full, truncated), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, newCaptureOptions(WithTruncated(func(ci gopacket.CaptureInfo) {
+		lengths = append(lengths, ci.Length-ci.CaptureLength)
+	}))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(lengths, []int{100}) {
+		t.Fatalf("truncated callback mismatch: %v", lengths)
+	}
+
+	var buf bytes.Buffer
+
+	if err := capture(context.TODO(), feedPackets(truncated, truncated), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, newCaptureOptions(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := bytes.Count(buf.Bytes(), []byte("captured packet truncated")); count != 1 {
+		t.Fatalf("truncated warning should be logged once: %d", count)
+	}
+}
+
+func TestCaptureFlushOnCancel(t *testing.T) {
+	for _, workers := range []int{1, 2} {
+		packets := make(chan gopacket.Packet)
+		handled := make(chan struct{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var (
+			mu      sync.Mutex
+			flushed []string
+		)
+		done := make(chan error, 1)
+
+		go func() {
+			done <- capture(ctx, packets, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+				// 不消费数据以模拟不完整的消息
+				handled <- struct{}{}
+				return 0, nil
+			}, newCaptureOptions(
+				WithWorkers(workers),
+				WithFlushOnCancel(func(session *core.Session, ts time.Time, data []byte) (int, error) {
+					mu.Lock()
+					defer mu.Unlock()
+
+					flushed = append(flushed, fmt.Sprintf("%d:%s", session.SrcPort, data))
+					return len(data), nil
+				}),
+			))
+		}()
+
+		for idx, payload := range []string{"partial", "message"} {
+			packets <- buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000 + idx, dport: 2000,
+				udp: true, payload: []byte(payload),
+			})
+			<-handled
+		}
+
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(flushed)
+
+		if !slices.Equal(flushed, []string{"1000:partial", "1001:message"}) {
+			t.Fatalf("flushed data mismatch with %d workers: %v", workers, flushed)
+		}
+	}
+}
+
+func TestCaptureErrorPolicy(t *testing.T) {
+	errBad := errors.New("malformed stream")
+
+	for _, c := range []struct {
+		policy ErrorPolicy
+		err    error
+		calls  []string
+		closed []string
+	}{
+		{ErrorStop, errBad, []string{"bad1"}, []string{"capture_end"}},
+		{ErrorContinue, nil, []string{"bad1", "ok1", "bad1bad2", "ok2"}, []string{"capture_end", "capture_end"}},
+		{ErrorAbortFlow, nil, []string{"bad1", "ok1", "bad2", "ok2"}, []string{"handler_error", "handler_error", "capture_end"}},
+	} {
+		var pkts []gopacket.Packet
+
+		for _, payload := range []string{"bad1", "ok1", "bad2", "ok2"} {
+			sport := 1000
+			if strings.HasPrefix(payload, "ok") {
+				sport = 1001
+			}
+
+			pkts = append(pkts, buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: sport, dport: 2000,
+				udp: true, payload: []byte(payload),
+			}))
+		}
+
+		var calls, closed []string
+
+		err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			calls = append(calls, string(data))
+
+			if session.SrcPort == 1000 {
+				return 0, errBad
+			}
+
+			return len(data), nil
+		}, newCaptureOptions(
+			WithErrorPolicy(c.policy),
+			WithLogger(nil),
+			WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+				closed = append(closed, reason.String())
+			}),
+		))
+
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s policy returned unexpected error: %v", c.policy, err)
+		}
+
+		if !slices.Equal(calls, c.calls) {
+			t.Fatalf("%s policy delivery mismatch: %v", c.policy, calls)
+		}
+
+		if !slices.Equal(closed, c.closed) {
+			t.Fatalf("%s policy close reasons mismatch: %v", c.policy, closed)
+		}
+	}
+}
+
+func TestCaptureICMP(t *testing.T) {
+	build := func() gopacket.Packet {
+		ip, _, _ := networkLayer(net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2"), layers.IPProtocolICMPv4)
+
+		return buildPacket(t, layers.LinkTypeRaw, time.Now(), ip, &layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeFragmentationNeeded),
+		}, gopacket.Payload("original header"))
+	}
+
+	for _, enable := range []bool{false, true} {
+		var opts []CaptureOption
+		if enable {
+			opts = append(opts, WithICMP())
+		}
+
+		var result []string
+
+		if err := capture(context.TODO(), feedPackets(build()), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, fmt.Sprintf("%s %s %d/%d %s", session.Proto, session.SrcAddr(), data[0], data[1], data[8:]))
+			return 0, nil
+		}, newCaptureOptions(append(opts, WithLogger(nil))...)); err != nil {
+			t.Fatal(err)
+		}
+
+		var expect []string
+		if enable {
+			expect = []string{"icmp 192.168.1.1 3/4 original header"}
+		}
+
+		if !slices.Equal(result, expect) {
+			t.Fatalf("icmp delivery mismatch with icmp %v: %v", enable, result)
+		}
+	}
+}
+
+func TestCapturePacketHandler(t *testing.T) {
+	for _, workers := range []int{1, 2} {
+		src := make(chanSource, 2)
+		src <- buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true,
+		})
+		src <- buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: []byte("request"),
+		})
+		close(src)
+
+		var result []string
+
+		if err := StartPacketCapture(context.TODO(), src, "", func(pkt *core.Packet) (int, error) {
+			if pkt.FlowHash != sessionHash(pkt.Session) || pkt.FlowHash != pkt.Key.Hash() {
+				t.Errorf("flow hash mismatch: %d", pkt.FlowHash)
+			}
+
+			if key := pkt.Key.String(); key != "[tcp] 192.168.1.1:1000 -> 192.168.1.2:80" {
+				t.Errorf("flow key mismatch: %s", key)
+			}
+
+			result = append(result, fmt.Sprintf(
+				"%s %s->%s %s %s", pkt.Protocol, pkt.Src, pkt.Dst, pkt.Direction, pkt.Data,
+			))
+			return len(pkt.Data), nil
+		}, WithWorkers(workers)); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := []string{"tcp 192.168.1.1:1000->192.168.1.2:80 client_to_server request"}
+
+		if !slices.Equal(result, expect) {
+			t.Fatalf("packet handler mismatch with %d workers: %v", workers, result)
+		}
+	}
+}
+
+func TestCaptureFromHandshake(t *testing.T) {
+	pkts := []gopacket.Packet{
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true,
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: []byte("handshake"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1001, dport: 80, seq: 500, ack: true, payload: []byte("mid-stream"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1002, dport: 80, udp: true, payload: []byte("datagram"),
+		}),
+	}
+
+	for _, zeroCopy := range []bool{false, true} {
+		var opened, result []string
+
+		if err := StartPacketCapture(context.TODO(), CreateHandlerFromPackets(layers.LinkTypeEthernet, pkts...), "", func(pkt *core.Packet) (int, error) {
+			result = append(result, fmt.Sprintf("%s %v", pkt.Data, pkt.FromHandshake))
+			return len(pkt.Data), nil
+		}, WithZeroCopy(zeroCopy), WithSessionOpen(func(flow FlowInfo) {
+			opened = append(opened, fmt.Sprintf("%d %v", flow.Session.SrcPort, flow.FromHandshake))
+		})); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := []string{"1000 true", "1001 false", "1002 false"}; !slices.Equal(opened, expect) {
+			t.Fatalf("session open mismatch with zero copy %v: %v", zeroCopy, opened)
+		}
+
+		if expect := []string{"handshake true", "mid-stream false", "datagram false"}; !slices.Equal(result, expect) {
+			t.Fatalf("packet handshake mismatch with zero copy %v: %v", zeroCopy, result)
+		}
+	}
+}
+
+func TestCaptureStableFlowHash(t *testing.T) {
+	pkts := []gopacket.Packet{
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: []byte("request"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 501, ack: true, payload: []byte("response"),
+		}),
+	}
+
+	for _, stable := range []bool{false, true} {
+		var opts []CaptureOption
+		if stable {
+			opts = append(opts, WithStableFlowHash())
+		}
+
+		var hashes []uint64
+
+		if err := StartPacketCapture(context.TODO(), CreateHandlerFromPackets(layers.LinkTypeEthernet, pkts...), "", func(pkt *core.Packet) (int, error) {
+			hashes = append(hashes, pkt.FlowHash)
+			return len(pkt.Data), nil
+		}, opts...); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := pkts[0].NetworkLayer().NetworkFlow().FastHash()*31 + pkts[0].TransportLayer().TransportFlow().FastHash()
+		if stable {
+			expect = core.FlowKey{
+				Proto: core.TCP, SrcIP: netip.MustParseAddr("192.168.1.1"), SrcPort: 1000,
+				DstIP: netip.MustParseAddr("192.168.1.2"), DstPort: 80,
+			}.StableHash()
+		}
+
+		if !slices.Equal(hashes, []uint64{expect, expect}) {
+			t.Fatalf("flow hash mismatch with stable %v: %v, expected %d", stable, hashes, expect)
+		}
+	}
+}
+
+func TestCaptureTrafficStats(t *testing.T) {
+	eth := func(typ layers.EthernetType) *layers.Ethernet {
+		return &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+			EthernetType: typ,
+		}
+	}
+	ip, _, _ := networkLayer(net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.3"), layers.IPProtocolICMPv4)
+
+	pkts := []gopacket.Packet{
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: make([]byte, 1000),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 501, ack: true, payload: make([]byte, 1000),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 5000, dport: 53, udp: true, payload: []byte("query"),
+		}),
+		buildPacket(t, layers.LinkTypeEthernet, time.Now(), eth(layers.EthernetTypeIPv4), ip, &layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+		}, gopacket.Payload("ping")),
+		buildPacket(t, layers.LinkTypeEthernet, time.Now(), eth(layers.EthernetTypeARP), &layers.ARP{
+			AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+			Operation: layers.ARPRequest, SourceHwAddress: make([]byte, 6), SourceProtAddress: make([]byte, 4),
+			DstHwAddress: make([]byte, 6), DstProtAddress: make([]byte, 4),
+		}),
+	}
+
+	size := func(pkgs ...gopacket.Packet) (n uint64) {
+		for _, pkg := range pkgs {
+			n += uint64(pkg.Metadata().CaptureLength)
+		}
+
+		return
+	}
+
+	for _, zeroCopy := range []bool{false, true} {
+		collector := NewTrafficCollector(1)
+
+		// 处理函数为 nil 时仅统计流量
+		if err := StartCapture(
+			context.TODO(), CreateHandlerFromPackets(layers.LinkTypeEthernet, pkts...), "", nil,
+			WithTrafficStats(collector), WithZeroCopy(zeroCopy),
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := collector.Stats()
+
+		for name, counters := range map[string][2]ProtoCounters{
+			"tcp":   {stats.TCP, {2, size(pkts[:2]...)}},
+			"udp":   {stats.UDP, {1, size(pkts[2])}},
+			"icmp":  {stats.ICMP, {1, size(pkts[3])}},
+			"sctp":  {stats.SCTP, {}},
+			"other": {stats.Other, {1, size(pkts[4])}},
+		} {
+			if counters[0] != counters[1] {
+				t.Errorf("%s counters mismatch with zero copy %v: %+v", name, zeroCopy, counters[0])
+			}
+		}
+
+		// 双向会话合并统计
+		if len(stats.TopTalkers) != 1 || stats.TopTalkers[0].Packets != 2 ||
+			stats.TopTalkers[0].Key.String() != "[tcp] 192.168.1.1:1000 -> 192.168.1.2:80" {
+			t.Fatalf("top talkers mismatch with zero copy %v: %+v", zeroCopy, stats.TopTalkers)
+		}
+	}
+}
+
+func TestTrafficCollectorCapacity(t *testing.T) {
+	collector := NewTrafficCollector(1)
+
+	key := func(port uint16) core.FlowKey {
+		return core.FlowKey{
+			Proto: core.UDP, SrcIP: netip.MustParseAddr("192.168.1.1"), SrcPort: port,
+			DstIP: netip.MustParseAddr("192.168.1.2"), DstPort: 53,
+		}
+	}
+
+	collector.add(key(1000), 10000)
+
+	// 大量小流量会话不影响显著的 TopN 会话
+	for port := uint16(2000); port < 2100; port++ {
+		collector.add(key(port), 10)
+	}
+
+	if len(collector.talkers) > talkerCapacity {
+		t.Fatalf("talker candidates not bounded: %d", len(collector.talkers))
+	}
+
+	if stats := collector.Stats(); len(stats.TopTalkers) != 1 || stats.TopTalkers[0].Key != key(1000).Canonical() ||
+		stats.UDP.Packets != 101 {
+		t.Fatalf("top talker mismatch: %+v, udp: %+v", stats.TopTalkers, stats.UDP)
+	}
+}
+
+func TestCaptureIPOptions(t *testing.T) {
+	build := func(sport int, proto layers.IPProtocol, payload string) gopacket.Packet {
+		ip := &layers.IPv4{
+			Version: 4, TTL: 64, Protocol: proto,
+			SrcIP: net.ParseIP("192.168.1.1").To4(), DstIP: net.ParseIP("192.168.1.2").To4(),
+			// Router Alert 及 NOP 填充, IHL 为 7
+			Options: []layers.IPv4Option{
+				{OptionType: 148, OptionLength: 4, OptionData: []byte{0, 0}},
+				{OptionType: 1}, {OptionType: 1}, {OptionType: 1}, {OptionType: 0},
+			},
+		}
+
+		var transport gopacket.SerializableLayer
+		if proto == layers.IPProtocolTCP {
+			tcp := &layers.TCP{SrcPort: layers.TCPPort(sport), DstPort: 80, Seq: 101, ACK: true, Window: 65535}
+			tcp.SetNetworkLayerForChecksum(ip)
+			transport = tcp
+		} else {
+			udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: 53}
+			udp.SetNetworkLayerForChecksum(ip)
+			transport = udp
+		}
+
+		return buildPacket(t, layers.LinkTypeEthernet, time.Now(), &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+			EthernetType: layers.EthernetTypeIPv4,
+		}, ip, transport, gopacket.Payload(payload))
+	}
+
+	pkts := []gopacket.Packet{
+		build(1000, layers.IPProtocolTCP, "optioned tcp"),
+		build(1001, layers.IPProtocolUDP, "optioned udp"),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1002, dport: 53, udp: true, payload: []byte("plain"),
+		}),
+	}
+
+	if ihl := pkts[0].NetworkLayer().(*layers.IPv4).IHL; ihl != 7 {
+		t.Fatalf("ip options not serialized: %d", ihl)
+	}
+
+	for _, zeroCopy := range []bool{false, true} {
+		for _, drop := range []bool{false, true} {
+			var counter atomic.Uint64
+
+			opts := []CaptureOption{WithZeroCopy(zeroCopy), WithIPOptionsCounter(&counter)}
+			if drop {
+				opts = append(opts, WithDropIPOptions())
+			}
+
+			var result []string
+
+			if err := StartCapture(context.TODO(), CreateHandlerFromPackets(layers.LinkTypeEthernet, pkts...), "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+				result = append(result, fmt.Sprintf("%d %s", session.SrcPort, data))
+				return len(data), nil
+			}, opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			expect := []string{"1000 optioned tcp", "1001 optioned udp", "1002 plain"}
+			if drop {
+				expect = expect[2:]
+			}
+
+			if !slices.Equal(result, expect) || counter.Load() != 2 {
+				t.Fatalf("ip options mismatch with zero copy %v, drop %v: %q, counted: %d", zeroCopy, drop, result, counter.Load())
+			}
+		}
+	}
+}
+
+func TestCaptureRawSegments(t *testing.T) {
+	pkts := feedPackets(
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true,
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 106, ack: true, payload: []byte("second"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: []byte("first"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: []byte("first"),
+		}),
+	)
+
+	var result []string
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		// 未使用的数据不应保留
+		return 0, nil
+	}, newCaptureOptions(WithRawSegments())); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"second", "first", "first"}) {
+		t.Fatalf("raw segments mismatch: %v", result)
+	}
+}
+
+func TestCaptureSYNPayload(t *testing.T) {
+	pkts := feedPackets(
+		// TCP Fast Open: SYN 携带请求数据
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true, payload: []byte("hello"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 500, syn: true, ack: true, payload: []byte("welcome"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 106, ack: true, payload: []byte(" world"),
+		}),
+	)
+
+	var result []string
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, fmt.Sprintf("%d:%s", session.SrcPort, data))
+		return len(data), nil
+	}, newCaptureOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"1000:hello", "80:welcome", "1000: world"}) {
+		t.Fatalf("syn payload mismatch: %q", result)
+	}
+}
+
+func TestCaptureDirectionFilter(t *testing.T) {
+	build := func() []gopacket.Packet {
+		return []gopacket.Packet{
+			buildSegment(t, testSegment{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true}),
+			buildSegment(t, testSegment{src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 500, syn: true, ack: true}),
+			buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, payload: []byte("request"),
+			}),
+			buildSegment(t, testSegment{
+				src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 501, ack: true, payload: []byte("response"),
+			}),
+			// 未观察到握手的会话
+			buildSegment(t, testSegment{
+				src: "192.168.1.3", dst: "192.168.1.2", sport: 2000, dport: 80, seq: 100, ack: true, payload: []byte("midstream"),
+			}),
+		}
+	}
+
+	for _, c := range []struct {
+		direction   DirectionFilter
+		dropUnknown bool
+		expect      []string
+	}{
+		{DirectionAll, true, []string{"request", "response", "midstream"}},
+		{DirectionInitiator, false, []string{"request", "midstream"}},
+		{DirectionResponder, false, []string{"response", "midstream"}},
+		{DirectionResponder, true, []string{"response"}},
+	} {
+		var result []string
+
+		if err := capture(context.TODO(), feedPackets(build()...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, newCaptureOptions(WithDirectionFilter(c.direction, c.dropUnknown), WithLogger(nil))); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(result, c.expect) {
+			t.Fatalf("%s direction (drop unknown %v) delivery mismatch: %v", c.direction, c.dropUnknown, result)
+		}
+	}
+}
+
+func TestCaptureDecodeError(t *testing.T) {
+	ether := func(typ layers.EthernetType) *layers.Ethernet {
+		return &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+			EthernetType: typ,
+		}
+	}
+	ip, _, _ := networkLayer(net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2"), layers.IPProtocolTCP)
+
+	// TCP 头部不完整的损坏报文及 gopacket 不支持的链路层协议
+	garbled := buildPacket(t, layers.LinkTypeEthernet, time.Now(), ether(layers.EthernetTypeIPv4), ip, gopacket.Payload("short")).Data()
+	unsupported := buildPacket(t, layers.LinkTypeEthernet, time.Now(), ether(0x88b5), gopacket.Payload("experimental")).Data()
+	valid := buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, udp: true, payload: []byte("ok"),
+	}).Data()
+
+	for _, zeroCopy := range []bool{false, true} {
+		var buf bytes.Buffer
+		writeFrames(t, &buf, layers.LinkTypeEthernet, garbled, unsupported, valid)
+
+		handler, err := CreateHandlerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			result []string
+			failed [][]byte
+		)
+
+		if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, WithZeroCopy(zeroCopy), WithLogger(nil), WithDecodeError(func(err error, ci gopacket.CaptureInfo, data []byte) {
+			t.Logf("decode error: %v", err)
+			failed = append(failed, slices.Clone(data))
+		})); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(failed) != 1 || !bytes.Equal(failed[0], garbled) {
+			t.Fatalf("decode error mismatch with zero copy %v: %x", zeroCopy, failed)
+		}
+
+		if !slices.Equal(result, []string{"ok"}) {
+			t.Fatalf("delivery mismatch with zero copy %v: %v", zeroCopy, result)
+		}
+	}
+}
+
+func TestCaptureMaxSessionBuffer(t *testing.T) {
+	segment := func(seq uint32, payload string) gopacket.Packet {
+		return buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80,
+			seq: seq, ack: true, payload: []byte(payload),
+		})
+	}
+
+	var (
+		result []string
+		closed []string
+	)
+
+	// 处理函数不消费数据, 第二个数据段超出限制
+	if err := capture(context.TODO(), feedPackets(
+		segment(100, "aaaaaa"), segment(106, "bbbbbb"), segment(112, "cc"),
+	), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return 0, nil
+	}, newCaptureOptions(
+		WithMaxSessionBuffer(10),
+		WithLogger(nil),
+		WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			closed = append(closed, reason.String())
+		}),
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"aaaaaa", "cc"}) {
+		t.Fatalf("delivery mismatch: %v", result)
+	}
+
+	if !slices.Equal(closed, []string{"overflow", "capture_end"}) {
+		t.Fatalf("close reasons mismatch: %v", closed)
+	}
+
+	result, closed = nil, nil
+
+	// 处理函数消费全部数据, 乱序缓存中的数据超出限制, 其后的数据段作为新会话处理
+	if err := capture(context.TODO(), feedPackets(
+		segment(100, "aa"), segment(106, "bbbbbb"), segment(112, "cccccc"), segment(102, "dddd"),
+	), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return len(data), nil
+	}, newCaptureOptions(
+		WithMaxSessionBuffer(10),
+		WithLogger(nil),
+		WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			closed = append(closed, reason.String())
+		}),
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"aa", "dddd"}) || !slices.Equal(closed, []string{"overflow", "capture_end"}) {
+		t.Fatalf("pending overflow mismatch: %v %v", result, closed)
+	}
+}
+
+func TestCaptureProtocolHandler(t *testing.T) {
+	packets := func() <-chan gopacket.Packet {
+		return feedPackets(
+			buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+				seq: 100, ack: true, payload: []byte("tcp"),
+			}),
+			buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+				udp: true, payload: []byte("udp"),
+			}),
+		)
+	}
+
+	var result []string
+
+	record := func(name string) core.DataHandler {
+		return func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, name+" "+string(data))
+			return len(data), nil
+		}
+	}
+
+	if err := capture(
+		context.TODO(), packets(), record("default"),
+		newCaptureOptions(WithProtocolHandler(core.UDP, record("udp"))),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"default tcp", "udp udp"}) {
+		t.Fatalf("protocol dispatch mismatch: %v", result)
+	}
+
+	// 未设置默认处理函数时, 其他协议的数据直接丢弃
+	result = nil
+
+	if err := capture(
+		context.TODO(), packets(), nil,
+		newCaptureOptions(WithProtocolHandler(core.TCP, record("tcp"))),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"tcp tcp"}) {
+		t.Fatalf("protocol handler without default mismatch: %v", result)
+	}
+}
+
+func TestCaptureCopyPayload(t *testing.T) {
+	run := func(opts ...CaptureOption) (stash []string) {
+		var retained [][]byte
+
+		if err := capture(context.TODO(), feedPackets(
+			buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+				udp: true, payload: []byte("hello"),
+			}),
+			buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+				udp: true, payload: []byte("world"),
+			}),
+		), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			retained = append(retained, data)
+			return len(data), nil
+		}, newCaptureOptions(opts...)); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, data := range retained {
+			stash = append(stash, string(data))
+		}
+
+		return
+	}
+
+	// 未拷贝时保留的切片被后续数据覆盖
+	if stash := run(); slices.Equal(stash, []string{"hello", "world"}) {
+		t.Fatalf("retained slice unexpectedly intact: %v", stash)
+	}
+
+	if stash := run(WithCopyPayload()); !slices.Equal(stash, []string{"hello", "world"}) {
+		t.Fatalf("copied payload mismatch: %v", stash)
+	}
+}
+
+func TestCaptureFraming(t *testing.T) {
+	run := func(payloads []string, opts ...CaptureOption) (result []string) {
+		var packets []gopacket.Packet
+
+		seq := uint32(101)
+		for _, payload := range payloads {
+			packets = append(packets, buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+				seq: seq, ack: true, payload: []byte(payload),
+			}))
+			seq += uint32(len(payload))
+		}
+
+		if err := capture(context.TODO(), feedPackets(packets...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, newCaptureOptions(opts...)); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	// 不完整的尾部数据跨越多个数据段保留
+	if result := run(
+		[]string{"GET a\r\nGE", "T b\r\nPAR", "T", "IAL\r", "\ntail"},
+		WithDelimiter([]byte("\r\n")),
+	); !slices.Equal(result, []string{"GET a\r\n", "GET b\r\n", "PARTIAL\r\n"}) {
+		t.Fatalf("delimiter framing mismatch: %q", result)
+	}
+
+	// 记录头跨越数据段: 握手记录 3 字节负载, 应用数据记录 5 字节负载
+	handshake := "\x16\x03\x01\x00\x03abc"
+	appData := "\x17\x03\x03\x00\x05hello"
+
+	if result := run(
+		[]string{handshake + appData[:2], appData[2:4], appData[4:] + "\x17\x03"},
+		WithTLSRecordFraming(),
+	); !slices.Equal(result, []string{handshake, appData}) {
+		t.Fatalf("tls record framing mismatch: %q", result)
+	}
+
+	if result := run(
+		[]string{"abcdefghij", "kl", "m"},
+		WithFixedFrameSize(4),
+	); !slices.Equal(result, []string{"abcd", "efgh", "ijkl"}) {
+		t.Fatalf("fixed size framing mismatch: %q", result)
+	}
+}
+
+func TestCaptureSampling(t *testing.T) {
+	run := func(segs []testSegment, opts ...CaptureOption) (result []string) {
+		var packets []gopacket.Packet
+		for _, seg := range segs {
+			packets = append(packets, buildSegment(t, seg))
+		}
+
+		if err := capture(context.TODO(), feedPackets(packets...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, newCaptureOptions(opts...)); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	var (
+		segs   []testSegment
+		expect []string
+	)
+
+	for port := 1000; port < 1016; port++ {
+		req := testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: port, dport: 2000,
+			udp: true, payload: []byte(fmt.Sprintf("req-%d", port)),
+		}
+		rsp := testSegment{
+			src: "192.168.1.2", dst: "192.168.1.1", sport: 2000, dport: port,
+			udp: true, payload: []byte(fmt.Sprintf("rsp-%d", port)),
+		}
+		segs = append(segs, req, rsp)
+
+		session := core.Session{
+			Proto: core.UDP, SrcIP: net.ParseIP(req.src), SrcPort: port, DstIP: net.ParseIP(req.dst), DstPort: 2000,
+		}
+		if sessionHash(&session)%4 == 0 {
+			expect = append(expect, string(req.payload), string(rsp.payload))
+		}
+	}
+
+	// 按会话抽样时选中会话的双向数据均交付
+	if result := run(segs, WithSampling(4, true)); len(expect) <= 0 || !slices.Equal(result, expect) {
+		t.Fatalf("flow sampling mismatch: %v, expect: %v", result, expect)
+	}
+
+	if result := run(segs[:7], WithSampling(3, false)); !slices.Equal(result, []string{"req-1000", "rsp-1001", "req-1003"}) {
+		t.Fatalf("packet sampling mismatch: %v", result)
+	}
+
+	if result := run(segs, WithSampling(1, false)); len(result) != len(segs) {
+		t.Fatalf("sampling disabled mismatch: %d", len(result))
+	}
+}
+
+func TestCaptureDedup(t *testing.T) {
+	base := time.Now()
+
+	var packets []gopacket.Packet
+	for _, seg := range []testSegment{
+		{seq: 100, syn: true},
+		{seq: 101, ack: true, payload: []byte("first")},
+		{seq: 106, ack: true, payload: []byte("second")},
+		{udp: true, payload: []byte("datagram")},
+	} {
+		seg.src, seg.dst, seg.sport, seg.dport = "192.168.1.1", "192.168.1.2", 1000, 2000
+
+		// SPAN 镜像的重复报文相隔不足 1ms
+		seg.ts = base
+		packets = append(packets, buildSegment(t, seg))
+		seg.ts = base.Add(100 * time.Microsecond)
+		packets = append(packets, buildSegment(t, seg))
+
+		base = base.Add(10 * time.Millisecond)
+	}
+
+	// 窗口之外的相同数据报为正常的重复发送
+	packets = append(packets, buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("datagram"), ts: base.Add(time.Second),
+	}))
+
+	// 窗口之内 IP 标识不同的相同数据报同样为正常的重复发送
+	packets = append(packets, buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("datagram"), ts: base.Add(time.Second + 100*time.Microsecond), ipID: 1,
+	}))
+
+	run := func(opts ...CaptureOption) (result []string) {
+		if err := capture(context.TODO(), feedPackets(packets...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, session.Proto.String()+" "+string(data))
+			return len(data), nil
+		}, newCaptureOptions(opts...)); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	// TCP 重组已丢弃重复的数据段, UDP 重复数据报将被重复交付
+	if result := run(); !slices.Equal(result, []string{
+		"tcp first", "tcp second", "udp datagram", "udp datagram", "udp datagram", "udp datagram",
+	}) {
+		t.Fatalf("delivery without dedup mismatch: %v", result)
+	}
+
+	if result := run(WithDedup(time.Millisecond)); !slices.Equal(result, []string{
+		"tcp first", "tcp second", "udp datagram", "udp datagram", "udp datagram",
+	}) {
+		t.Fatalf("dedup delivery mismatch: %v", result)
+	}
+}
@@ -0,0 +1,20 @@
+package pcap
+
+import (
+	origin_errors "errors"
+)
+
+// CreateHandler 等创建数据源时返回的错误原因, 以 errors.Is 判断
+var (
+	ErrInvalidDataSource = origin_errors.New("invalid data source")
+	ErrUnknownProtocol   = origin_errors.New("unknown pcap protocol")
+	ErrInterfaceNotFound = origin_errors.New("no interface found")
+	// ErrPermissionDenied 无抓包权限, 通常需要 root 或 CAP_NET_RAW
+	ErrPermissionDenied = origin_errors.New("capture permission denied")
+	// ErrPromiscDenied 无权开启混杂模式, 可以 WithPromiscuous(false) 关闭后重试
+	ErrPromiscDenied = origin_errors.New("promiscuous mode denied")
+)
+
+// ErrSourceFailed 数据源无法继续读取, 如多文件数据源的后续文件打开失败或链路类型不一致,
+// 抓包随即结束并返回该错误, 以 errors.Is 判断
+var ErrSourceFailed = origin_errors.New("packet source failed")
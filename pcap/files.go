@@ -0,0 +1,166 @@
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+)
+
+// CreateHandlerFromFiles 按顺序读取多个离线文件, 作为单个连续的数据源,
+// 文件边界不影响会话重组, 各文件需具有相同的链路类型;
+// 后续文件打开失败或链路类型不一致时抓包结束并返回以 ErrSourceFailed 包装的错误
+func CreateHandlerFromFiles(paths ...string) (PacketSource, error) {
+	switch len(paths) {
+	case 0:
+		return nil, errors.WithStack(fmt.Errorf("%w: no capture file specified", ErrInvalidDataSource))
+	case 1:
+		return openFile(paths[0])
+	}
+
+	src, err := openFile(paths[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileChain{
+		paths:    paths[1:],
+		current:  src,
+		linkType: src.LinkType(),
+	}, nil
+}
+
+// openFiles 打开 file:// 数据源, 路径包含通配符时连续读取全部匹配文件
+func openFiles(pattern string, _ *handlerOptions) (PacketSource, error) {
+	paths, err := globFiles(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateHandlerFromFiles(paths...)
+}
+
+// globFiles 展开包含通配符的文件路径, 匹配结果按文件名排序
+func globFiles(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(paths) <= 0 {
+		return nil, errors.WithStack(fmt.Errorf("%w: no capture file matched: %s", ErrInvalidDataSource, pattern))
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// fileChain 多文件数据源, 当前文件读取完毕后关闭并打开下一个文件,
+// 切换文件失败时此后的读取均返回以 ErrSourceFailed 包装的错误
+type fileChain struct {
+	paths    []string
+	current  PacketSource
+	linkType layers.LinkType
+	err      error
+}
+
+// LinkType 返回首个文件的链路类型
+func (src *fileChain) LinkType() layers.LinkType {
+	return src.linkType
+}
+
+func (src *fileChain) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(func(current PacketSource) packetReader {
+		return current.ReadPacketData
+	})
+}
+
+// ZeroCopyReadPacketData 零拷贝读取, 当前文件不支持零拷贝时退化为普通读取
+func (src *fileChain) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(zeroCopyRead)
+}
+
+func (src *fileChain) read(reader func(PacketSource) packetReader) (data []byte, ci gopacket.CaptureInfo, err error) {
+	for src.err == nil && src.current != nil {
+		if data, ci, err = reader(src.current)(); err != io.EOF {
+			return
+		}
+
+		if err = src.next(); err != nil {
+			src.err = errors.WithStack(fmt.Errorf("%w: %w", ErrSourceFailed, err))
+		}
+	}
+
+	if src.err != nil {
+		return nil, ci, src.err
+	}
+
+	return nil, ci, io.EOF
+}
+
+// failure 返回切换文件时的错误, 抓包结束时由 Capture 返回
+func (src *fileChain) failure() error {
+	return src.err
+}
+
+func (src *fileChain) next() error {
+	if err := closeSource(src.current); err != nil {
+		return err
+	}
+
+	src.current = nil
+
+	if len(src.paths) <= 0 {
+		return nil
+	}
+
+	path := src.paths[0]
+	src.paths = src.paths[1:]
+
+	next, err := openFile(path)
+	if err != nil {
+		return err
+	}
+
+	if linkType := next.LinkType(); linkType != src.linkType {
+		closeSource(next)
+		return errors.Errorf(
+			"link type mismatch in %s: %s, expected %s",
+			path, linkType, src.linkType,
+		)
+	}
+
+	src.current = next
+
+	return nil
+}
+
+// Decode 按当前文件的解码方式解码, 读取与解码顺序执行, 因此可安全使用
+func (src *fileChain) Decode(data []byte, p gopacket.PacketBuilder) error {
+	if decoder, ok := src.current.(gopacket.Decoder); ok {
+		return decoder.Decode(data, p)
+	}
+
+	return linkDecoder(src.linkType).Decode(data, p)
+}
+
+func (src *fileChain) Close() error {
+	if src.current == nil {
+		return nil
+	}
+
+	err := closeSource(src.current)
+	src.current = nil
+
+	return err
+}
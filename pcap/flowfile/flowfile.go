@@ -0,0 +1,138 @@
+// Package flowfile 基于 pcap 会话重组将各会话方向的数据随重组写入独立文件, 即 "跟踪 TCP 流" 至磁盘
+package flowfile
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/frozenpine/pkt4go/pcap"
+	"github.com/pkg/errors"
+)
+
+const (
+	// RequestSuffix 客户端至服务端方向的文件后缀
+	RequestSuffix = ".req"
+	// ResponseSuffix 服务端至客户端方向的文件后缀
+	ResponseSuffix = ".resp"
+)
+
+// Start 抓包并将各会话方向的重组数据写入 dir 下的独立文件, 参数与 pcap.StartCapture 相同, dir 不存在时创建.
+//
+// 双向会话的文件均以客户端在前的 srcip_srcport-dstip_dstport 命名, 客户端至服务端方向后缀为 RequestSuffix,
+// 反向为 ResponseSuffix; 未观察到握手的会话以端口较大一侧视为客户端, 端口相同时以地址较小一侧视为客户端, IPv6 地址中的 ":" 以 "." 替代.
+// 文件以追加方式写入, 会话结束时关闭, 同一会话再次出现时重新打开追加, 以限制同时打开的文件数量;
+// 写入失败时按 pcap.WithErrorPolicy 处理. ICMP 报文无会话, 不写入文件;
+// opts 中的 pcap.WithSessionClose 回调在 flowfile 关闭会话文件后调用
+func Start(ctx context.Context, handler pcap.PacketSource, filter string, dir string, opts ...pcap.CaptureOption) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	w := writer{
+		dir:   dir,
+		files: make(map[core.FlowKey]*os.File),
+	}
+
+	err := pcap.StartPacketCapture(
+		ctx, handler, filter, w.handle,
+		append(opts, pcap.WithSessionCloseChain(w.close))...,
+	)
+
+	// 抓包提前结束时, 会话结束回调可能未覆盖全部会话
+	if closeErr := w.closeAll(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Name 会话方向对应的文件名, 不含目录
+func Name(key core.FlowKey, dir core.Direction) string {
+	client, suffix := key, RequestSuffix
+
+	switch dir {
+	case core.DirectionServerToClient:
+		client, suffix = key.Reverse(), ResponseSuffix
+	case core.DirectionUnknown:
+		// 端口相同时与 FlowKey.Canonical 一致, 以地址较小一侧视为客户端
+		if key.SrcPort < key.DstPort || key.SrcPort == key.DstPort && key.SrcIP.Compare(key.DstIP) > 0 {
+			client, suffix = key.Reverse(), ResponseSuffix
+		}
+	}
+
+	return endpoint(client.SrcIP, client.SrcPort) + "-" + endpoint(client.DstIP, client.DstPort) + suffix
+}
+
+func endpoint(ip netip.Addr, port uint16) string {
+	return strings.ReplaceAll(ip.Unmap().String(), ":", ".") + "_" + strconv.Itoa(int(port))
+}
+
+type writer struct {
+	dir   string
+	mu    sync.Mutex
+	files map[core.FlowKey]*os.File
+}
+
+func (w *writer) handle(pkt *core.Packet) (int, error) {
+	if pkt.Protocol == core.ICMP || pkt.Protocol == core.ICMPv6 {
+		return len(pkt.Data), nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	file, exist := w.files[pkt.Key]
+	if !exist {
+		var err error
+
+		if file, err = os.OpenFile(
+			filepath.Join(w.dir, Name(pkt.Key, pkt.Direction)),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644,
+		); err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		w.files[pkt.Key] = file
+	}
+
+	if _, err := file.Write(pkt.Data); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return len(pkt.Data), nil
+}
+
+// close 会话结束时关闭对应文件
+func (w *writer) close(flow pcap.FlowInfo, _ pcap.CloseReason) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := flow.Session.Key()
+
+	if file, exist := w.files[key]; exist {
+		delete(w.files, key)
+		file.Close()
+	}
+}
+
+// closeAll 关闭全部文件, 返回首个关闭错误
+func (w *writer) closeAll() (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, file := range w.files {
+		delete(w.files, key)
+
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = errors.WithStack(closeErr)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,116 @@
+package flowfile
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/frozenpine/pkt4go/pcap"
+	"github.com/frozenpine/pkt4go/pcap/internal/pcaptest"
+	"github.com/google/gopacket/layers"
+)
+
+func TestStart(t *testing.T) {
+	client := &pcaptest.TCPStream{
+		Src: net.IPv4(192, 168, 1, 1).To4(), Dst: net.IPv4(192, 168, 1, 2).To4(),
+		SrcPort: 1000, DstPort: 80, Seq: 100,
+	}
+	server := &pcaptest.TCPStream{
+		Src: client.Dst, Dst: client.Src,
+		SrcPort: client.DstPort, DstPort: client.SrcPort, Seq: 500,
+	}
+	// 未观察到握手的会话
+	mid := &pcaptest.TCPStream{
+		Src: client.Src, Dst: client.Dst,
+		SrcPort: 50000, DstPort: 443, Seq: 900,
+	}
+
+	packets := [][]byte{
+		client.Segment(t, true, false, ""),
+		server.Segment(t, true, false, ""),
+		client.Segment(t, false, false, "GET / HTTP/1.1\r\n"),
+		server.Segment(t, false, false, "HTTP/1.1 200 OK\r\n"),
+		mid.Segment(t, false, false, "resumed"),
+		client.Segment(t, false, true, ""),
+		server.Segment(t, false, true, ""),
+	}
+
+	// 会话结束后复用相同端口重新建立连接, 数据追加至已有文件
+	client.Seq, server.Seq = 2000, 3000
+	packets = append(packets,
+		client.Segment(t, true, false, ""),
+		server.Segment(t, true, false, ""),
+		client.Segment(t, false, false, "GET /again HTTP/1.1\r\n"),
+	)
+
+	dir := filepath.Join(t.TempDir(), "flows")
+
+	// 调用方的会话结束回调与 flowfile 的回调同时生效
+	closed := 0
+
+	if err := Start(
+		context.TODO(), pcap.CreateHandlerFromData(layers.LinkTypeEthernet, packets...), "", dir,
+		pcap.WithSessionClose(func(pcap.FlowInfo, pcap.CloseReason) { closed++ }),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if closed == 0 {
+		t.Error("session close handler not called")
+	}
+
+	for name, expect := range map[string]string{
+		"192.168.1.1_1000-192.168.1.2_80.req":   "GET / HTTP/1.1\r\nGET /again HTTP/1.1\r\n",
+		"192.168.1.1_1000-192.168.1.2_80.resp":  "HTTP/1.1 200 OK\r\n",
+		"192.168.1.1_50000-192.168.1.2_443.req": "resumed",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != expect {
+			t.Errorf("flow file %s mismatch: %q", name, data)
+		}
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Fatalf("unexpected flow files: %d", len(entries))
+	}
+}
+
+func TestName(t *testing.T) {
+	key := core.FlowKey{
+		Proto:   core.TCP,
+		SrcIP:   netip.MustParseAddr("2001:db8::1"),
+		SrcPort: 80,
+		DstIP:   netip.MustParseAddr("2001:db8::2"),
+		DstPort: 40000,
+	}
+
+	for dir, expect := range map[core.Direction]string{
+		core.DirectionServerToClient: "2001.db8..2_40000-2001.db8..1_80.resp",
+		core.DirectionUnknown:        "2001.db8..2_40000-2001.db8..1_80.resp",
+		core.DirectionClientToServer: "2001.db8..1_80-2001.db8..2_40000.req",
+	} {
+		if name := Name(key, dir); name != expect {
+			t.Errorf("flow file name mismatch for %s: %s", dir, name)
+		}
+	}
+
+	// 端口相同时双向均以地址较小一侧视为客户端
+	key.SrcPort, key.DstPort = 5060, 5060
+
+	for key, expect := range map[core.FlowKey]string{
+		key:           "2001.db8..1_5060-2001.db8..2_5060.req",
+		key.Reverse(): "2001.db8..1_5060-2001.db8..2_5060.resp",
+	} {
+		if name := Name(key, core.DirectionUnknown); name != expect {
+			t.Errorf("flow file name mismatch for equal ports %s: %s", key, name)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+)
+
+// flowReaderBuffer 单个 FlowReader 未读取数据的上限, 超出时抓包等待读取
+const flowReaderBuffer = 1 << 20
+
+// FlowHandler 新会话回调, 在独立协程中调用, 返回时关闭 r
+type FlowHandler func(r *FlowReader)
+
+// FlowReader 单向会话重组数据的流式读取器, 可直接交由 bufio.Scanner, json.Decoder 等读取;
+// 会话正常结束时 Read 返回 io.EOF, 因淘汰, 缓存超限, 超出生存时间或处理出错结束时返回 io.ErrUnexpectedEOF,
+// 抓包因 ctx 结束时返回 ctx 的错误, 结束前已重组的数据均可读取
+type FlowReader struct {
+	Session *core.Session
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	err    error
+	closed bool
+}
+
+func newFlowReader(session *core.Session) *FlowReader {
+	r := FlowReader{Session: session}
+	r.cond = sync.NewCond(&r.mu)
+
+	return &r
+}
+
+// Read 读取重组数据, 无数据时阻塞直至收到后续数据或会话结束
+func (r *FlowReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.buf.Len() <= 0 && r.err == nil && !r.closed {
+		r.cond.Wait()
+	}
+
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if r.buf.Len() <= 0 {
+		return 0, r.err
+	}
+
+	n, _ := r.buf.Read(p)
+	r.cond.Broadcast()
+
+	return n, nil
+}
+
+// Close 停止读取, 会话的后续数据将被丢弃
+func (r *FlowReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	r.buf = bytes.Buffer{}
+	r.cond.Broadcast()
+
+	return nil
+}
+
+// write 写入重组数据, 未读取数据超出上限时等待, 读取器关闭, 会话结束或 ctx 结束时丢弃数据
+func (r *FlowReader) write(ctx context.Context, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for !r.closed && r.err == nil && ctx.Err() == nil &&
+		r.buf.Len() > 0 && r.buf.Len()+len(data) > flowReaderBuffer {
+		r.cond.Wait()
+	}
+
+	if r.closed || r.err != nil || ctx.Err() != nil {
+		return
+	}
+
+	r.buf.Write(data)
+	r.cond.Broadcast()
+}
+
+// finish 结束写入, 剩余数据读取完毕后 Read 返回 err
+func (r *FlowReader) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = err
+	}
+	r.cond.Broadcast()
+}
+
+// wake 唤醒等待中的读写, 用于 ctx 结束时
+func (r *FlowReader) wake() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cond.Broadcast()
+}
+
+type flowReaders struct {
+	ctx     context.Context
+	fn      FlowHandler
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	readers map[*core.Session]*FlowReader
+}
+
+// StartFlowCapture 开始抓包, 每个会话方向收到首个数据时以 FlowReader 交由 fn, 参数与 StartCapture 相同;
+// 读取慢于抓包时, 抓包将在该会话未读取数据超出上限后等待, 因此各 fn 需持续读取或及时关闭读取器.
+// 抓包结束时全部会话随之结束, 等待全部 fn 返回后返回; ICMP 报文无会话, 不以 FlowReader 交付
+func StartFlowCapture(ctx context.Context, handler PacketSource, filter string, fn FlowHandler, opts ...CaptureOption) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	fr := flowReaders{
+		ctx:     ctx,
+		fn:      fn,
+		readers: make(map[*core.Session]*FlowReader),
+	}
+
+	stop := context.AfterFunc(ctx, fr.wake)
+	defer stop()
+
+	err := StartCapture(ctx, handler, filter, fr.handle, append(opts, WithSessionCloseChain(fr.close))...)
+
+	// 抓包因数据源读取失败等原因提前结束时, 会话结束回调可能未覆盖全部会话
+	fr.finishAll(io.ErrUnexpectedEOF)
+	fr.wg.Wait()
+
+	return err
+}
+
+func (fr *flowReaders) handle(session *core.Session, _ time.Time, data []byte) (int, error) {
+	if session.Proto == core.ICMP || session.Proto == core.ICMPv6 {
+		return len(data), nil
+	}
+
+	fr.mu.Lock()
+	r, exist := fr.readers[session]
+	if !exist {
+		r = newFlowReader(session)
+		fr.readers[session] = r
+
+		fr.wg.Add(1)
+		go func() {
+			defer fr.wg.Done()
+			defer r.Close()
+
+			fr.fn(r)
+		}()
+	}
+	fr.mu.Unlock()
+
+	r.write(fr.ctx, data)
+
+	return len(data), nil
+}
+
+func (fr *flowReaders) close(flow FlowInfo, reason CloseReason) {
+	fr.mu.Lock()
+	r, exist := fr.readers[flow.Session]
+	delete(fr.readers, flow.Session)
+	fr.mu.Unlock()
+
+	if !exist {
+		return
+	}
+
+	switch {
+	case fr.ctx.Err() != nil:
+		r.finish(fr.ctx.Err())
+	case reason == CloseEviction || reason == CloseOverflow ||
+		reason == CloseHandlerError || reason == CloseMaxLifetime:
+		r.finish(io.ErrUnexpectedEOF)
+	default:
+		r.finish(io.EOF)
+	}
+}
+
+func (fr *flowReaders) finishAll(err error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	for session, r := range fr.readers {
+		delete(fr.readers, session)
+
+		if fr.ctx.Err() != nil {
+			r.finish(fr.ctx.Err())
+		} else {
+			r.finish(err)
+		}
+	}
+}
+
+func (fr *flowReaders) wake() {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	for _, r := range fr.readers {
+		r.wake()
+	}
+}
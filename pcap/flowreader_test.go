@@ -0,0 +1,104 @@
+package pcap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestStartFlowCapture(t *testing.T) {
+	var packets []gopacket.Packet
+	for _, seg := range []testSegment{
+		{seq: 100, syn: true},
+		{seq: 101, ack: true, payload: []byte("first\nsec")},
+		{seq: 110, ack: true, payload: []byte("ond\nthird\n")},
+		{seq: 120, ack: true, fin: true},
+	} {
+		seg.src, seg.dst, seg.sport, seg.dport = "192.168.1.1", "192.168.1.2", 1000, 2000
+		packets = append(packets, buildSegment(t, seg))
+	}
+
+	var (
+		mu     sync.Mutex
+		lines  []string
+		result error
+	)
+
+	if err := StartFlowCapture(context.TODO(), CreateHandlerFromPackets(layers.LinkTypeEthernet, packets...), "", func(r *FlowReader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			mu.Lock()
+			lines = append(lines, r.Session.String()+" "+scanner.Text())
+			mu.Unlock()
+		}
+
+		mu.Lock()
+		result = scanner.Err()
+		mu.Unlock()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"[tcp] 192.168.1.1:1000 -> 192.168.1.2:2000 first",
+		"[tcp] 192.168.1.1:1000 -> 192.168.1.2:2000 second",
+		"[tcp] 192.168.1.1:1000 -> 192.168.1.2:2000 third",
+	}
+
+	if !slices.Equal(lines, expect) || result != nil {
+		t.Fatalf("flow reader mismatch: %v, %v", lines, result)
+	}
+}
+
+func TestStartFlowCaptureCancel(t *testing.T) {
+	src := make(chanSource, 1)
+	src <- buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("pending"),
+	})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var (
+		data   []byte
+		result error
+	)
+
+	if err := StartFlowCapture(ctx, src, "", func(r *FlowReader) {
+		data, result = io.ReadAll(r)
+	}, WithIdleTimeout(0), WithSessionOpen(func(FlowInfo) {
+		time.AfterFunc(10*time.Millisecond, cancel)
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "pending" || !errors.Is(result, context.Canceled) {
+		t.Fatalf("canceled flow reader mismatch: %q, %v", data, result)
+	}
+}
+
+func TestStartFlowCaptureClosedReader(t *testing.T) {
+	var data [][]byte
+	for range 3 {
+		data = append(data, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: make([]byte, 1024),
+		}).Data())
+	}
+
+	// 读取器关闭后的数据直接丢弃, 不阻塞抓包
+	if err := StartFlowCapture(context.TODO(), CreateHandlerFromData(layers.LinkTypeEthernet, data...), "", func(r *FlowReader) {
+		r.Close()
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
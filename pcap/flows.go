@@ -0,0 +1,310 @@
+package pcap
+
+import (
+	"container/list"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+)
+
+type flow struct {
+	key      string
+	session  *core.Session
+	cache    *core.StreamCache
+	stream   tcpStream
+	sctp     *sctpAssembler
+	lastSeen time.Time
+	created  time.Time
+	// lastPacket 最近一个数据包的抓取时间, lastSeen 及 created 为处理时的本地时间
+	lastPacket time.Time
+	hash       uint64
+	iface      int
+	route      int
+	// handshake 会话是否自 SYN 开始重组
+	handshake bool
+	counters  FlowCounters
+}
+
+func (f *flow) info() FlowInfo {
+	return FlowInfo{
+		Session:       f.session,
+		Hash:          f.hash,
+		Interface:     f.iface,
+		FromHandshake: f.handshake,
+		Counters:      f.counters,
+	}
+}
+
+// count 累计归属该会话的数据包
+func (f *flow) count(captured int) {
+	f.counters.Packets++
+	f.counters.CapturedBytes += uint64(captured)
+}
+
+// flowTable 会话缓存表, 按最近活动时间维护LRU顺序,
+// 以包含传输层协议的会话描述为键, 相同地址端口的 TCP 与 UDP 会话互不影响,
+// 开启 WithVLANKey 时键中附加 VLAN 及 MPLS 标签
+type flowTable struct {
+	opts  *captureOptions
+	flows map[string]*list.Element
+	lru   *list.List
+	// iface 当前处理数据包所在接口序号, captured 为其抓取长度, labels 为其 MPLS 标签栈,
+	// route 为其匹配的 WithFilterHandler 序号, handshake 为其是否为 TCP SYN, timestamp 为其抓取时间,
+	// 由调用方在处理数据包前设置
+	iface     int
+	captured  int
+	labels    []uint32
+	route     int
+	handshake bool
+	timestamp time.Time
+}
+
+func newFlowTable(opts *captureOptions) *flowTable {
+	return &flowTable{
+		opts:  opts,
+		flows: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+func (tbl *flowTable) Len() int {
+	return tbl.lru.Len()
+}
+
+// key 返回会话缓存键, 开启 WithVLANKey 时附加 VLAN ID 及当前数据包的 MPLS 标签栈
+func (tbl *flowTable) key(session *core.Session) string {
+	key := session.String()
+
+	if !tbl.opts.vlanKey {
+		return key
+	}
+
+	for _, id := range session.VLANs {
+		key += " vlan:" + strconv.Itoa(int(id))
+	}
+
+	for _, label := range tbl.labels {
+		key += " mpls:" + strconv.FormatUint(uint64(label), 10)
+	}
+
+	return key
+}
+
+// hash 会话哈希, 开启 WithStableFlowHash 时为 FlowKey.StableHash
+func (tbl *flowTable) hash(session *core.Session) uint64 {
+	if tbl.opts.stableHash {
+		return session.Key().StableHash()
+	}
+
+	return sessionHash(session)
+}
+
+// Lookup 获取已存在的会话缓存, 并标记为最近活动
+func (tbl *flowTable) Lookup(session *core.Session, now time.Time) *flow {
+	elem, exist := tbl.flows[tbl.key(session)]
+	if !exist {
+		return nil
+	}
+
+	tbl.lru.MoveToFront(elem)
+	f := elem.Value.(*flow)
+	f.lastSeen, f.lastPacket = now, tbl.timestamp
+	f.count(tbl.captured)
+
+	return f
+}
+
+// Peek 获取已存在的会话缓存, 不更新活动时间及计数
+func (tbl *flowTable) Peek(session *core.Session) *flow {
+	if elem, exist := tbl.flows[tbl.key(session)]; exist {
+		return elem.Value.(*flow)
+	}
+
+	return nil
+}
+
+// Get 获取会话缓存, 不存在时创建, 并标记为最近活动
+func (tbl *flowTable) Get(session *core.Session, now time.Time) *flow {
+	if f := tbl.Lookup(session, now); f != nil {
+		return f
+	}
+
+	key := tbl.key(session)
+
+	// 先淘汰再插入, 确保当前活动会话不会被淘汰
+	if max := tbl.opts.maxSessions; max > 0 {
+		for tbl.lru.Len() >= max {
+			tbl.evict()
+		}
+	}
+
+	// session 可能引用复用的读取缓冲区, 缓存时需独立拷贝
+	f := &flow{
+		key:        key,
+		session:    session.Clone(),
+		cache:      core.NewStreamCacheSize(tbl.opts.bufferSize),
+		lastSeen:   now,
+		created:    now,
+		lastPacket: tbl.timestamp,
+		hash:       tbl.hash(session),
+		iface:      tbl.iface,
+		route:      tbl.route,
+		handshake:  tbl.handshake,
+	}
+	f.count(tbl.captured)
+	tbl.flows[key] = tbl.lru.PushFront(f)
+
+	if tbl.opts.onOpen != nil {
+		tbl.opts.onOpen(f.info())
+	}
+
+	return f
+}
+
+// Each 按最久未活动优先的顺序遍历会话缓存, fn 返回 false 时停止遍历
+func (tbl *flowTable) Each(fn func(f *flow) bool) {
+	for elem := tbl.lru.Back(); elem != nil; elem = elem.Prev() {
+		if !fn(elem.Value.(*flow)) {
+			return
+		}
+	}
+}
+
+// Delete 删除会话对应的缓存
+func (tbl *flowTable) Delete(session *core.Session, reason CloseReason) {
+	tbl.remove(tbl.key(session), reason)
+}
+
+// Remove 删除会话缓存
+func (tbl *flowTable) Remove(f *flow, reason CloseReason) {
+	tbl.remove(f.key, reason)
+}
+
+// Clear 删除全部会话缓存
+func (tbl *flowTable) Clear(reason CloseReason) {
+	for elem := tbl.lru.Back(); elem != nil; elem = tbl.lru.Back() {
+		tbl.Remove(elem.Value.(*flow), reason)
+	}
+}
+
+func (tbl *flowTable) remove(key string, reason CloseReason) {
+	elem, exist := tbl.flows[key]
+	if !exist {
+		return
+	}
+
+	tbl.lru.Remove(elem)
+	delete(tbl.flows, key)
+
+	f := elem.Value.(*flow)
+
+	// 未交付的乱序数据计入丢失
+	if pending := f.stream.Pending() + f.sctp.Pending(); pending > 0 {
+		f.counters.LostBytes += uint64(pending)
+
+		tbl.opts.logger.Debug(
+			"session removed with pending segments:",
+			slog.String("session", key),
+			slog.String("reason", reason.String()),
+			slog.Int("pending", pending),
+		)
+	}
+
+	if tbl.opts.onClose != nil {
+		tbl.opts.onClose(f.info(), reason)
+	}
+
+	// 会话结束后归还缓冲区, 供后续新建会话复用
+	f.cache.Release()
+}
+
+func (tbl *flowTable) evict() {
+	elem := tbl.lru.Back()
+	if elem == nil {
+		return
+	}
+
+	f := elem.Value.(*flow)
+
+	tbl.opts.logger.Debug(
+		"session evicted for exceeding max sessions:",
+		slog.String("session", f.key),
+		slog.Int("remain", f.cache.Len()),
+	)
+
+	tbl.Remove(f, CloseEviction)
+
+	if tbl.opts.evicted != nil {
+		tbl.opts.evicted.Add(1)
+	}
+
+	tbl.opts.observer.SessionEvicted()
+}
+
+// SweepInterval 会话清理周期, 为空闲超时及最长生存时间中较短者的一半, 均未启用时为 0
+func (tbl *flowTable) SweepInterval() time.Duration {
+	interval := tbl.opts.idleTimeout
+
+	if lifetime := tbl.opts.maxLifetime; lifetime > 0 && (interval <= 0 || lifetime < interval) {
+		interval = lifetime
+	}
+
+	if interval <= 0 {
+		return 0
+	}
+
+	return interval / 2
+}
+
+// Sweep 清理在 now 之前已超出最长生存时间或空闲超时的会话
+func (tbl *flowTable) Sweep(now time.Time) {
+	if lifetime := tbl.opts.maxLifetime; lifetime > 0 {
+		deadline := now.Add(-lifetime)
+
+		// 创建时间与活动顺序无关, 需遍历全部会话
+		for elem := tbl.lru.Back(); elem != nil; {
+			f := elem.Value.(*flow)
+			elem = elem.Prev()
+
+			if f.created.After(deadline) {
+				continue
+			}
+
+			tbl.opts.logger.Debug(
+				"session removed for exceeding max lifetime:",
+				slog.String("session", f.key),
+				slog.Time("created", f.created),
+				slog.Int("remain", f.cache.Len()),
+			)
+
+			tbl.Remove(f, CloseMaxLifetime)
+		}
+	}
+
+	timeout := tbl.opts.idleTimeout
+	if timeout <= 0 {
+		return
+	}
+
+	deadline := now.Add(-timeout)
+
+	// LRU尾部为最久未活动的会话, 遇到未超时的会话即可停止
+	for elem := tbl.lru.Back(); elem != nil; elem = tbl.lru.Back() {
+		f := elem.Value.(*flow)
+
+		if f.lastSeen.After(deadline) {
+			return
+		}
+
+		tbl.opts.logger.Debug(
+			"idle session removed:",
+			slog.String("session", f.key),
+			slog.Time("last_seen", f.lastSeen),
+			slog.Int("remain", f.cache.Len()),
+		)
+
+		tbl.Remove(f, CloseTimeout)
+	}
+}
@@ -0,0 +1,307 @@
+package pcap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+)
+
+func testSession(port int) *core.Session {
+	return &core.Session{
+		Proto:   core.TCP,
+		SrcIP:   net.ParseIP("192.168.1.1"),
+		SrcPort: port,
+		DstIP:   net.ParseIP("192.168.1.2"),
+		DstPort: 80,
+	}
+}
+
+func TestFlowTableLRU(t *testing.T) {
+	var evicted atomic.Uint64
+
+	now := time.Now()
+	tbl := newFlowTable(newCaptureOptions(
+		WithMaxSessions(2), WithEvictedCounter(&evicted),
+	))
+
+	first := tbl.Get(testSession(1000), now)
+	first.cache.Merge([]byte("in-flight"))
+
+	tbl.Get(testSession(1001), now)
+
+	// touch first session, so the second one becomes least recently used
+	if f := tbl.Get(testSession(1000), now); f != first {
+		t.Fatal("existing session not reused")
+	}
+
+	tbl.Get(testSession(1002), now)
+
+	if tbl.Len() != 2 {
+		t.Fatalf("session table size exceeded: %d", tbl.Len())
+	}
+
+	if evicted.Load() != 1 {
+		t.Fatalf("evicted counter mismatch: %d", evicted.Load())
+	}
+
+	if _, exist := tbl.flows[testSession(1001).String()]; exist {
+		t.Fatal("least recently used session not evicted")
+	}
+
+	if f := tbl.Get(testSession(1000), now); string(f.cache.Bytes()) != "in-flight" {
+		t.Fatal("active session buffer corrupted")
+	}
+}
+
+func TestFlowTableSweep(t *testing.T) {
+	tbl := newFlowTable(newCaptureOptions(WithIdleTimeout(time.Minute)))
+
+	now := time.Now()
+
+	tbl.Get(testSession(1000), now.Add(-2*time.Minute))
+	tbl.Get(testSession(1002), now.Add(-90*time.Second))
+	tbl.Get(testSession(1001), now.Add(-30*time.Second))
+
+	tbl.Sweep(now)
+
+	if tbl.Len() != 1 {
+		t.Fatalf("idle sessions not swept: %d", tbl.Len())
+	}
+
+	if _, exist := tbl.flows[testSession(1001).String()]; !exist {
+		t.Fatal("active session swept")
+	}
+}
+
+func TestFlowTableMaxLifetime(t *testing.T) {
+	var closed []string
+
+	tbl := newFlowTable(newCaptureOptions(
+		WithIdleTimeout(0),
+		WithMaxFlowLifetime(time.Hour),
+		WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			closed = append(closed, fmt.Sprintf("%d %s", flow.Session.SrcPort, reason))
+		}),
+	))
+
+	if interval := tbl.SweepInterval(); interval != 30*time.Minute {
+		t.Fatalf("sweep interval mismatch: %v", interval)
+	}
+
+	now := time.Now()
+
+	tbl.Get(testSession(1000), now.Add(-2*time.Hour))
+	tbl.Get(testSession(1001), now.Add(-30*time.Minute))
+	// 持续活动的长连接同样在超出生存时间后删除
+	tbl.Get(testSession(1000), now)
+	tbl.Sweep(now)
+
+	if expect := []string{"1000 max_lifetime"}; !slices.Equal(closed, expect) {
+		t.Fatalf("session close mismatch: %v", closed)
+	}
+
+	if tbl.Len() != 1 {
+		t.Fatalf("young session swept: %d", tbl.Len())
+	}
+
+	if interval := newFlowTable(newCaptureOptions(WithIdleTimeout(0))).SweepInterval(); interval != 0 {
+		t.Fatalf("sweep enabled without timeout: %v", interval)
+	}
+}
+
+func TestFlowTableTeardown(t *testing.T) {
+	c := newCapturer(func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return 0, nil
+	}, newCaptureOptions())
+
+	for _, seg := range []testSegment{
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true},
+		{src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 500, syn: true, ack: true},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, payload: []byte("pending")},
+	} {
+		if err := c.handlePacket(buildSegment(t, seg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.flows.Len() != 2 {
+		t.Fatalf("handshake sessions not tracked: %d", c.flows.Len())
+	}
+
+	if err := c.handlePacket(buildSegment(t, testSegment{
+		src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1000, seq: 501, rst: true,
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.flows.Len() != 0 {
+		t.Fatalf("sessions not removed after rst: %d", c.flows.Len())
+	}
+
+	for _, seg := range []testSegment{
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1001, dport: 80, seq: 100, syn: true},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1001, dport: 80, seq: 101, payload: []byte("bye"), fin: true},
+	} {
+		if err := c.handlePacket(buildSegment(t, seg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.flows.Len() != 0 {
+		t.Fatalf("session not removed after fin: %d", c.flows.Len())
+	}
+}
+
+func TestFlowTableProtoIsolation(t *testing.T) {
+	now := time.Now()
+	tbl := newFlowTable(newCaptureOptions())
+
+	tcpSession := testSession(1000)
+	udpSession := testSession(1000)
+	udpSession.Proto = core.UDP
+
+	tcp := tbl.Get(tcpSession, now)
+	tcp.cache.Merge([]byte("tcp"))
+
+	udp := tbl.Get(udpSession, now)
+	if udp == tcp || udp.cache.Len() != 0 {
+		t.Fatal("udp flow shares cache with tcp flow of same address and port")
+	}
+
+	udp.cache.Merge([]byte("udp"))
+
+	// 同一地址端口的 TCP 会话拆除不影响 UDP 会话
+	tbl.Delete(tcpSession, CloseRST)
+
+	if f := tbl.Lookup(udpSession, now); f != udp || string(f.cache.Bytes()) != "udp" {
+		t.Fatal("udp flow affected by tcp teardown")
+	}
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	syn := buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 100, syn: true,
+	})
+
+	pkts := feedPackets(
+		syn,
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80, seq: 101, ack: true, fin: true, payload: []byte("bye"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1001, dport: 80, seq: 1, ack: true, payload: []byte("data"),
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.2", dst: "192.168.1.1", sport: 80, dport: 1001, seq: 1, rst: true,
+		}),
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1002, dport: 53, udp: true, payload: []byte("query"),
+		}),
+	)
+
+	var (
+		opened []uint64
+		closed []string
+	)
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, newCaptureOptions(
+		WithSessionOpen(func(flow FlowInfo) {
+			opened = append(opened, flow.Hash)
+		}),
+		WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			closed = append(closed, fmt.Sprintf("%d %s", flow.Session.SrcPort, reason))
+		}),
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(opened) != 3 || opened[0] != flowHash(syn) {
+		t.Fatalf("session open mismatch: %v", opened)
+	}
+
+	if expect := []string{"1000 fin", "1001 rst", "1002 capture_end"}; !slices.Equal(closed, expect) {
+		t.Fatalf("session close mismatch: %v", closed)
+	}
+
+	closed = closed[:0]
+
+	now := time.Now()
+	tbl := newFlowTable(newCaptureOptions(
+		WithMaxSessions(1),
+		WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			closed = append(closed, fmt.Sprintf("%d %s", flow.Session.SrcPort, reason))
+		}),
+	))
+
+	tbl.Get(testSession(1000), now.Add(-time.Hour))
+	tbl.Get(testSession(1001), now.Add(-time.Hour))
+	tbl.Sweep(now)
+
+	if expect := []string{"1000 eviction", "1001 timeout"}; !slices.Equal(closed, expect) {
+		t.Fatalf("session close mismatch: %v", closed)
+	}
+}
+
+func TestFlowCounters(t *testing.T) {
+	segment := func(seq uint32, syn, fin bool, payload string) gopacket.Packet {
+		return buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 80,
+			seq: seq, syn: syn, ack: !syn, fin: fin, payload: []byte(payload),
+		})
+	}
+
+	// 第二个数据段为重传, 不计入 PayloadBytes
+	pkts := []gopacket.Packet{
+		segment(100, true, false, ""),
+		segment(101, false, false, "hello"),
+		segment(101, false, false, "hello"),
+		segment(106, false, true, "world"),
+	}
+
+	var captured uint64
+	for _, pkg := range pkts {
+		captured += uint64(pkg.Metadata().CaptureLength)
+	}
+
+	var counters []FlowCounters
+
+	if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, newCaptureOptions(
+		WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			counters = append(counters, flow.Counters)
+		}),
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := FlowCounters{Packets: 4, CapturedBytes: captured, PayloadBytes: 10}
+
+	if len(counters) != 1 || counters[0] != expect {
+		t.Fatalf("flow counters mismatch: %+v, expect %+v", counters, expect)
+	}
+}
+
+// BenchmarkFlowTableChurn 模拟大量短连接, 会话结束时归还的缓冲区将被新会话复用
+func BenchmarkFlowTableChurn(b *testing.B) {
+	tbl := newFlowTable(newCaptureOptions())
+	now := time.Now()
+	payload := []byte("payload")
+
+	b.ReportAllocs()
+
+	for idx := 0; idx < b.N; idx++ {
+		f := tbl.Get(testSession(idx%60000), now)
+		f.cache.Merge(payload)
+		tbl.Remove(f, CloseFIN)
+	}
+}
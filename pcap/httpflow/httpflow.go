@@ -0,0 +1,305 @@
+// Package httpflow 基于 pcap 会话重组解析 HTTP/1.x 请求及响应, 请求与响应按到达顺序配对后交付
+package httpflow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/frozenpine/pkt4go/pcap"
+	"github.com/pkg/errors"
+)
+
+// Exchange 一次 HTTP 请求及其响应, 请求或响应的数据未抓取到时对应字段为 nil,
+// 请求及响应的 Body 已完整读入内存, 可重复读取
+type Exchange struct {
+	// Key 请求方向的会话五元组
+	Key      core.FlowKey
+	Request  *http.Request
+	Response *http.Response
+	// RequestTime 请求首个数据包的抓取时间
+	RequestTime time.Time
+	// ResponseTime 响应首个数据包的抓取时间
+	ResponseTime time.Time
+}
+
+// Latency 请求至响应首个数据包的间隔, 请求或响应缺失时返回 0
+func (ex *Exchange) Latency() time.Duration {
+	if ex.Request == nil || ex.Response == nil {
+		return 0
+	}
+
+	return ex.ResponseTime.Sub(ex.RequestTime)
+}
+
+// Handler HTTP 请求响应处理函数, 返回错误时按 pcap.WithErrorPolicy 处理
+type Handler func(ex *Exchange) error
+
+// Start 抓包并解析 HTTP/1.x 报文, 支持流水线请求及分块编码, 参数与 pcap.StartCapture 相同;
+// 以连接关闭界定长度的响应在服务端方向会话结束时交付, 会话结束时仍未收到响应的请求以 Response 为 nil 交付.
+//
+// opts 中的 pcap.WithSessionClose 回调在 httpflow 完成会话处理后调用;
+// 升级协议 (101 Switching Protocols) 或解析失败的会话不再解析后续数据
+func Start(ctx context.Context, handler pcap.PacketSource, filter string, fn Handler, opts ...pcap.CaptureOption) error {
+	p := parser{
+		fn:            fn,
+		conversations: make(map[core.FlowKey]*conversation),
+	}
+
+	err := pcap.StartPacketCapture(
+		ctx, handler, filter, p.handle,
+		append(opts, pcap.WithSessionCloseChain(p.close))...,
+	)
+
+	// 单向抓包时服务端会话可能从未建立, 抓包结束后交付剩余请求
+	p.flush()
+
+	if err != nil {
+		return err
+	}
+
+	if errors.Is(p.err, io.EOF) {
+		return nil
+	}
+
+	return p.err
+}
+
+// conversation 双向会话的解析状态
+type conversation struct {
+	client core.FlowKey
+	known  bool
+	// skip 升级协议或解析失败, 后续数据直接丢弃
+	skip bool
+	// since 各方向当前报文首个数据包的抓取时间
+	since   map[core.FlowKey]time.Time
+	pending []*Exchange
+	// tail 以连接关闭界定长度的响应, body 为已收到的响应体
+	tail *Exchange
+	body []byte
+}
+
+type parser struct {
+	fn            Handler
+	mu            sync.Mutex
+	conversations map[core.FlowKey]*conversation
+	// err 会话结束回调中处理函数返回的首个错误
+	err error
+}
+
+var responsePrefix = []byte("HTTP/")
+
+// incomplete 数据不足以解析完整报文
+func incomplete(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (p *parser) handle(pkt *core.Packet) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := pkt.Key.Canonical()
+
+	conv, exist := p.conversations[id]
+	if !exist {
+		conv = &conversation{since: make(map[core.FlowKey]time.Time, 2)}
+		p.conversations[id] = conv
+	}
+
+	if conv.skip {
+		return len(pkt.Data), nil
+	}
+
+	if conv.tail != nil && pkt.Key != conv.client {
+		conv.body = append(conv.body, pkt.Data...)
+		return len(pkt.Data), nil
+	}
+
+	if conv.since[pkt.Key].IsZero() {
+		conv.since[pkt.Key] = pkt.Timestamp
+	}
+
+	used := 0
+
+	for used < len(pkt.Data) && !conv.skip && conv.tail == nil {
+		n, err := p.parse(conv, pkt, pkt.Data[used:])
+		if err != nil {
+			return used, err
+		}
+
+		if n <= 0 {
+			break
+		}
+
+		used += n
+		// 后续报文起始于当前数据包
+		conv.since[pkt.Key] = pkt.Timestamp
+	}
+
+	if conv.skip || conv.tail != nil {
+		return len(pkt.Data), nil
+	}
+
+	if used >= len(pkt.Data) {
+		delete(conv.since, pkt.Key)
+	}
+
+	return used, nil
+}
+
+// parse 解析 data 起始的单个报文, 返回报文长度, 数据不完整时返回 0
+func (p *parser) parse(conv *conversation, pkt *core.Packet, data []byte) (int, error) {
+	reader := bytes.NewReader(data)
+	br := bufio.NewReader(reader)
+
+	consumed := func() int {
+		return len(data) - reader.Len() - br.Buffered()
+	}
+
+	if !bytes.HasPrefix(data, responsePrefix) {
+		req, err := http.ReadRequest(br)
+		if incomplete(err) {
+			return 0, nil
+		}
+		if err != nil {
+			conv.skip = true
+			return len(data), nil
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if incomplete(err) {
+			return 0, nil
+		}
+		if err != nil {
+			conv.skip = true
+			return len(data), nil
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.RemoteAddr = pkt.Src.String()
+
+		conv.client, conv.known = pkt.Key, true
+		conv.pending = append(conv.pending, &Exchange{
+			Key:         pkt.Key,
+			Request:     req,
+			RequestTime: conv.since[pkt.Key],
+		})
+
+		return consumed(), nil
+	}
+
+	if !conv.known {
+		conv.client, conv.known = pkt.Key.Reverse(), true
+	}
+
+	var ex *Exchange
+	if len(conv.pending) > 0 {
+		ex = conv.pending[0]
+	} else {
+		ex = &Exchange{Key: conv.client}
+	}
+
+	resp, err := http.ReadResponse(br, ex.Request)
+	if incomplete(err) {
+		return 0, nil
+	}
+	if err != nil {
+		conv.skip = true
+		return len(data), nil
+	}
+
+	// 1xx 临时响应不结束请求
+	if resp.StatusCode/100 == 1 && resp.StatusCode != http.StatusSwitchingProtocols {
+		return consumed(), nil
+	}
+
+	closeDelimited := resp.Body != http.NoBody && resp.ContentLength < 0 && len(resp.TransferEncoding) <= 0
+
+	var body []byte
+	if !closeDelimited {
+		if body, err = io.ReadAll(resp.Body); incomplete(err) {
+			return 0, nil
+		}
+		if err != nil {
+			conv.skip = true
+			return len(data), nil
+		}
+	}
+
+	if len(conv.pending) > 0 {
+		conv.pending = conv.pending[1:]
+	}
+
+	ex.Response = resp
+	ex.ResponseTime = conv.since[pkt.Key]
+
+	if closeDelimited {
+		conv.tail = ex
+		conv.body = append([]byte(nil), data[consumed():]...)
+		return len(data), nil
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		conv.skip = true
+	}
+
+	if err := p.fn(ex); err != nil {
+		return 0, err
+	}
+
+	return consumed(), nil
+}
+
+// close 会话任一方向结束时交付剩余数据, 客户端方向先结束且仍有待响应请求时等待服务端方向结束
+func (p *parser) close(flow pcap.FlowInfo, _ pcap.CloseReason) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := flow.Session.Key()
+	id := key.Canonical()
+
+	conv, exist := p.conversations[id]
+	if !exist {
+		return
+	}
+
+	if conv.known && key == conv.client && (len(conv.pending) > 0 || conv.tail != nil) {
+		return
+	}
+
+	delete(p.conversations, id)
+	p.finish(conv)
+}
+
+// flush 交付全部会话的剩余数据
+func (p *parser) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, conv := range p.conversations {
+		delete(p.conversations, id)
+		p.finish(conv)
+	}
+}
+
+func (p *parser) finish(conv *conversation) {
+	exchanges := conv.pending
+
+	if conv.tail != nil {
+		conv.tail.Response.Body = io.NopCloser(bytes.NewReader(conv.body))
+		exchanges = append([]*Exchange{conv.tail}, exchanges...)
+	}
+
+	for _, ex := range exchanges {
+		if err := p.fn(ex); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package httpflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/frozenpine/pkt4go/pcap"
+	"github.com/frozenpine/pkt4go/pcap/internal/pcaptest"
+	"github.com/google/gopacket/layers"
+)
+
+func TestStart(t *testing.T) {
+	client := &pcaptest.TCPStream{
+		Src: net.IPv4(192, 168, 1, 1).To4(), Dst: net.IPv4(192, 168, 1, 2).To4(),
+		SrcPort: 1000, DstPort: 80, Seq: 100,
+	}
+	server := &pcaptest.TCPStream{
+		Src: client.Dst, Dst: client.Src,
+		SrcPort: client.DstPort, DstPort: client.SrcPort, Seq: 500,
+	}
+
+	src := pcap.CreateHandlerFromData(layers.LinkTypeEthernet,
+		client.Segment(t, true, false, ""),
+		server.Segment(t, true, false, ""),
+		// 流水线请求, 第二个请求体跨数据段
+		client.Segment(t, false, false,
+			"GET /first HTTP/1.1\r\nHost: example.com\r\n\r\n"+
+				"POST /second HTTP/1.1\r\nHost: example.com\r\nContent-Length: 8\r\n\r\nbo",
+		),
+		client.Segment(t, false, false, "dy-dat"),
+		client.Segment(t, false, false, "GET /third HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+		server.Segment(t, false, false,
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst"+
+				"HTTP/1.1 100 Continue\r\n\r\n"+
+				"HTTP/1.1 201 Created\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nsec\r\n",
+		),
+		server.Segment(t, false, false, "3\r\nond\r\n0\r\n\r\n"),
+		// 以连接关闭界定长度的响应
+		server.Segment(t, false, false, "HTTP/1.0 200 OK\r\n\r\nthi"),
+		server.Segment(t, false, true, "rd"),
+	)
+
+	key := (&core.Session{
+		Proto: core.TCP, SrcIP: client.Src, SrcPort: 1000, DstIP: client.Dst, DstPort: 80,
+	}).Key()
+
+	var result []string
+
+	if err := Start(context.TODO(), src, "", func(ex *Exchange) error {
+		reqBody, _ := io.ReadAll(ex.Request.Body)
+		respBody, _ := io.ReadAll(ex.Response.Body)
+
+		if ex.Key != key {
+			t.Errorf("exchange key mismatch: %s", ex.Key)
+		}
+
+		if ex.Latency() <= 0 {
+			t.Errorf("latency should be positive: %s", ex.Latency())
+		}
+
+		result = append(result, fmt.Sprintf(
+			"%s %s %s -> %d %s", ex.Request.Method, ex.Request.URL.Path, reqBody,
+			ex.Response.StatusCode, respBody,
+		))
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"GET /first  -> 200 first",
+		"POST /second body-dat -> 201 second",
+		"GET /third  -> 200 third",
+	}
+
+	if !slices.Equal(result, expect) {
+		t.Fatalf("exchange mismatch: %q", result)
+	}
+}
+
+func TestStartUnanswered(t *testing.T) {
+	client := &pcaptest.TCPStream{
+		Src: net.IPv4(192, 168, 1, 1).To4(), Dst: net.IPv4(192, 168, 1, 2).To4(),
+		SrcPort: 1000, DstPort: 80, Seq: 100,
+	}
+
+	src := pcap.CreateHandlerFromData(layers.LinkTypeEthernet,
+		client.Segment(t, true, false, ""),
+		client.Segment(t, false, true, "GET /lost HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+	)
+
+	var (
+		result []*Exchange
+		closed int
+	)
+
+	// 调用方的会话结束回调与 httpflow 的回调同时生效
+	if err := Start(context.TODO(), src, "", func(ex *Exchange) error {
+		result = append(result, ex)
+		return nil
+	}, pcap.WithSessionClose(func(pcap.FlowInfo, pcap.CloseReason) {
+		closed++
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	if closed == 0 {
+		t.Error("session close handler not called")
+	}
+
+	if len(result) != 1 || result[0].Request.URL.Path != "/lost" || result[0].Response != nil {
+		t.Fatalf("unanswered request mismatch: %+v", result)
+	}
+
+	if result[0].Latency() != 0 {
+		t.Errorf("latency without response: %s", result[0].Latency())
+	}
+}
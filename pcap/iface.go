@@ -0,0 +1,124 @@
+package pcap
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+)
+
+// lookupInterface 查找携带 ip 的接口, afpacket:// 及 afxdp:// 以系统接口列表查找, 无需 libpcap 枚举设备,
+// 可用于限制设备枚举的容器环境
+func lookupInterface(proto string, ip net.IP) (string, error) {
+	ifaceList, err := interfaceList(proto)
+	if err != nil {
+		return "", err
+	}
+
+	return matchInterface(ip, ifaceList)
+}
+
+// interfaceList 返回数据源协议对应的接口列表
+func interfaceList(proto string) (ifaceList []libpcap.Interface, err error) {
+	if proto == "afpacket" || proto == "afxdp" {
+		ifaceList, err = systemInterfaces()
+	} else {
+		ifaceList, err = libpcap.FindAllDevs()
+	}
+
+	return ifaceList, errors.WithStack(err)
+}
+
+// interfaceAddresses 返回接口 name 的地址, 未找到接口时返回 nil
+func interfaceAddresses(name string, ifaceList []libpcap.Interface) []net.IPNet {
+	for _, iface := range ifaceList {
+		if iface.Name != name {
+			continue
+		}
+
+		addrs := make([]net.IPNet, 0, len(iface.Addresses))
+		for _, addr := range iface.Addresses {
+			addrs = append(addrs, net.IPNet{IP: addr.IP, Mask: addr.Netmask})
+		}
+
+		return addrs
+	}
+
+	return nil
+}
+
+// systemInterfaces 以标准库获取系统接口及其地址, 转换为 libpcap 接口描述
+func systemInterfaces() ([]libpcap.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaceList := make([]libpcap.Interface, 0, len(ifaces))
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		dev := libpcap.Interface{Name: iface.Name}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				dev.Addresses = append(dev.Addresses, libpcap.InterfaceAddress{
+					IP: ipNet.IP, Netmask: ipNet.Mask,
+				})
+			}
+		}
+
+		ifaceList = append(ifaceList, dev)
+	}
+
+	return ifaceList, nil
+}
+
+// matchInterface 查找携带 ip 的接口, 优先匹配接口地址, 其次匹配接口所在网段;
+// 多个接口同时匹配时按接口名排序选取首个, 以保证结果确定
+func matchInterface(ip net.IP, ifaceList []libpcap.Interface) (string, error) {
+	var exact, network []string
+
+	for _, iface := range ifaceList {
+		for _, addr := range iface.Addresses {
+			if addr.IP.Equal(ip) {
+				exact = append(exact, iface.Name)
+				break
+			}
+
+			if addr.Netmask != nil && addr.IP.Mask(addr.Netmask).Equal(ip.Mask(addr.Netmask)) {
+				network = append(network, iface.Name)
+			}
+		}
+	}
+
+	candidates := exact
+	if len(candidates) <= 0 {
+		candidates = network
+	}
+
+	if len(candidates) <= 0 {
+		return "", errors.WithStack(fmt.Errorf("%w for address: %s", ErrInterfaceNotFound, ip))
+	}
+
+	slices.Sort(candidates)
+	candidates = slices.Compact(candidates)
+
+	if len(candidates) > 1 {
+		slog.Warn(
+			"multiple interfaces matched address:",
+			slog.String("address", ip.String()),
+			slog.Any("interfaces", candidates),
+			slog.String("selected", candidates[0]),
+		)
+	}
+
+	return candidates[0], nil
+}
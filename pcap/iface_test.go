@@ -0,0 +1,81 @@
+package pcap
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	libpcap "github.com/google/gopacket/pcap"
+)
+
+func TestMatchInterface(t *testing.T) {
+	addr := func(ip string, bits int) libpcap.InterfaceAddress {
+		return libpcap.InterfaceAddress{IP: net.ParseIP(ip).To4(), Netmask: net.CIDRMask(bits, 32)}
+	}
+
+	ifaceList := []libpcap.Interface{
+		{Name: "eth1", Addresses: []libpcap.InterfaceAddress{addr("192.168.1.10", 24)}},
+		{Name: "eth0", Addresses: []libpcap.InterfaceAddress{addr("192.168.1.10", 24)}},
+		{Name: "eth0:1", Addresses: []libpcap.InterfaceAddress{addr("10.0.0.1", 8), addr("10.1.0.1", 16)}},
+	}
+
+	for _, c := range []struct {
+		ip, iface string
+	}{
+		{"192.168.1.10", "eth0"},
+		{"192.168.1.20", "eth0"},
+		{"10.1.0.1", "eth0:1"},
+		{"10.2.0.1", "eth0:1"},
+	} {
+		iface, err := matchInterface(net.ParseIP(c.ip), ifaceList)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if iface != c.iface {
+			t.Fatalf("interface mismatch for %s: %s", c.ip, iface)
+		}
+	}
+
+	// Npcap 设备名, 同一接口同时携带 IPv6 及 IPv4 地址
+	npf := []libpcap.Interface{
+		{Name: `\Device\NPF_Loopback`, Addresses: []libpcap.InterfaceAddress{addr("127.0.0.1", 8)}},
+		{Name: `\Device\NPF_{6F237A3B-8E56-4E0C-9C6A-B1C2D3E4F506}`, Addresses: []libpcap.InterfaceAddress{
+			{IP: net.ParseIP("fe80::1"), Netmask: net.CIDRMask(64, 128)},
+			addr("172.16.0.10", 16),
+		}},
+	}
+
+	if iface, err := matchInterface(net.ParseIP("172.16.0.10"), npf); err != nil || iface != npf[1].Name {
+		t.Fatalf("npf interface mismatch: %s, %v", iface, err)
+	}
+
+	if _, err := matchInterface(net.ParseIP("172.16.0.1"), ifaceList); !errors.Is(err, ErrInterfaceNotFound) {
+		t.Fatalf("unmatched address should fail: %v", err)
+	}
+}
+
+func TestSystemInterfaces(t *testing.T) {
+	ifaceList, err := systemInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loopback := net.ParseIP("127.0.0.1")
+
+	for _, iface := range ifaceList {
+		for _, addr := range iface.Addresses {
+			if !addr.IP.Equal(loopback) {
+				continue
+			}
+
+			if name, err := matchInterface(loopback, ifaceList); err != nil || name != iface.Name {
+				t.Fatalf("loopback interface mismatch: %s, %v", name, err)
+			}
+
+			return
+		}
+	}
+
+	t.Skip("no loopback interface with 127.0.0.1")
+}
@@ -0,0 +1,47 @@
+package pcap
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/google/gopacket/layers"
+)
+
+// SourceInfo CreateHandler 解析后的数据源描述, 用于记录实际抓包的接口
+type SourceInfo struct {
+	// Device 实际打开的接口名, IP 地址形式的数据源为解析后的接口; tcppcap:// 为远端接口名, file:// 为空
+	Device   string
+	LinkType layers.LinkType
+	// SnapLen 单个数据包最大抓取长度, 离线文件为文件头中的长度
+	SnapLen int
+	// Addresses 接口地址, 仅 pcap://, afpacket:// 及 afxdp:// 填充
+	Addresses []net.IPNet
+}
+
+// describeSource 以打开的数据源及解析结果填充 SourceInfo
+func describeSource(proto, device string, handle PacketSource, options *handlerOptions) *SourceInfo {
+	info := SourceInfo{LinkType: handle.LinkType()}
+
+	if proto != "file" {
+		info.Device, info.SnapLen = device, options.snapLen
+	}
+
+	if s, ok := handle.(snapLener); ok && s.SnapLen() > 0 {
+		info.SnapLen = s.SnapLen()
+	}
+
+	if proto == "pcap" || proto == "afpacket" || proto == "afxdp" {
+		// 地址仅用于描述, 获取失败不影响抓包
+		if ifaceList, err := interfaceList(proto); err != nil {
+			slog.Warn(
+				"list interface addresses failed:",
+				slog.String("device", device),
+				slog.Any("error", err),
+			)
+		} else {
+			info.Addresses = interfaceAddresses(device, ifaceList)
+		}
+	}
+
+	return &info
+}
@@ -0,0 +1,52 @@
+// Package pcaptest 构造测试用数据包, 仅供 pcap 及其子包的测试使用
+package pcaptest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// TCPStream 按顺序生成单向 TCP 数据段的以太网帧, 序列号随负载及 SYN, FIN 递增
+type TCPStream struct {
+	Src, Dst         net.IP
+	SrcPort, DstPort layers.TCPPort
+	Seq              uint32
+}
+
+// Segment 生成下一个数据段, 端口较小的一侧视为服务端, 其 SYN 携带 ACK
+func (s *TCPStream) Segment(t testing.TB, syn, fin bool, payload string) []byte {
+	t.Helper()
+
+	ip := &layers.IPv4{
+		Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: s.Src, DstIP: s.Dst,
+	}
+	tcp := &layers.TCP{
+		SrcPort: s.SrcPort, DstPort: s.DstPort,
+		Seq: s.Seq, SYN: syn, FIN: fin, ACK: !syn || s.SrcPort < s.DstPort, Window: 65535,
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(
+		buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ip, tcp, gopacket.Payload(payload),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Seq += uint32(len(payload))
+	if syn || fin {
+		s.Seq++
+	}
+
+	return buffer.Bytes()
+}
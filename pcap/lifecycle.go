@@ -0,0 +1,51 @@
+package pcap
+
+import "github.com/frozenpine/pkt4go/core"
+
+// CloseReason 会话结束原因
+type CloseReason int
+
+//go:generate stringer -type CloseReason -linecomment
+const (
+	CloseFIN          CloseReason = iota // fin
+	CloseRST                             // rst
+	CloseTimeout                         // timeout
+	CloseEviction                        // eviction
+	CloseCaptureEnd                      // capture_end
+	CloseHandlerError                    // handler_error
+	CloseOverflow                        // overflow
+	CloseMaxLifetime                     // max_lifetime
+)
+
+// FlowInfo 会话生命周期回调中的会话标识
+type FlowInfo struct {
+	Session *core.Session
+	// Hash 会话哈希, 双向会话哈希值相同, 默认为 FlowKey.Hash, 开启 WithStableFlowHash 时为 FlowKey.StableHash
+	Hash uint64
+	// Interface 会话首个数据包所在接口序号, 仅对 CreateMultiHandler 创建的数据源有意义
+	Interface int
+	// FromHandshake TCP 会话是否自 SYN 开始重组, 为 false 时会话中途开始抓取 (见 WithMidStream), 可能缺失起始数据;
+	// 其他协议均为 false
+	FromHandshake bool
+	// Counters 会话累计计数, 会话建立回调中仅包含首个数据包
+	Counters FlowCounters
+}
+
+// FlowCounters 会话累计计数, PayloadBytes 与 CapturedBytes 之比可反映重组效率
+type FlowCounters struct {
+	// Packets 归属该会话的数据包数量, 不含无载荷的纯 ACK 及 FIN 数据段
+	Packets uint64
+	// CapturedBytes 上述数据包的抓取长度之和, 包含各层协议头
+	CapturedBytes uint64
+	// PayloadBytes 合并入会话缓存的传输层数据长度, 不含重传及无法重组的数据
+	PayloadBytes uint64
+	// LostBytes 未能交付的 TCP 及 SCTP 数据长度, 包含因 WithReassemblyLimit 跳过的 TCP 序列号空洞及丢弃的 SCTP 分片,
+	// 及会话因淘汰, 超时等原因结束时仍在重组缓存中的数据
+	LostBytes uint64
+}
+
+// SessionOpenHandler 会话建立回调, TCP 会话在观察到 SYN 或首个数据段时建立, UDP 会话在首个数据报时建立
+type SessionOpenHandler func(flow FlowInfo)
+
+// SessionCloseHandler 会话结束回调
+type SessionCloseHandler func(flow FlowInfo, reason CloseReason)
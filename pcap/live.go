@@ -0,0 +1,63 @@
+package pcap
+
+import (
+	"fmt"
+	"strings"
+
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+)
+
+// openLive 打开 libpcap 实时抓包句柄, 开启 WithImmediateMode 时以未激活句柄设置参数后激活
+func openLive(iface string, options *handlerOptions) (PacketSource, error) {
+	if !options.immediate {
+		handle, err := libpcap.OpenLive(
+			iface, int32(options.snapLen), options.promisc, options.timeout,
+		)
+		if err != nil {
+			return nil, liveError(iface, err)
+		}
+
+		return handle, nil
+	}
+
+	inactive, err := libpcap.NewInactiveHandle(iface)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer inactive.CleanUp()
+
+	for _, set := range []func() error{
+		func() error { return inactive.SetSnapLen(options.snapLen) },
+		func() error { return inactive.SetPromisc(options.promisc) },
+		func() error { return inactive.SetTimeout(options.timeout) },
+		func() error { return inactive.SetImmediateMode(true) },
+	} {
+		if err := set(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, liveError(iface, err)
+	}
+
+	return handle, nil
+}
+
+// liveError 将权限不足导致的打开失败归类为 ErrPromiscDenied 或 ErrPermissionDenied,
+// 兼容 pcap_open_live 的错误信息及 pcap_activate 的状态码
+func liveError(iface string, err error) error {
+	msg := strings.ToLower(err.Error())
+
+	switch {
+	case strings.Contains(msg, "permission to capture in promiscuous mode"),
+		strings.Contains(msg, "activated error: -11"): // PCAP_ERROR_PROMISC_PERM_DENIED
+		return errors.WithStack(fmt.Errorf("%w on %s: %v", ErrPromiscDenied, iface, err))
+	case strings.Contains(msg, "permission"), strings.Contains(msg, "not permitted"):
+		return errors.WithStack(fmt.Errorf("%w on %s: %v", ErrPermissionDenied, iface, err))
+	default:
+		return errors.WithStack(err)
+	}
+}
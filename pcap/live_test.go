@@ -0,0 +1,24 @@
+package pcap
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLiveError(t *testing.T) {
+	for msg, expect := range map[string]error{
+		"eth0: You don't have permission to capture in promiscuous mode (Operation not permitted)": ErrPromiscDenied,
+		"unknown activated error: -11": ErrPromiscDenied,
+		"eth0: You don't have permission to perform this capture on that device (socket: Operation not permitted)": ErrPermissionDenied,
+		"Permission Denied": ErrPermissionDenied,
+		"No Such Device":    nil,
+	} {
+		err := liveError("eth0", errors.New(msg))
+
+		for _, target := range []error{ErrPromiscDenied, ErrPermissionDenied} {
+			if errors.Is(err, target) != (target == expect) {
+				t.Errorf("%q classified as: %v", msg, err)
+			}
+		}
+	}
+}
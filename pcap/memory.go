@@ -0,0 +1,85 @@
+package pcap
+
+import (
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// memorySource 内存数据源, 按顺序读取预先构造的数据包, 读取完毕后返回 io.EOF
+type memorySource struct {
+	linkType layers.LinkType
+	data     [][]byte
+	infos    []gopacket.CaptureInfo
+	idx      int
+}
+
+// CreateHandlerFromPackets 以内存中的数据包创建数据源, 按顺序经过与实时抓包相同的解码及重组流程,
+// 不依赖网卡或抓包文件, 用于测试; 数据包的抓取长度未设置时以数据长度填充
+func CreateHandlerFromPackets(linkType layers.LinkType, packets ...gopacket.Packet) PacketSource {
+	src := memorySource{
+		linkType: linkType,
+		data:     make([][]byte, len(packets)),
+		infos:    make([]gopacket.CaptureInfo, len(packets)),
+	}
+
+	for idx, pkg := range packets {
+		src.data[idx] = pkg.Data()
+
+		if md := pkg.Metadata(); md != nil {
+			src.infos[idx] = md.CaptureInfo
+		}
+	}
+
+	return src.fill(time.Time{})
+}
+
+// CreateHandlerFromData 以内存中的原始数据包创建数据源,
+// 抓取时间从创建时刻起每包递增 1ms
+func CreateHandlerFromData(linkType layers.LinkType, data ...[]byte) PacketSource {
+	src := memorySource{
+		linkType: linkType,
+		data:     data,
+		infos:    make([]gopacket.CaptureInfo, len(data)),
+	}
+
+	return src.fill(time.Now())
+}
+
+// fill 补全抓取信息, base 非零时以其为起点生成抓取时间
+func (src memorySource) fill(base time.Time) *memorySource {
+	for idx, data := range src.data {
+		ci := &src.infos[idx]
+
+		if !base.IsZero() {
+			ci.Timestamp = base.Add(time.Duration(idx) * time.Millisecond)
+		}
+
+		if ci.CaptureLength <= 0 {
+			ci.CaptureLength = len(data)
+		}
+
+		if ci.Length < ci.CaptureLength {
+			ci.Length = ci.CaptureLength
+		}
+	}
+
+	return &src
+}
+
+func (src *memorySource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if src.idx >= len(src.data) {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	data, ci := src.data[src.idx], src.infos[src.idx]
+	src.idx++
+
+	return data, ci, nil
+}
+
+func (src *memorySource) LinkType() layers.LinkType {
+	return src.linkType
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/frozenpine/pkt4go/pcap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	c, err := NewCollector(reg, "pkt4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.PacketProcessed(60)
+	c.PacketProcessed(60)
+	c.DataReassembled(128)
+	c.SessionsTracked(3)
+	c.SessionEvicted()
+	c.HandlerFailed(errors.New("failed"))
+	c.QueueDepth(12, 1000)
+	c.BufferOverflow()
+	c.ObserveStats(&pcap.Stats{PacketsReceived: 10, PacketsDropped: 2, PacketsIfDropped: 1})
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64, len(families))
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+
+		if counter := metric.GetCounter(); counter != nil {
+			values[family.GetName()] = counter.GetValue()
+		} else {
+			values[family.GetName()] = metric.GetGauge().GetValue()
+		}
+	}
+
+	for name, expect := range map[string]float64{
+		"pkt4go_capture_packets_total":            2,
+		"pkt4go_capture_reassembled_bytes_total":  128,
+		"pkt4go_capture_sessions":                 3,
+		"pkt4go_capture_sessions_evicted_total":   1,
+		"pkt4go_capture_handler_errors_total":     1,
+		"pkt4go_capture_queue_depth":              12,
+		"pkt4go_capture_sessions_overflow_total":  1,
+		"pkt4go_capture_stats_packets_dropped":    2,
+		"pkt4go_capture_stats_packets_if_dropped": 1,
+	} {
+		if values[name] != expect {
+			t.Errorf("metric %s mismatch: %v, expect %v", name, values[name], expect)
+		}
+	}
+
+	if _, err := NewCollector(reg, "pkt4go"); err == nil {
+		t.Fatal("duplicate register should fail")
+	}
+}
@@ -0,0 +1,57 @@
+package pcap
+
+import (
+	"encoding/binary"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+)
+
+// mplsStack 以单个协议层解码完整的 MPLS 标签栈, 供零拷贝快速解码使用,
+// 栈底之后按 IP 版本号继续解码 IPv4/IPv6, 其他负载 (如伪线控制字) 由完整解码处理
+type mplsStack struct {
+	layers.BaseLayer
+	// labels 外层标签在前, 下次解码时复用
+	labels []uint32
+	next   gopacket.LayerType
+}
+
+func (m *mplsStack) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	m.labels = m.labels[:0]
+
+	for offset := 0; offset+4 <= len(data); offset += 4 {
+		entry := binary.BigEndian.Uint32(data[offset:])
+		m.labels = append(m.labels, entry>>12)
+
+		if entry&0x100 == 0 {
+			continue
+		}
+
+		m.Contents, m.Payload = data[:offset+4], data[offset+4:]
+		m.next = gopacket.LayerTypePayload
+
+		if len(m.Payload) > 0 {
+			switch m.Payload[0] >> 4 {
+			case 4:
+				m.next = layers.LayerTypeIPv4
+			case 6:
+				m.next = layers.LayerTypeIPv6
+			}
+		}
+
+		return nil
+	}
+
+	df.SetTruncated()
+
+	return errors.New("mpls label stack truncated")
+}
+
+func (m *mplsStack) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeMPLS
+}
+
+func (m *mplsStack) NextLayerType() gopacket.LayerType {
+	return m.next
+}
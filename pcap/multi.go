@@ -0,0 +1,221 @@
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+
+	origin_errors "errors"
+)
+
+// multiQueueSize 多数据源合并队列长度
+const multiQueueSize = 1024
+
+// CreateMultiHandler 同时从多个数据源抓包, 各数据源格式与 CreateHandler 相同,
+// 数据包按到达顺序合并为单个数据源, 同一会话的数据包无论来自哪个接口均在同一会话缓存中重组;
+// 数据包的 CaptureInfo.InterfaceIndex 为其数据源在 sources 中的序号, 会话建立时的序号记录在 FlowInfo.Interface 中.
+//
+// 非对称路由下会话的双向数据包可能由不同接口抓取, 此时 FlowInfo.Interface 仅为首个数据包所在接口,
+// 且不同接口间的数据包不保证严格按抓取时间排序
+func CreateMultiHandler(sources []string, opts ...HandlerOption) (PacketSource, error) {
+	if len(sources) <= 0 {
+		return nil, errors.WithStack(fmt.Errorf("%w: no data source specified", ErrInvalidDataSource))
+	}
+
+	handles := make([]PacketSource, 0, len(sources))
+
+	for _, source := range sources {
+		handle, err := CreateHandler(source, opts...)
+		if err != nil {
+			for _, h := range handles {
+				closeSource(h)
+			}
+
+			return nil, err
+		}
+
+		handles = append(handles, handle)
+	}
+
+	return newMultiSource(handles...), nil
+}
+
+type multiPacket struct {
+	data     []byte
+	ci       gopacket.CaptureInfo
+	linkType layers.LinkType
+}
+
+// multiSource 多数据源合并, 各数据源在独立协程中读取
+type multiSource struct {
+	handles []PacketSource
+	sources []PacketSource
+	packets chan multiPacket
+	done    chan struct{}
+	start   sync.Once
+	stop    sync.Once
+	current layers.LinkType
+}
+
+func newMultiSource(sources ...PacketSource) *multiSource {
+	src := multiSource{
+		handles: sources,
+		sources: make([]PacketSource, len(sources)),
+		packets: make(chan multiPacket, multiQueueSize),
+		done:    make(chan struct{}),
+	}
+
+	// 不支持内核过滤的数据源在进程内过滤
+	for idx, source := range sources {
+		if _, ok := source.(bpfSetter); !ok {
+			source = newFilterSource(source)
+		}
+
+		src.sources[idx] = source
+	}
+
+	return &src
+}
+
+// LinkType 返回首个数据源的链路类型
+func (src *multiSource) LinkType() layers.LinkType {
+	return src.sources[0].LinkType()
+}
+
+// SetBPFFilter 对全部数据源设置过滤表达式
+func (src *multiSource) SetBPFFilter(expr string) error {
+	for _, source := range src.sources {
+		if err := source.(bpfSetter).SetBPFFilter(expr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetBPFInstructionFilter 对全部数据源加载预编译的过滤程序, 各数据源需具有相同的链路类型
+func (src *multiSource) SetBPFInstructionFilter(insts []libpcap.BPFInstruction) error {
+	for _, source := range src.sources {
+		setter, ok := source.(bpfProgramSetter)
+		if !ok {
+			return errors.New("packet source does not support bpf program")
+		}
+
+		if err := setter.SetBPFInstructionFilter(insts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FilterStats 汇总全部数据源的过滤统计, 任一数据源在内核中过滤时 Kernel 为 true
+func (src *multiSource) FilterStats() (*FilterStats, error) {
+	var total FilterStats
+
+	for _, source := range src.sources {
+		var (
+			stats *FilterStats
+			err   error
+		)
+
+		switch v := source.(type) {
+		case filterStatsReader:
+			stats, err = v.FilterStats()
+		case statsReader:
+			var kernel *Stats
+			if kernel, err = v.Stats(); err == nil {
+				stats = &FilterStats{Accepted: uint64(kernel.PacketsReceived), Kernel: true}
+			}
+		default:
+			return nil, errors.New("packet source does not support filter stats")
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		total.Accepted += stats.Accepted
+		total.Filtered += stats.Filtered
+		total.Kernel = total.Kernel || stats.Kernel
+	}
+
+	return &total, nil
+}
+
+func (src *multiSource) run() {
+	var wg sync.WaitGroup
+
+	for idx, source := range src.sources {
+		wg.Add(1)
+
+		go func(idx int, source PacketSource) {
+			defer wg.Done()
+
+			for {
+				data, ci, err := source.ReadPacketData()
+				if err != nil {
+					if readFatal(err) {
+						return
+					}
+
+					continue
+				}
+
+				linkType := captureLinkType(ci, source.LinkType())
+				ci.InterfaceIndex = idx
+
+				select {
+				case src.packets <- multiPacket{data: data, ci: ci, linkType: linkType}:
+				case <-src.done:
+					return
+				}
+			}
+		}(idx, source)
+	}
+
+	wg.Wait()
+	close(src.packets)
+}
+
+// ReadPacketData 读取任一数据源的数据包, 全部数据源读取完毕时返回 io.EOF
+func (src *multiSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	src.start.Do(func() { go src.run() })
+
+	pkt, ok := <-src.packets
+	if !ok {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	src.current = pkt.linkType
+	pkt.ci.AncillaryData = []interface{}{pkt.linkType}
+
+	return pkt.data, pkt.ci, nil
+}
+
+// Decode 按最近读取数据包所属数据源的链路类型解码,
+// gopacket.PacketSource 读取与解码顺序执行, 因此可安全使用
+func (src *multiSource) Decode(data []byte, p gopacket.PacketBuilder) error {
+	return linkDecoder(src.current).Decode(data, p)
+}
+
+// Close 停止读取并关闭全部数据源
+func (src *multiSource) Close() error {
+	var errs []error
+
+	src.stop.Do(func() {
+		close(src.done)
+
+		for _, source := range src.handles {
+			if err := closeSource(source); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+
+	return origin_errors.Join(errs...)
+}
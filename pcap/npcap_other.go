@@ -0,0 +1,7 @@
+//go:build !windows
+
+package pcap
+
+func checkNpcap() error {
+	return nil
+}
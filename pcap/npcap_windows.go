@@ -0,0 +1,17 @@
+//go:build windows
+
+package pcap
+
+import (
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+)
+
+// checkNpcap 确认 wpcap.dll 可用, 未安装 Npcap 时 gopacket 仅返回难以定位的 dll 加载错误
+func checkNpcap() error {
+	if err := libpcap.LoadWinPCAP(); err != nil {
+		return errors.Wrap(err, "npcap is not installed, live capture requires npcap (https://npcap.com)")
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package pcap
+
+// Observer 抓包过程观测接口, 各方法均在抓包协程中同步调用, 实现不应阻塞
+type Observer interface {
+	// PacketProcessed 每处理一个数据包调用一次, size 为抓取长度
+	PacketProcessed(size int)
+	// DataReassembled 传输层数据合并入会话缓存时调用, size 为本次合并的数据长度
+	DataReassembled(size int)
+	// SessionsTracked 每处理一个数据包后调用, count 为当前跟踪的会话数量
+	SessionsTracked(count int)
+	// SessionEvicted 会话因超出最大跟踪数量被淘汰时调用
+	SessionEvicted()
+	// HandlerFailed 数据处理函数返回错误时调用, 不包含 io.EOF
+	HandlerFailed(err error)
+}
+
+// QueueObserver 可选的队列观测接口, Observer 同时实现该接口时, 读取协程每写入一个数据包后
+// 以待处理数据包队列的当前长度及容量调用, 队列持续接近满载表示数据处理速度低于抓包速度;
+// 零拷贝读取不经过队列, 不会调用
+type QueueObserver interface {
+	QueueDepth(depth, capacity int)
+}
+
+// BufferObserver 可选的会话缓存观测接口, Observer 同时实现该接口时,
+// 会话缓存超出 WithMaxSessionBuffer 限制而被删除时调用
+type BufferObserver interface {
+	BufferOverflow()
+}
+
+type nopObserver struct{}
+
+func (nopObserver) PacketProcessed(int) {}
+func (nopObserver) DataReassembled(int) {}
+func (nopObserver) SessionsTracked(int) {}
+func (nopObserver) SessionEvicted()     {}
+func (nopObserver) HandlerFailed(error) {}
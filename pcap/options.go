@@ -0,0 +1,803 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	libpcap "github.com/google/gopacket/pcap"
+)
+
+const (
+	// DefaultSnapLen 默认抓包长度
+	DefaultSnapLen = 65535
+	// DefaultReadTimeout 默认实时抓包读超时时间
+	DefaultReadTimeout = time.Hour
+	// DefaultPromiscuous 默认开启混杂模式
+	DefaultPromiscuous = true
+
+	// DefaultMaxSessions 默认最大跟踪会话数量
+	DefaultMaxSessions = 10240
+	// DefaultIdleTimeout 默认会话空闲超时时间
+	DefaultIdleTimeout = 5 * time.Minute
+	// DefaultVXLANPort VXLAN 默认目的端口
+	DefaultVXLANPort = 4789
+	// DefaultStatsInterval 默认抓包统计上报周期
+	DefaultStatsInterval = 10 * time.Second
+	// DefaultDefragTimeout 默认 IPv4 分片重组超时时间
+	DefaultDefragTimeout = 30 * time.Second
+	// DefaultQueueSize 默认待处理数据包队列长度, 与 gopacket.PacketSource 一致
+	DefaultQueueSize = 1000
+	// DefaultMaxPending 默认单个 TCP 会话方向的乱序缓存数据上限
+	DefaultMaxPending = 4 << 20
+	// DefaultGapTimeout 默认 TCP 序列号空洞的最长等待时间
+	DefaultGapTimeout = 3 * time.Second
+
+	// tlsRecordHeader TLS 记录头长度: 类型, 版本及负载长度
+	tlsRecordHeader = 5
+)
+
+type handlerOptions struct {
+	snapLen int
+	promisc bool
+	timeout time.Duration
+
+	frameSize   int
+	blockSize   int
+	numBlocks   int
+	fanout      bool
+	fanoutGroup uint16
+	xdpQueue    int
+
+	dialer     Dialer
+	deviceName bool
+	immediate  bool
+	info       *SourceInfo
+}
+
+func newHandlerOptions(opts ...HandlerOption) *handlerOptions {
+	options := handlerOptions{
+		snapLen: DefaultSnapLen,
+		promisc: DefaultPromiscuous,
+		timeout: DefaultReadTimeout,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&options)
+		}
+	}
+
+	return &options
+}
+
+// HandlerOption CreateHandler 可选参数, 仅对实时抓包(pcap://, afpacket://, afxdp://, tcppcap://)生效,
+// 离线文件(file://)将忽略这些参数
+type HandlerOption func(*handlerOptions)
+
+// WithSnapLen 设置单个数据包最大抓取长度, 默认为 DefaultSnapLen
+func WithSnapLen(size int) HandlerOption {
+	return func(opts *handlerOptions) {
+		if size > 0 {
+			opts.snapLen = size
+		}
+	}
+}
+
+// WithPromiscuous 设置是否开启混杂模式, 默认为 DefaultPromiscuous;
+// 关闭时仍抓取本机收发及广播, 组播数据包, 适用于容器 sidecar 等禁止混杂模式的环境,
+// 无权开启混杂模式时 CreateHandler 返回 ErrPromiscDenied
+func WithPromiscuous(promisc bool) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.promisc = promisc
+	}
+}
+
+// WithTimeout 设置实时抓包读超时时间, 较短的超时可降低安静链路上的数据延迟,
+// 默认为 DefaultReadTimeout
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.timeout = timeout
+	}
+}
+
+// WithImmediateMode 开启 libpcap 即时模式, 数据包到达即交付, 不再等待内核缓冲区填满或读超时, 仅对 pcap:// 生效;
+// 即时模式下每个数据包均唤醒一次读取, 延迟最低但系统调用开销随包速增长, 高吞吐链路上宜保持关闭,
+// 以 WithTimeout 设置较短的读超时兼顾延迟及吞吐
+func WithImmediateMode() HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.immediate = true
+	}
+}
+
+// WithAFPacketRing 设置 AF_PACKET 环形缓冲区的帧大小, 块大小及块数量, 仅对 afpacket:// 生效,
+// 帧大小决定单个数据包最大抓取长度, 块大小需为帧大小及内存页大小的整数倍, 参数 <= 0 时使用 afpacket 默认值
+func WithAFPacketRing(frameSize, blockSize, numBlocks int) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.frameSize = frameSize
+		opts.blockSize = blockSize
+		opts.numBlocks = numBlocks
+	}
+}
+
+// WithFanout 将 AF_PACKET 套接字加入 fanout 组, 同组套接字按会话哈希分担同一接口的流量,
+// 可跨进程使用, 仅对 afpacket:// 生效
+func WithFanout(group uint16) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.fanout = true
+		opts.fanoutGroup = group
+	}
+}
+
+// WithXDPQueue 设置 afxdp:// 接收的网卡接收队列, 默认为 0, 仅对 afxdp:// 生效;
+// 单个数据源仅接收一个队列, 多队列网卡需以 ethtool 将目标流量导向该队列, 或为各队列分别创建数据源
+func WithXDPQueue(queue int) HandlerOption {
+	return func(opts *handlerOptions) {
+		if queue >= 0 {
+			opts.xdpQueue = queue
+		}
+	}
+}
+
+// WithDeviceName 将 pcap://, afpacket:// 及 afxdp:// 的数据源直接作为接口名, 不再将 IP 地址形式的数据源解析为所在接口,
+// 用于以地址命名的接口
+func WithDeviceName() HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.deviceName = true
+	}
+}
+
+// WithSourceInfo CreateHandler 成功时以解析后的接口名, 链路类型, 抓取长度及接口地址填充 info,
+// 对全部数据源生效
+func WithSourceInfo(info *SourceInfo) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.info = info
+	}
+}
+
+// WithDialer 设置 tcppcap:// 数据源的连接方式, 默认以 TCP 直连,
+// 可在返回连接前完成 TLS 握手或认证, 仅对 tcppcap:// 生效
+func WithDialer(dial Dialer) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.dialer = dial
+	}
+}
+
+type captureOptions struct {
+	maxSessions   int
+	evicted       *atomic.Uint64
+	idleTimeout   time.Duration
+	maxLifetime   time.Duration
+	vlans         map[uint16]struct{}
+	vlanKey       bool
+	vxlanPorts    map[int]struct{}
+	gre           bool
+	midStream     bool
+	closeOnExit   bool
+	logger        *slog.Logger
+	observer      Observer
+	pcapWriter    io.Writer
+	rotation      *pcapRotation
+	payload       *regexp.Regexp
+	workers       int
+	queueSize     int
+	zeroCopy      bool
+	decodeTargets *DecodeTargets
+	onOpen        SessionOpenHandler
+	onClose       SessionCloseHandler
+	defrag        time.Duration
+	onTruncated   TruncatedHandler
+	onDecodeError DecodeErrorHandler
+	rawHook       RawPacketHook
+	sampling      int
+	dedup         time.Duration
+	flowSampling  bool
+	decoder       gopacket.Decoder
+	replay        float64
+	rangeStart    time.Time
+	rangeEnd      time.Time
+	onFlush       core.DataHandler
+	onEOF         core.DataHandler
+	errorPolicy   ErrorPolicy
+	direction     DirectionFilter
+	dropUnknown   bool
+	icmp          bool
+	rawSegments   bool
+	bufferSize    int
+	maxBuffer     int
+	reassembly    reassemblyLimit
+	handlers      map[core.TransProto]core.DataHandler
+	routes        *filterRoutes
+	stableHash    bool
+	traffic       *TrafficCollector
+	ipOptions     *atomic.Uint64
+	dropIPOptions bool
+	result        *CaptureResult
+	bpfProgram    []libpcap.BPFInstruction
+	copyPayload   bool
+	framer        func(data []byte) int
+	// stopped 由 WithCaptureResult 设置, 记录数据处理函数是否要求结束
+	stopped *atomic.Bool
+	// packetFn 由 RunPacket 设置, 非空时替代 DataHandler
+	packetFn core.PacketHandler
+
+	statsInterval time.Duration
+	statsFn       StatsHandler
+}
+
+func newCaptureOptions(opts ...CaptureOption) *captureOptions {
+	options := captureOptions{
+		maxSessions: DefaultMaxSessions,
+		queueSize:   DefaultQueueSize,
+		idleTimeout: DefaultIdleTimeout,
+		reassembly:  reassemblyLimit{pending: DefaultMaxPending, timeout: DefaultGapTimeout},
+		midStream:   true,
+		logger:      slog.Default(),
+		observer:    nopObserver{},
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&options)
+		}
+	}
+
+	return &options
+}
+
+// CaptureOption StartCapture 可选参数
+type CaptureOption func(*captureOptions)
+
+// WithMaxSessions 设置最大跟踪会话数量, 超出时淘汰最久未活动的会话,
+// size <= 0 时不限制会话数量, 默认为 DefaultMaxSessions
+func WithMaxSessions(size int) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.maxSessions = size
+	}
+}
+
+// WithEvictedCounter 会话因容量限制被淘汰时累加计数器, 可用于观测淘汰速率
+func WithEvictedCounter(counter *atomic.Uint64) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.evicted = counter
+	}
+}
+
+// WithIPOptionsCounter 收到携带选项 (IHL > 5) 的 IPv4 报文时累加计数器, 此类报文较少见, 可能为探测或攻击流量
+func WithIPOptionsCounter(counter *atomic.Uint64) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.ipOptions = counter
+	}
+}
+
+// WithDropIPOptions 丢弃携带选项的 IPv4 报文, 默认按选项后的实际负载正常处理
+func WithDropIPOptions() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.dropIPOptions = true
+	}
+}
+
+// WithIdleTimeout 设置会话空闲超时时间, 超时未收到数据的会话将被定期清理,
+// timeout <= 0 时不清理空闲会话, 默认为 DefaultIdleTimeout
+func WithIdleTimeout(timeout time.Duration) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.idleTimeout = timeout
+	}
+}
+
+// WithMaxFlowLifetime 设置会话最长生存时间, 自创建起超出该时间的会话无论是否活动均在定期清理时删除,
+// 丢弃其缓存数据并以 CloseMaxLifetime 结束, 用于限制长连接占用的重组状态; lifetime <= 0 时不限制, 默认不限制
+func WithMaxFlowLifetime(lifetime time.Duration) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.maxLifetime = lifetime
+	}
+}
+
+// WithVLANFilter 仅处理携带指定 VLAN ID 的数据包, QinQ 数据包的任一层标签匹配即可,
+// 该过滤在进程内进行, 与 BPF 过滤相互独立
+func WithVLANFilter(ids ...uint16) CaptureOption {
+	return func(opts *captureOptions) {
+		if opts.vlans == nil {
+			opts.vlans = make(map[uint16]struct{}, len(ids))
+		}
+
+		for _, id := range ids {
+			opts.vlans[id] = struct{}{}
+		}
+	}
+}
+
+// WithVLANKey 以 VLAN ID 及 MPLS 标签栈区分会话, 不同 VLAN 上地址端口相同的会话分别重组,
+// 用于地址空间重叠的多 VLAN 抓包, 默认仅以地址端口区分会话;
+// MPLS 标签通常按方向分配, 双向标签不同时 RST 等关联反向会话的处理仅作用于当前方向
+func WithVLANKey() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.vlanKey = true
+	}
+}
+
+// WithVXLAN 开启 VXLAN 解封装, 目的端口匹配的 UDP 数据报将剥离外层报文头,
+// 以内层报文进行会话重组, 未指定端口时使用 DefaultVXLANPort
+func WithVXLAN(ports ...int) CaptureOption {
+	return func(opts *captureOptions) {
+		if len(ports) <= 0 {
+			ports = []int{DefaultVXLANPort}
+		}
+
+		if opts.vxlanPorts == nil {
+			opts.vxlanPorts = make(map[int]struct{}, len(ports))
+		}
+
+		for _, port := range ports {
+			opts.vxlanPorts[port] = struct{}{}
+		}
+	}
+}
+
+// WithGRE 开启 GRE 解封装, 以内层报文进行会话重组, 暂不支持嵌套的 GRE 隧道
+func WithGRE() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.gre = true
+	}
+}
+
+// WithSessionBufferSize 设置会话缓存的初始容量, 数据超出容量时自动扩容, 消费完毕后恢复初始容量;
+// 大量短连接小报文时可减小以节省内存, 大流量传输时可增大以减少扩容, size <= 0 时使用 core.DefaultStreamCacheSize
+func WithSessionBufferSize(size int) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.bufferSize = size
+	}
+}
+
+// WithMaxSessionBuffer 限制单个会话缓存中待处理数据的长度, 数据处理函数消费速度跟不上时缓存不再无限增长,
+// 长度包含 TCP 乱序缓存及 SCTP 未收齐消息中的数据;
+// 超出限制的会话将被删除并丢弃其缓存数据, 以 CloseOverflow 结束, 其后续数据作为新会话处理,
+// 同时输出告警日志并通知 BufferObserver; size <= 0 时不限制, 默认不限制
+func WithMaxSessionBuffer(size int) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.maxBuffer = size
+	}
+}
+
+// WithReassemblyLimit 设置 TCP 重组的乱序缓存限制, 缓存数据超出 maxPending 字节, 或序列号空洞自首个乱序报文起
+// 等待超出 gapTimeout 时跳过空洞继续交付, 时长以数据包时间戳计算; SCTP 未收齐消息的分片同样受 maxPending 限制,
+// 超出时丢弃 TSN 最小的分片; 跳过及丢弃的长度计入 FlowCounters.LostBytes 并输出调试日志,
+// 各参数 <= 0 时不限制, 默认为 DefaultMaxPending 及 DefaultGapTimeout.
+// 丢包, 中途开始重组时首个报文为旧的重传报文及按数据包抽样等情况均将产生无法填补的空洞
+func WithReassemblyLimit(maxPending int, gapTimeout time.Duration) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.reassembly = reassemblyLimit{pending: maxPending, timeout: gapTimeout}
+	}
+}
+
+// WithRawSegments 关闭 TCP 重组, 每个携带数据的 TCP 数据段直接交由数据处理函数, 不经会话缓存,
+// 返回的已处理长度将被忽略; 乱序, 重传及重复数据段均原样交付, 需由调用方自行处理;
+// 会话生命周期回调及方向识别不受影响, 与 WithZeroCopy 同时使用时数据仅在回调期间有效
+func WithRawSegments() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.rawSegments = true
+	}
+}
+
+// WithICMP 开启 ICMP 报文透传, ICMP 及 ICMPv6 报文将不经会话缓存直接交由数据处理函数,
+// 数据为完整的 ICMP 报文, 首字节为类型, 次字节为代码, 会话端口均为 0, 返回的已处理长度将被忽略; 默认关闭
+func WithICMP() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.icmp = true
+	}
+}
+
+// WithProtocolHandler 为指定传输层协议设置独立的数据处理函数, 优先于 StartCapture 及 StartPacketCapture 的处理函数,
+// 可多次调用分别设置各协议; 未设置处理函数的协议仍交由 StartCapture 的处理函数, 其为 nil 时数据直接丢弃.
+// ICMP 及 ICMPv6 需同时开启 WithICMP
+func WithProtocolHandler(proto core.TransProto, fn core.DataHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		if fn == nil {
+			return
+		}
+
+		if opts.handlers == nil {
+			opts.handlers = make(map[core.TransProto]core.DataHandler)
+		}
+
+		opts.handlers[proto] = fn
+	}
+}
+
+// WithFilterHandler 为匹配 BPF 过滤表达式 expr 的会话设置独立的数据处理函数, 可多次调用设置多组过滤,
+// 过滤在进程内按设置顺序匹配, 会话首个数据包匹配的首个过滤决定其处理函数, 优先于 WithProtocolHandler;
+// 均不匹配的会话仍交由 StartCapture 的处理函数. StartCapture 的 filter 参数为空时以各过滤表达式之并作为数据源的过滤
+func WithFilterHandler(expr string, fn core.DataHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		if expr == "" || fn == nil {
+			return
+		}
+
+		if opts.routes == nil {
+			opts.routes = &filterRoutes{}
+		}
+
+		opts.routes.routes = append(opts.routes.routes, newFilterRoute(expr, fn))
+	}
+}
+
+// WithStableFlowHash 以 FlowKey.StableHash 替代默认的 FastHash 作为会话哈希, 用于 FlowInfo.Hash, Packet.FlowHash
+// 及按会话抽样, 哈希值可持久化并跨运行及版本关联会话, 计算开销略高; 多协程分发仍以 FastHash 进行
+func WithStableFlowHash() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.stableHash = true
+	}
+}
+
+// WithTrafficStats 以 collector 累计按协议分类的流量及 TopN 会话, 由 collector.Stats 获取快照;
+// 处理函数为 nil 时仍解码全部数据包, 可仅用于流量统计
+func WithTrafficStats(collector *TrafficCollector) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.traffic = collector
+	}
+}
+
+// WithCopyPayload 以数据的独立拷贝调用数据处理函数, 处理函数可直接保留传入的数据;
+// 默认传入会话缓存的切片, 仅在本次调用期间有效, 后续数据合并时可能被覆盖, 开启后每次调用均分配内存
+func WithCopyPayload() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.copyPayload = true
+	}
+}
+
+// WithDelimiter 按分隔符拆分会话数据, 仅以包含 delim 的完整帧调用数据处理函数, 每帧调用一次,
+// 不完整的尾部数据保留在会话缓存中与后续数据合并; 单帧长度受 WithMaxSessionBuffer 限制,
+// WithRawSegments 时不合并数据段, 尾部数据将被丢弃
+func WithDelimiter(delim []byte) CaptureOption {
+	return func(opts *captureOptions) {
+		if len(delim) <= 0 {
+			return
+		}
+
+		delim = slices.Clone(delim)
+		opts.framer = func(data []byte) int {
+			if idx := bytes.Index(data, delim); idx >= 0 {
+				return idx + len(delim)
+			}
+
+			return 0
+		}
+	}
+}
+
+// WithFixedFrameSize 按固定长度 size 拆分会话数据, 与 WithDelimiter 相同仅以完整帧调用数据处理函数,
+// 两者同时设置时后设置的生效
+func WithFixedFrameSize(size int) CaptureOption {
+	return func(opts *captureOptions) {
+		if size <= 0 {
+			return
+		}
+
+		opts.framer = func(data []byte) int {
+			if len(data) >= size {
+				return size
+			}
+
+			return 0
+		}
+	}
+}
+
+// WithTLSRecordFraming 按 TLS 记录拆分会话数据, 以 5 字节记录头中的长度确定记录边界, 仅以完整记录调用数据处理函数,
+// 不进行解密; 与 WithDelimiter 及 WithFixedFrameSize 同时设置时后设置的生效
+func WithTLSRecordFraming() CaptureOption {
+	return func(opts *captureOptions) {
+		opts.framer = func(data []byte) int {
+			if len(data) < tlsRecordHeader {
+				return 0
+			}
+
+			if size := tlsRecordHeader + int(binary.BigEndian.Uint16(data[3:])); len(data) >= size {
+				return size
+			}
+
+			return 0
+		}
+	}
+}
+
+// WithBPFProgram 以预编译的 BPF 程序过滤, 与 filter 参数互斥, 可避免重复编译及以程序构造过滤,
+// 链路类型要求见 Capture.SetFilterProgram
+func WithBPFProgram(insts []libpcap.BPFInstruction) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.bpfProgram = insts
+	}
+}
+
+// WithCaptureResult 抓包结束时将处理的数据包数量, 字节数及结束原因写入 result,
+// 用于确认离线文件是否完整处理; result 在 StartCapture 返回后有效
+func WithCaptureResult(result *CaptureResult) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.result = result
+	}
+}
+
+// WithMidStream 设置是否跟踪未观察到握手过程的 TCP 会话, 默认开启,
+// 开启时以首个携带数据的报文作为会话起点, 首段数据可能为不完整的消息, 可由 FlowInfo.FromHandshake 区分;
+// 关闭时仅重组观察到 SYN 的会话, 适用于对数据完整性要求严格的场景
+func WithMidStream(allow bool) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.midStream = allow
+	}
+}
+
+// WithCloseOnExit 设置 StartCapture 返回时是否关闭数据源, 以及时释放文件描述符及内核缓冲,
+// 关闭操作可重复执行
+func WithCloseOnExit(close bool) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.closeOnExit = close
+	}
+}
+
+// WithStats 抓包过程中按 interval 周期上报数据源统计信息, 抓包结束时额外上报一次,
+// interval <= 0 时使用 DefaultStatsInterval, 数据源不支持统计时忽略
+func WithStats(interval time.Duration, fn StatsHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		if interval <= 0 {
+			interval = DefaultStatsInterval
+		}
+
+		opts.statsInterval = interval
+		opts.statsFn = fn
+	}
+}
+
+// WithPcapWriter 将抓取到的数据包以 pcap 格式同步写入 w, 便于离线复现问题,
+// 写入内容为 BPF 过滤后的原始数据包, 链路层类型取自数据源, 写入失败时仅停止写入
+func WithPcapWriter(w io.Writer) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.pcapWriter = w
+	}
+}
+
+// WithPcapRotation 将抓取到的数据包轮转写入 prefix-0001.pcap, prefix-0002.pcap 等 pcap 文件, 写入内容与 WithPcapWriter 相同;
+// 当前文件写入后超出 maxBytes 或首个数据包起已超出 maxAge 时切换至新文件, 时长以数据包时间戳计算,
+// maxFiles > 0 时仅保留最新的 maxFiles 个文件, 更早的文件被删除; 各参数 <= 0 时不限制.
+// 切换时先创建新文件再关闭旧文件, 不会丢失数据包; 已存在的轮转文件不被覆盖, 编号在其最大序号后继续并计入 maxFiles,
+// 每次 Run 结束时关闭当前文件, 再次 Run 时写入下一个文件; 创建或写入文件失败时仅停止写入
+func WithPcapRotation(prefix string, maxBytes int64, maxAge time.Duration, maxFiles int) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.rotation = &pcapRotation{
+			prefix:   prefix,
+			maxBytes: maxBytes,
+			maxAge:   maxAge,
+			maxFiles: maxFiles,
+		}
+	}
+}
+
+// WithPayloadFilter 仅在会话当前累积的数据匹配 re 时调用数据处理函数,
+// 不匹配的数据仍保留在会话缓存中与后续数据合并, 因此 re 应能匹配不完整消息的前缀部分;
+// 该过滤仅为便利功能, 所有数据包仍需完成解码及重组, 无法替代 BPF 过滤
+func WithPayloadFilter(re *regexp.Regexp) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.payload = re
+	}
+}
+
+// WithWorkers 设置数据处理工作协程数量, 数据包按会话哈希分发, 开启 VXLAN 或 GRE 解封装时以内层会话计算, 同一会话的双向数据包总由同一协程处理,
+// 以保证会话内数据顺序; workers > 1 时 fn 及 Observer 将被并发调用, 需自行保证并发安全,
+// 最大跟踪会话数量由各协程均分; 抓包结束时将处理完已分发的数据包后返回
+func WithWorkers(workers int) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.workers = workers
+	}
+}
+
+// WithQueueSize 设置读取协程与处理协程间的待处理数据包队列长度, 默认为 DefaultQueueSize,
+// 较大的队列可吸收突发流量, 队列深度可通过同时实现 QueueObserver 的 Observer 观测
+func WithQueueSize(size int) CaptureOption {
+	return func(opts *captureOptions) {
+		if size > 0 {
+			opts.queueSize = size
+		}
+	}
+}
+
+// WithZeroCopy 设置是否以零拷贝方式读取数据源, Ethernet 及 Linux SLL 链路上的 IP/TCP/UDP 报文
+// 将以预分配的协议层解码, 避免逐包分配内存; 读取缓冲区在每次读取后复用,
+// 传输层数据在缓存或交由 fn 处理前均已拷贝, fn 收到的数据不受影响;
+// 隧道报文及其他链路层类型仍拷贝后完整解码, 与 WithWorkers 同时使用时不生效
+func WithZeroCopy(enable bool) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.zeroCopy = enable
+	}
+}
+
+// WithDecodeTargets 设置零拷贝快速解码所用的 IP 及传输层, 仅在 WithZeroCopy 生效时使用, 使用约定见 DecodeTargets
+func WithDecodeTargets(targets *DecodeTargets) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.decodeTargets = targets
+	}
+}
+
+// WithSessionOpen 设置会话建立回调, 与数据处理函数在同一协程中调用
+func WithSessionOpen(fn SessionOpenHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.onOpen = fn
+	}
+}
+
+// WithSessionClose 设置会话结束回调, 会话因 FIN, RST, 空闲超时, 容量淘汰, 处理出错(ErrorAbortFlow)或抓包结束而删除时调用,
+// RST 将同时结束双向会话
+func WithSessionClose(fn SessionCloseHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.onClose = fn
+	}
+}
+
+// WithSessionCloseChain 追加会话结束回调, fn 先于已设置的会话结束回调调用, 两者均保留;
+// 供基于抓包构建的上层处理占用会话结束回调, 应置于调用方选项之后
+func WithSessionCloseChain(fn SessionCloseHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		prev := opts.onClose
+
+		opts.onClose = func(flow FlowInfo, reason CloseReason) {
+			fn(flow, reason)
+
+			if prev != nil {
+				prev(flow, reason)
+			}
+		}
+	}
+}
+
+// WithIPv4Defrag 开启 IPv4 分片重组, 分片收齐后再进行传输层处理,
+// 超过 timeout 仍未收齐的分片将被丢弃, timeout <= 0 时使用 DefaultDefragTimeout;
+// 与 WithWorkers 同时使用时数据包仅按网络层地址分发
+func WithIPv4Defrag(timeout time.Duration) CaptureOption {
+	return func(opts *captureOptions) {
+		if timeout <= 0 {
+			timeout = DefaultDefragTimeout
+		}
+
+		opts.defrag = timeout
+	}
+}
+
+// WithTruncated 设置数据包被截断(抓取长度小于原始长度)时的回调, 被截断的数据包仍将继续处理,
+// 未设置时仅在首次截断时输出告警日志
+func WithTruncated(fn TruncatedHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.onTruncated = fn
+	}
+}
+
+// WithDecodeError 设置数据包解码失败(报文损坏)时的回调, 解码失败的数据包仍将继续处理已解码的部分,
+// gopacket 不支持的协议类型不触发回调; 未设置时以 Debug 级别输出包含原始报文的日志
+func WithDecodeError(fn DecodeErrorHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.onDecodeError = fn
+	}
+}
+
+// WithRawPacketHook 设置原始数据包回调, 每个读取的数据包在网络层及传输层处理前均调用一次, 不区分协议,
+// 可用于自定义统计, 采样或协议层提取; 回调返回 false 时跳过该数据包的后续处理.
+// WithWorkers 时在各工作协程中并发调用, 零拷贝读取时数据包以延迟解码构造, 仅在回调期间有效
+func WithRawPacketHook(fn RawPacketHook) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.rawHook = fn
+	}
+}
+
+// WithDedup 丢弃 window 时间内方向, 地址端口, IP 标识, 序列号, 确认号, 标志位及负载完全相同的 TCP/UDP 数据包, 用于 SPAN 端口将同一报文镜像两次的场景,
+// 窗口以抓取时间计算, 宜设置为数毫秒; TCP 重传间隔通常远大于窗口, 不受影响, window <= 0 时不去重
+func WithDedup(window time.Duration) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.dedup = window
+	}
+}
+
+// WithSampling 按 1/n 抽样处理, n <= 1 时不抽样; perFlow 为 false 时按读取顺序每 n 个数据包处理一个,
+// 会话数据将缺失片段, TCP 重组因序列号空洞不再交付后续数据, 仅适用于 WithRawSegments 或 UDP 等逐包统计;
+// perFlow 为 true 时按会话哈希选取 1/n 的会话, 双向数据包均处理, 选中会话的重组保持完整, 无会话的 ICMP 报文不抽样
+func WithSampling(n int, perFlow bool) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.sampling = n
+		opts.flowSampling = perFlow
+	}
+}
+
+// WithDecoder 以 decoder 替代数据源链路类型对应的解码器, 用于解码非标准的链路层封装.
+// decoder 需解码自身协议层并通过 PacketBuilder.AddLayer 添加, 再以 PacketBuilder.NextDecoder 将剩余数据
+// 交由后续协议层 (如 layers.LayerTypeEthernet) 解码, 以 gopacket.RegisterLayerType 注册的 LayerType 即满足要求;
+// 解码结果中包含 IP 层时即可正常重组. BPF 过滤仍按数据源的链路类型编译, 设置后零拷贝读取不可用
+func WithDecoder(decoder gopacket.Decoder) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.decoder = decoder
+	}
+}
+
+// WithReplayTiming 按数据包抓取时间间隔回放, speed 为回放倍速, 1.0 为原始速度,
+// speed <= 0 时不控制回放节奏, 适用于离线文件(file://)数据源
+func WithReplayTiming(speed float64) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.replay = speed
+	}
+}
+
+// WithFlushOnCancel 设置 ctx 取消时的剩余数据处理函数, 抓包返回前将各会话缓存中尚未被处理的数据交由 fn,
+// fn 收到的均为不完整的部分数据, ts 为会话最后一个数据包的抓取时间, 其返回的已处理长度将被忽略, 返回错误时停止交付;
+// 与 WithWorkers 同时使用时 fn 将被并发调用; 未设置时丢弃剩余数据, 数据源读取完毕时由 WithFlushOnEOF 处理
+func WithFlushOnCancel(fn core.DataHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.onFlush = fn
+	}
+}
+
+// WithFlushOnEOF 设置数据源读取完毕时的剩余数据处理函数, 离线文件在连接中途截断时,
+// 未收到 FIN 的会话缓存中尚未被处理的数据将在抓包返回前交由 fn, 之后会话以 CloseCaptureEnd 结束;
+// fn 的调用约定与 WithFlushOnCancel 相同, 可与其设置为同一函数
+func WithFlushOnEOF(fn core.DataHandler) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.onEOF = fn
+	}
+}
+
+// WithErrorPolicy 设置数据处理函数返回非 io.EOF 错误时的处理策略, 默认为 ErrorStop,
+// fn 返回 io.EOF 时总是正常结束抓包
+func WithErrorPolicy(policy ErrorPolicy) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.errorPolicy = policy
+	}
+}
+
+// WithDirectionFilter 仅处理指定方向的会话数据, 未选择的方向不进行重组, 默认为 DirectionAll;
+// 未观察到 TCP 握手的会话及 UDP 等无握手协议的会话方向未知, dropUnknown 为 false 时全部交付, 否则丢弃
+func WithDirectionFilter(direction DirectionFilter, dropUnknown bool) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.direction = direction
+		opts.dropUnknown = dropUnknown
+	}
+}
+
+// WithTimeRange 仅处理抓取时间在 [start, end] 区间内的数据包, start 之前的数据包在解码前跳过,
+// 超过 end 后即结束抓包; 适用于按时间顺序记录的离线文件(file://)数据源, 零值表示不限制
+func WithTimeRange(start, end time.Time) CaptureOption {
+	return func(opts *captureOptions) {
+		opts.rangeStart = start
+		opts.rangeEnd = end
+	}
+}
+
+// WithObserver 设置抓包过程观测接口, 用于接入外部监控, observer 为 nil 时不观测
+func WithObserver(observer Observer) CaptureOption {
+	return func(opts *captureOptions) {
+		if observer == nil {
+			observer = nopObserver{}
+		}
+
+		opts.observer = observer
+	}
+}
+
+// WithLogger 设置抓包过程的日志输出, 默认为 slog.Default(), logger 为 nil 时丢弃所有日志
+func WithLogger(logger *slog.Logger) CaptureOption {
+	return func(opts *captureOptions) {
+		if logger == nil {
+			logger = slog.New(discardHandler{})
+		}
+
+		opts.logger = logger
+	}
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
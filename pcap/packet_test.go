@@ -0,0 +1,141 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type testSegment struct {
+	src, dst string
+	sport    int
+	dport    int
+	seq      uint32
+	syn, ack bool
+	fin, rst bool
+	payload  []byte
+	ts       time.Time
+	udp      bool
+	vlans    []uint16
+	ipID     uint16
+}
+
+func networkLayer(src, dst net.IP, proto layers.IPProtocol) (gopacket.SerializableLayer, gopacket.NetworkLayer, layers.EthernetType) {
+	if src.To4() != nil {
+		ip := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: proto,
+			SrcIP:    src.To4(),
+			DstIP:    dst.To4(),
+		}
+		return ip, ip, layers.EthernetTypeIPv4
+	}
+
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   64,
+		NextHeader: proto,
+		SrcIP:      src,
+		DstIP:      dst,
+	}
+	return ip, ip, layers.EthernetTypeIPv6
+}
+
+func buildSegment(t testing.TB, seg testSegment) gopacket.Packet {
+	t.Helper()
+
+	src, dst := net.ParseIP(seg.src), net.ParseIP(seg.dst)
+
+	proto := layers.IPProtocolTCP
+	if seg.udp {
+		proto = layers.IPProtocolUDP
+	}
+
+	ip, network, etherType := networkLayer(src, dst, proto)
+	if ip4, ok := network.(*layers.IPv4); ok {
+		ip4.Id = seg.ipID
+	}
+
+	ether := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+		EthernetType: etherType,
+	}
+	stack := []gopacket.SerializableLayer{ether}
+
+	for idx, id := range seg.vlans {
+		if idx == 0 {
+			ether.EthernetType = layers.EthernetTypeDot1Q
+			if len(seg.vlans) > 1 {
+				ether.EthernetType = layers.EthernetTypeQinQ
+			}
+		} else {
+			stack[len(stack)-1].(*layers.Dot1Q).Type = layers.EthernetTypeDot1Q
+		}
+
+		stack = append(stack, &layers.Dot1Q{VLANIdentifier: id, Type: etherType})
+	}
+
+	stack = append(stack, ip)
+
+	if seg.udp {
+		udp := &layers.UDP{
+			SrcPort: layers.UDPPort(seg.sport),
+			DstPort: layers.UDPPort(seg.dport),
+		}
+		udp.SetNetworkLayerForChecksum(network)
+		stack = append(stack, udp)
+	} else {
+		tcp := &layers.TCP{
+			SrcPort: layers.TCPPort(seg.sport),
+			DstPort: layers.TCPPort(seg.dport),
+			Seq:     seg.seq,
+			SYN:     seg.syn,
+			ACK:     seg.ack,
+			FIN:     seg.fin,
+			RST:     seg.rst,
+			Window:  65535,
+		}
+		tcp.SetNetworkLayerForChecksum(network)
+		stack = append(stack, tcp)
+	}
+
+	stack = append(stack, gopacket.Payload(seg.payload))
+
+	return buildPacket(t, layers.LinkTypeEthernet, seg.ts, stack...)
+}
+
+func buildPacket(t testing.TB, linkType layers.LinkType, ts time.Time, stack ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(
+		buf,
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		stack...,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := gopacket.NewPacket(buf.Bytes(), linkType, gopacket.Default)
+	pkg.Metadata().Timestamp = ts
+	pkg.Metadata().CaptureLength = len(buf.Bytes())
+	pkg.Metadata().Length = len(buf.Bytes())
+
+	return pkg
+}
+
+func feedPackets(pkts ...gopacket.Packet) <-chan gopacket.Packet {
+	ch := make(chan gopacket.Packet, len(pkts))
+
+	for _, pkg := range pkts {
+		ch <- pkg
+	}
+	close(ch)
+
+	return ch
+}
@@ -0,0 +1,14 @@
+package pcap
+
+// ErrorPolicy 数据处理函数返回非 io.EOF 错误时的处理策略
+type ErrorPolicy int
+
+//go:generate stringer -type ErrorPolicy -linecomment
+const (
+	// ErrorStop 停止抓包并返回该错误
+	ErrorStop ErrorPolicy = iota // stop
+	// ErrorContinue 忽略错误继续抓包, 本次数据保留在会话缓存中, 与后续数据合并后再次交付
+	ErrorContinue // continue
+	// ErrorAbortFlow 删除出错的会话缓存, 其他会话继续处理, 该会话的后续数据将作为新会话处理
+	ErrorAbortFlow // abort_flow
+)
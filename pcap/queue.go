@@ -0,0 +1,41 @@
+package pcap
+
+import (
+	"context"
+
+	"github.com/google/gopacket"
+)
+
+// packetQueue 在独立协程中读取并解码数据包, 写入长度为 size 的队列,
+// 数据源读取完毕或 ctx 结束时关闭队列; 每写入一个数据包即上报队列深度
+func packetQueue(ctx context.Context, src *gopacket.PacketSource, size int, observer Observer) <-chan gopacket.Packet {
+	queue := make(chan gopacket.Packet, size)
+	depth, _ := observer.(QueueObserver)
+
+	go func() {
+		defer close(queue)
+
+		for {
+			pkg, err := src.NextPacket()
+			if err != nil {
+				if readFatal(err) {
+					return
+				}
+
+				continue
+			}
+
+			select {
+			case queue <- pkg:
+			case <-ctx.Done():
+				return
+			}
+
+			if depth != nil {
+				depth.QueueDepth(len(queue), size)
+			}
+		}
+	}()
+
+	return queue
+}
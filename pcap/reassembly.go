@@ -0,0 +1,157 @@
+package pcap
+
+import (
+	"slices"
+	"time"
+)
+
+// seqDiff 计算TCP序列号差值, 按模 2^32 比较以正确处理序列号回绕
+func seqDiff(a, b uint32) int32 {
+	return int32(a - b)
+}
+
+type tcpFragment struct {
+	seq  uint32
+	data []byte
+}
+
+func (frag *tcpFragment) end() uint32 {
+	return frag.seq + uint32(len(frag.data))
+}
+
+// reassemblyLimit 乱序缓存限制, 缓存数据超出 pending 或空洞持续超出 timeout 时跳过空洞, 均 <= 0 时不限制
+type reassemblyLimit struct {
+	pending int
+	timeout time.Duration
+}
+
+// tcpStream TCP单向数据流序列号跟踪, 按序输出数据并缓存乱序到达的报文
+type tcpStream struct {
+	synced  bool
+	next    uint32
+	pending []tcpFragment
+	// pendingBytes 乱序缓存的数据长度, gapSince 为当前空洞开始等待时的数据包抓取时间
+	pendingBytes int
+	gapSince     time.Time
+}
+
+// Sync 以SYN报文的序列号初始化数据流
+func (s *tcpStream) Sync(seq uint32) {
+	s.synced = true
+	s.next = seq + 1
+	s.pending = s.pending[:0]
+	s.pendingBytes = 0
+}
+
+// Push 接收报文数据, 按序可用的数据通过 emit 输出, ts 为报文抓取时间;
+// 未观察到握手的数据流以首个数据报文的序列号作为起点,
+// 乱序缓存超出 limit 时跳过空洞继续输出, 返回跳过的空洞长度
+func (s *tcpStream) Push(seq uint32, payload []byte, ts time.Time, limit reassemblyLimit, emit func([]byte)) (skipped int) {
+	if len(payload) <= 0 {
+		return 0
+	}
+
+	if !s.synced {
+		s.Sync(seq - 1)
+	}
+
+	switch diff := seqDiff(seq, s.next); {
+	case diff > 0:
+		s.hold(seq, payload, ts)
+	case diff < 0 && -int(diff) >= len(payload):
+		// 重传报文, 数据均已输出
+	default:
+		// 重传报文, 仅保留超出已输出部分的数据
+		if diff < 0 {
+			payload = payload[-int(diff):]
+		}
+
+		emit(payload)
+		s.next += uint32(len(payload))
+
+		s.flush(emit)
+		s.gapSince = ts
+	}
+
+	for len(s.pending) > 0 && s.exceeded(ts, limit) {
+		skipped += s.skip(emit)
+		s.gapSince = ts
+	}
+
+	return skipped
+}
+
+// Drain 跳过全部空洞输出缓存的乱序数据, 用于会话结束时, 返回跳过的空洞长度
+func (s *tcpStream) Drain(emit func([]byte)) (skipped int) {
+	for len(s.pending) > 0 {
+		skipped += s.skip(emit)
+	}
+
+	return skipped
+}
+
+// Pending 乱序缓存的数据长度
+func (s *tcpStream) Pending() int {
+	return s.pendingBytes
+}
+
+func (s *tcpStream) exceeded(ts time.Time, limit reassemblyLimit) bool {
+	return limit.pending > 0 && s.pendingBytes > limit.pending ||
+		limit.timeout > 0 && ts.Sub(s.gapSince) >= limit.timeout
+}
+
+// skip 跳过首个空洞, 输出其后按序可用的缓存数据, 返回空洞长度
+func (s *tcpStream) skip(emit func([]byte)) int {
+	gap := int(seqDiff(s.pending[0].seq, s.next))
+	s.next = s.pending[0].seq
+
+	s.flush(emit)
+
+	return max(gap, 0)
+}
+
+// hold 缓存乱序报文, 按序列号排序, 数据须复制以免底层缓冲被复用
+func (s *tcpStream) hold(seq uint32, payload []byte, ts time.Time) {
+	frag := tcpFragment{seq: seq, data: slices.Clone(payload)}
+
+	if len(s.pending) <= 0 {
+		s.gapSince = ts
+	}
+
+	idx, found := slices.BinarySearchFunc(s.pending, frag, func(v, target tcpFragment) int {
+		return int(seqDiff(v.seq, target.seq))
+	})
+
+	if found {
+		// 相同序列号的重复报文, 保留数据较长者
+		if len(s.pending[idx].data) < len(frag.data) {
+			s.pendingBytes += len(frag.data) - len(s.pending[idx].data)
+			s.pending[idx] = frag
+		}
+
+		return
+	}
+
+	s.pending = slices.Insert(s.pending, idx, frag)
+	s.pendingBytes += len(frag.data)
+}
+
+// flush 输出空洞已被填补的缓存报文
+func (s *tcpStream) flush(emit func([]byte)) {
+	for len(s.pending) > 0 {
+		frag := s.pending[0]
+
+		if seqDiff(frag.seq, s.next) > 0 {
+			return
+		}
+
+		// 先移出缓存再输出, emit 中统计的乱序缓存长度不含本次输出的数据
+		s.pending = s.pending[1:]
+		s.pendingBytes -= len(frag.data)
+
+		if overlap := seqDiff(s.next, frag.seq); int(overlap) < len(frag.data) {
+			emit(frag.data[overlap:])
+			s.next = frag.end()
+		}
+	}
+}
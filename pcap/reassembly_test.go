@@ -0,0 +1,239 @@
+package pcap
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+)
+
+func captureStream(t *testing.T, segs ...testSegment) string {
+	t.Helper()
+
+	return captureStreamWith(t, newCaptureOptions(), segs...)
+}
+
+func captureStreamWith(t *testing.T, opts *captureOptions, segs ...testSegment) string {
+	t.Helper()
+
+	pkts := make([]gopacket.Packet, 0, len(segs))
+	for _, seg := range segs {
+		if seg.src == "" {
+			seg.src, seg.dst, seg.sport, seg.dport = "192.168.1.1", "192.168.1.2", 1000, 2000
+		}
+
+		pkts = append(pkts, buildSegment(t, seg))
+	}
+
+	var stream []byte
+
+	if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		stream = append(stream, data...)
+		return len(data), nil
+	}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(stream)
+}
+
+func TestReassemblyOutOfOrder(t *testing.T) {
+	stream := captureStream(
+		t,
+		testSegment{seq: 100, syn: true},
+		testSegment{seq: 103, payload: []byte("333")},
+		testSegment{seq: 101, payload: []byte("1")},
+		testSegment{seq: 102, payload: []byte("2")},
+	)
+
+	if stream != "12333" {
+		t.Fatalf("out of order reassembly failed: %s", stream)
+	}
+}
+
+func TestReassemblyRetransmission(t *testing.T) {
+	expected := captureStream(
+		t,
+		testSegment{seq: 100, syn: true},
+		testSegment{seq: 101, payload: []byte("aaa")},
+		testSegment{seq: 104, payload: []byte("bbb")},
+		testSegment{seq: 107, payload: []byte("ccc")},
+	)
+
+	for name, segs := range map[string][]testSegment{
+		"duplicate": {
+			{seq: 101, payload: []byte("aaa")},
+			{seq: 104, payload: []byte("bbb")},
+			{seq: 104, payload: []byte("bbb")},
+			{seq: 107, payload: []byte("ccc")},
+		},
+		"partial overlap": {
+			{seq: 101, payload: []byte("aaa")},
+			{seq: 102, payload: []byte("aabbb")},
+			{seq: 107, payload: []byte("ccc")},
+		},
+		"pending duplicate": {
+			{seq: 107, payload: []byte("ccc")},
+			{seq: 104, payload: []byte("bbb")},
+			{seq: 104, payload: []byte("bbb")},
+			{seq: 101, payload: []byte("aaa")},
+		},
+		"pending overlap": {
+			{seq: 105, payload: []byte("bbccc")},
+			{seq: 104, payload: []byte("b")},
+			{seq: 101, payload: []byte("aaa")},
+		},
+	} {
+		stream := captureStream(t, append([]testSegment{{seq: 100, syn: true}}, segs...)...)
+
+		if stream != expected {
+			t.Fatalf("%s: retransmission not de-duplicated: %s != %s", name, stream, expected)
+		}
+	}
+}
+
+func TestReassemblyMidStream(t *testing.T) {
+	strict := testSegment{src: "192.168.1.1", dst: "192.168.1.2", sport: 1001, dport: 2000}
+
+	segs := []testSegment{
+		{seq: 5000, payload: []byte("mid-")},
+		{seq: 5004, payload: []byte("stream")},
+		strict, strict,
+	}
+
+	segs[2].seq, segs[2].syn = 100, true
+	segs[3].seq, segs[3].payload = 101, []byte("strict")
+
+	if stream := captureStream(t, segs...); stream != "mid-streamstrict" {
+		t.Fatalf("mid-stream session not reassembled: %s", stream)
+	}
+
+	if stream := captureStreamWith(t, newCaptureOptions(WithMidStream(false)), segs...); stream != "strict" {
+		t.Fatalf("strict handshake mode mismatch: %s", stream)
+	}
+}
+
+func TestReassemblyGap(t *testing.T) {
+	start := time.Now()
+
+	for name, c := range map[string]struct {
+		limit    CaptureOption
+		segs     []testSegment
+		expected string
+		lost     uint64
+	}{
+		// 乱序缓存超出上限, 跳过 101-102 的空洞
+		"pending limit": {WithReassemblyLimit(4, 0), []testSegment{
+			{seq: 100, syn: true, ts: start},
+			{seq: 103, payload: []byte("333"), ts: start},
+			{seq: 106, payload: []byte("66"), ts: start},
+			{seq: 101, payload: []byte("12"), ts: start},
+		}, "33366", 2},
+		// 空洞等待超时
+		"gap timeout": {WithReassemblyLimit(0, time.Second), []testSegment{
+			{seq: 100, syn: true, ts: start},
+			{seq: 103, payload: []byte("333"), ts: start},
+			{seq: 106, payload: []byte("6"), ts: start.Add(500 * time.Millisecond)},
+			{seq: 107, payload: []byte("7"), ts: start.Add(time.Second)},
+		}, "33367", 2},
+		// FIN 时交付乱序缓存中的数据
+		"fin drain": {WithReassemblyLimit(0, 0), []testSegment{
+			{seq: 100, syn: true, ts: start},
+			{seq: 101, payload: []byte("1"), ts: start},
+			{seq: 104, payload: []byte("44"), ts: start},
+			{seq: 106, fin: true, ack: true, ts: start},
+		}, "144", 2},
+		// 会话结束时仍在乱序缓存中的数据计入丢失
+		"pending on close": {WithReassemblyLimit(0, 0), []testSegment{
+			{seq: 100, syn: true, ts: start},
+			{seq: 104, payload: []byte("44"), ts: start},
+		}, "", 2},
+	} {
+		var lost uint64
+
+		opts := newCaptureOptions(c.limit, WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			lost += flow.Counters.LostBytes
+		}))
+
+		if stream := captureStreamWith(t, opts, c.segs...); stream != c.expected || lost != c.lost {
+			t.Fatalf("%s: gap reassembly mismatch: %q lost %d, expect %q lost %d", name, stream, lost, c.expected, c.lost)
+		}
+	}
+}
+
+func TestReassemblyWraparound(t *testing.T) {
+	for name, c := range map[string]struct {
+		segs     []testSegment
+		expected string
+	}{
+		// SYN 位于 2^32-1, 首个数据字节序列号回绕为 0
+		"syn at boundary": {[]testSegment{
+			{seq: 0xffffffff, syn: true},
+			{seq: 3, payload: []byte("ccc")},
+			{seq: 0, payload: []byte("aaa")},
+			{seq: 0, payload: []byte("aaa")},
+		}, "aaaccc"},
+		// 数据段跨越边界, 乱序及重叠重传均需按模比较
+		"segment across boundary": {[]testSegment{
+			{seq: 0xfffffff9, syn: true},
+			{seq: 0x00000000, payload: []byte("bcc")},
+			{seq: 0xfffffffa, payload: []byte("aaaaa")},
+			{seq: 0xfffffffd, payload: []byte("aabb")},
+			{seq: 0x00000003, payload: []byte("c")},
+		}, "aaaaabbccc"},
+		// 未观察到握手, 起点位于边界之前
+		"mid-stream across boundary": {[]testSegment{
+			{seq: 0xfffffffe, payload: []byte("ab")},
+			{seq: 0x00000002, payload: []byte("ef")},
+			{seq: 0x00000000, payload: []byte("cd")},
+		}, "abcdef"},
+	} {
+		if stream := captureStream(t, c.segs...); stream != c.expected {
+			t.Fatalf("%s: wraparound reassembly mismatch: %s != %s", name, stream, c.expected)
+		}
+	}
+}
+
+func TestReassemblyFullConsume(t *testing.T) {
+	payloads := []string{"abc", "defghijklmno", "p", "qrstuvwxyz0123456789", "!", "@#", "$%^"}
+
+	segs := []testSegment{{seq: 100, syn: true}}
+	seq := uint32(101)
+	for _, payload := range payloads {
+		segs = append(segs, testSegment{seq: seq, ack: true, payload: []byte(payload)})
+		seq += uint32(len(payload))
+	}
+
+	pkts := make([]gopacket.Packet, 0, len(segs))
+	for _, seg := range segs {
+		seg.src, seg.dst, seg.sport, seg.dport = "192.168.1.1", "192.168.1.2", 1000, 2000
+		pkts = append(pkts, buildSegment(t, seg))
+	}
+
+	var (
+		stream []byte
+		calls  int
+	)
+
+	// 初始容量小于单个数据段, 合并时缓冲区多次重新分配; 交替完整消费与部分消费, 最后一次完整消费
+	if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		calls++
+
+		used := len(data)
+		if calls%2 == 0 {
+			used = len(data) / 2
+		}
+
+		stream = append(stream, data[:used]...)
+		return used, nil
+	}, newCaptureOptions(WithSessionBufferSize(8))); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := strings.Join(payloads, ""); string(stream) != expected {
+		t.Fatalf("consumed stream mismatch: %q != %q", stream, expected)
+	}
+}
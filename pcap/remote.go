@@ -0,0 +1,73 @@
+package pcap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// remoteDialTimeout 默认远程抓包连接超时时间
+	remoteDialTimeout = 10 * time.Second
+	// remoteHeaderTimeout 等待远端发送 pcap 文件头的最长时间, WithTimeout 更短时以其为准
+	remoteHeaderTimeout = 10 * time.Second
+)
+
+// Dialer 建立远程抓包连接, 与 net.Dialer.DialContext 签名相同
+type Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+// openRemote 连接远程抓包服务, source 格式为 host:port[/iface].
+//
+// 协议: 连接建立后发送以换行结尾的接口名 (未指定时为空行), 服务端随后持续发送 pcap 或 pcapng 格式的数据流,
+// 如 tcpdump -U -w - 的输出; 数据包随到达逐个交付, 服务端关闭连接时抓包结束.
+// 认证及 TLS 不在协议范围内, 可通过 WithDialer 在返回连接前完成.
+// 该协议为本包自定义的简单 TCP 数据流, 与 libpcap 的 RPCAP (rpcapd) 协议不兼容
+func openRemote(source string, options *handlerOptions) (PacketSource, error) {
+	address, iface, _ := strings.Cut(source, "/")
+	if address == "" {
+		return nil, errors.WithStack(fmt.Errorf("%w: remote capture address not specified", ErrInvalidDataSource))
+	}
+
+	dial := options.dialer
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: remoteDialTimeout}).DialContext
+	}
+
+	conn, err := dial(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect remote capture failed")
+	}
+
+	if _, err := conn.Write([]byte(iface + "\n")); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "send remote capture request failed")
+	}
+
+	// 文件头解析完成前设置读超时, 避免远端不发送数据时永久阻塞
+	timeout := remoteHeaderTimeout
+	if options.timeout > 0 && options.timeout < timeout {
+		timeout = options.timeout
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "set remote capture deadline failed")
+	}
+
+	src, err := openStream(conn, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "clear remote capture deadline failed")
+	}
+
+	return src, nil
+}
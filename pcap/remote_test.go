@@ -0,0 +1,149 @@
+package pcap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestCaptureRemote(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var packets []gopacket.Packet
+	for _, payload := range []string{"live", "stream"} {
+		packets = append(packets, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte(payload), ts: time.Now(),
+		}))
+	}
+
+	received := make(chan string, 2)
+	requests := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		iface, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		requests <- iface
+
+		writer := pcapgo.NewWriter(conn)
+		if err := writer.WriteFileHeader(DefaultSnapLen, layers.LinkTypeEthernet); err != nil {
+			return
+		}
+
+		for _, pkg := range packets {
+			if err := writer.WritePacket(pkg.Metadata().CaptureInfo, pkg.Data()); err != nil {
+				return
+			}
+
+			// 前一个数据包交付后才发送下一个, 验证数据随到达交付
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	}()
+
+	var (
+		dialed string
+		info   SourceInfo
+	)
+
+	handler, err := CreateHandler(
+		"tcppcap://"+listener.Addr().String()+"/eth0",
+		WithSourceInfo(&info),
+		WithDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = address
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result []string
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		received <- string(data)
+		return len(data), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if dialed != listener.Addr().String() {
+		t.Errorf("dialer not used: %q", dialed)
+	}
+
+	if info.Device != "eth0" || info.LinkType != layers.LinkTypeEthernet {
+		t.Errorf("remote source info mismatch: %+v", info)
+	}
+
+	if iface := <-requests; iface != "eth0\n" {
+		t.Errorf("remote request mismatch: %q", iface)
+	}
+
+	if !slices.Equal(result, []string{"live", "stream"}) {
+		t.Fatalf("remote delivery mismatch: %v", result)
+	}
+}
+
+func TestCaptureRemoteInvalid(t *testing.T) {
+	if _, err := CreateHandler("tcppcap:///eth0"); !errors.Is(err, ErrInvalidDataSource) {
+		t.Errorf("remote source without address should fail: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	if _, err := CreateHandler("tcppcap://" + addr); err == nil {
+		t.Error("unreachable remote source should fail")
+	}
+
+	// 远端不发送文件头时按读超时失败, 不永久阻塞
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	go func() {
+		conn, err := silent.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		io.Copy(io.Discard, conn)
+	}()
+
+	if _, err := CreateHandler("tcppcap://"+silent.Addr().String(), WithTimeout(100*time.Millisecond)); err == nil {
+		t.Error("silent remote source should fail")
+	}
+}
@@ -0,0 +1,65 @@
+package pcap
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// replaySource 按数据包抓取时间间隔控制读取节奏, 模拟实时流量
+type replaySource struct {
+	PacketSource
+	ctx   context.Context
+	speed float64
+
+	// 首个数据包的抓取时间及读取时间, 以此计算后续数据包的读取时间, 避免累计误差
+	origin time.Time
+	start  time.Time
+}
+
+func newReplaySource(ctx context.Context, src PacketSource, speed float64) *replaySource {
+	return &replaySource{
+		PacketSource: src,
+		ctx:          ctx,
+		speed:        speed,
+	}
+}
+
+func (src *replaySource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(src.PacketSource.ReadPacketData)
+}
+
+// ZeroCopyReadPacketData 零拷贝读取, 数据源不支持零拷贝时退化为普通读取
+func (src *replaySource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(zeroCopyRead(src.PacketSource))
+}
+
+func (src *replaySource) read(next packetReader) (data []byte, ci gopacket.CaptureInfo, err error) {
+	if data, ci, err = next(); err != nil {
+		return
+	}
+
+	if src.start.IsZero() {
+		src.origin, src.start = ci.Timestamp, time.Now()
+		return
+	}
+
+	delay := time.Until(src.start.Add(
+		time.Duration(float64(ci.Timestamp.Sub(src.origin)) / src.speed),
+	))
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-src.ctx.Done():
+		return nil, ci, io.EOF
+	case <-timer.C:
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func writeTimedPcap(t *testing.T, gaps ...time.Duration) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	writer := pcapgo.NewWriter(&buf)
+	if err := writer.WriteFileHeader(DefaultSnapLen, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Unix(1700000000, 0)
+
+	for _, gap := range append([]time.Duration{0}, gaps...) {
+		ts = ts.Add(gap)
+
+		frame := buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte("replay"),
+		}).Data()
+
+		if err := writer.WritePacket(gopacket.CaptureInfo{
+			Timestamp: ts, CaptureLength: len(frame), Length: len(frame),
+		}, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &buf
+}
+
+func TestCaptureReplayTiming(t *testing.T) {
+	handler, err := CreateHandlerFromReader(writeTimedPcap(t, 100*time.Millisecond, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	start := time.Now()
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		count++
+		return len(data), nil
+	}, WithReplayTiming(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 200ms 间隔以 2 倍速回放约需 100ms
+	if elapsed := time.Since(start); count != 3 || elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("replay timing mismatch: %d packets in %s", count, elapsed)
+	}
+}
+
+func TestCaptureReplayCancel(t *testing.T) {
+	handler, err := CreateHandlerFromReader(writeTimedPcap(t, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if err := StartCapture(ctx, handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, WithReplayTiming(1), WithZeroCopy(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("replay not cancelled: %s", elapsed)
+	}
+}
+
+func TestCaptureTimeRange(t *testing.T) {
+	buf := writeTimedPcap(t, time.Second, time.Second, time.Second, time.Second)
+
+	handler, err := CreateHandlerFromReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := time.Unix(1700000000, 0)
+
+	var offsets []time.Duration
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		offsets = append(offsets, ts.Sub(origin))
+		return len(data), nil
+	}, WithTimeRange(origin.Add(time.Second), origin.Add(3*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(offsets, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}) {
+		t.Fatalf("time range mismatch: %v", offsets)
+	}
+}
@@ -0,0 +1,106 @@
+package pcap
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/pkg/errors"
+)
+
+// StopReason 抓包结束原因
+type StopReason int
+
+//go:generate stringer -type StopReason -linecomment
+const (
+	// StopEOF 数据源读取完毕, 离线文件已完整处理
+	StopEOF StopReason = iota // eof
+	// StopReadError 数据源读取失败, 如离线文件被截断
+	StopReadError // read_error
+	// StopCanceled ctx 取消
+	StopCanceled // canceled
+	// StopHandler 数据处理函数返回 io.EOF 要求结束
+	StopHandler // handler
+	// StopError 抓包返回错误, 如按 ErrorStop 策略结束
+	StopError // error
+	// StopUnknown 无法归类的结束, 如数据源未返回错误即停止交付
+	StopUnknown // unknown
+)
+
+// CaptureResult 抓包结束时的统计及结束原因
+type CaptureResult struct {
+	// Packets 从数据源读取的数据包数量, Bytes 为其抓取长度之和,
+	// 提前结束时可能包含已读取但未处理的数据包
+	Packets uint64
+	Bytes   uint64
+	Reason  StopReason
+	// Err StopReadError 时为数据源的读取错误, StopError 时为抓包返回的错误, StopUnknown 时为数据源最后的读取错误
+	Err error
+}
+
+// countSource 统计读取的数据包, 并记录数据源的结束错误
+type countSource struct {
+	PacketSource
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+
+	mu  sync.Mutex
+	err error
+}
+
+func newCountSource(src PacketSource) *countSource {
+	return &countSource{PacketSource: src}
+}
+
+func (src *countSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(src.PacketSource.ReadPacketData)
+}
+
+// ZeroCopyReadPacketData 零拷贝读取, 数据源不支持零拷贝时退化为普通读取
+func (src *countSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(zeroCopyRead(src.PacketSource))
+}
+
+func (src *countSource) read(next packetReader) (data []byte, ci gopacket.CaptureInfo, err error) {
+	data, ci, err = next()
+
+	if err == nil {
+		src.packets.Add(1)
+		src.bytes.Add(uint64(ci.CaptureLength))
+	}
+
+	src.mu.Lock()
+	src.err = err
+	src.mu.Unlock()
+
+	return
+}
+
+// report 以抓包返回的错误, 处理函数是否要求结束及数据源状态填充结果
+func (src *countSource) report(ctx context.Context, err error, stopped bool, result *CaptureResult) {
+	src.mu.Lock()
+	readErr := src.err
+	src.mu.Unlock()
+
+	*result = CaptureResult{
+		Packets: src.packets.Load(),
+		Bytes:   src.bytes.Load(),
+	}
+
+	switch {
+	case err != nil:
+		result.Reason, result.Err = StopError, err
+	case stopped:
+		result.Reason = StopHandler
+	case ctx.Err() != nil:
+		result.Reason = StopCanceled
+	case errors.Is(readErr, io.EOF):
+		result.Reason = StopEOF
+	case readErr != nil && readFatal(readErr):
+		result.Reason, result.Err = StopReadError, readErr
+	default:
+		result.Reason, result.Err = StopUnknown, readErr
+	}
+}
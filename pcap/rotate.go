@@ -0,0 +1,235 @@
+package pcap
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pkg/errors"
+)
+
+// pcapFileHeader pcap 文件头长度, pcapRecordHeader 单个数据包的记录头长度
+const (
+	pcapFileHeader   = 24
+	pcapRecordHeader = 16
+)
+
+// pcapRotation WithPcapRotation 的轮转参数
+type pcapRotation struct {
+	prefix   string
+	maxBytes int64
+	maxAge   time.Duration
+	maxFiles int
+}
+
+// rotateWriter 按大小及时长轮转写入 pcap 文件, 切换时先创建新文件再关闭旧文件,
+// 切换在写入数据包时同步进行, 不会丢失数据包
+type rotateWriter struct {
+	pcapRotation
+	snapLen  int
+	linkType layers.LinkType
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	seq    int
+	files  []string
+	file   *os.File
+	buffer *bufio.Writer
+	writer *pcapgo.Writer
+	size   int64
+	opened time.Time
+}
+
+func newRotateWriter(rotation *pcapRotation, snapLen int, linkType layers.LinkType, logger *slog.Logger) *rotateWriter {
+	w := rotateWriter{
+		pcapRotation: *rotation,
+		snapLen:      snapLen,
+		linkType:     linkType,
+		logger:       logger,
+	}
+
+	w.files, w.seq = w.existing()
+
+	return &w
+}
+
+// existing 按序号返回已存在的轮转文件及最大序号, 重启后在其后继续编号, 并计入 maxFiles
+func (w *rotateWriter) existing() ([]string, int) {
+	dir, base := filepath.Split(w.prefix)
+
+	entries, err := os.ReadDir(cmp.Or(dir, "."))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.logger.Warn(
+				"scan rotated pcap files failed:",
+				slog.String("prefix", w.prefix),
+				slog.Any("error", err),
+			)
+		}
+
+		return nil, 0
+	}
+
+	type rotated struct {
+		name string
+		seq  int
+	}
+
+	var files []rotated
+
+	for _, entry := range entries {
+		digits, ok := strings.CutPrefix(entry.Name(), base+"-")
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		if digits, ok = strings.CutSuffix(digits, ".pcap"); !ok {
+			continue
+		}
+
+		if seq, err := strconv.Atoi(digits); err == nil && seq > 0 && strings.Trim(digits, "0123456789") == "" {
+			files = append(files, rotated{name: filepath.Join(dir, entry.Name()), seq: seq})
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, 0
+	}
+
+	slices.SortFunc(files, func(a, b rotated) int { return cmp.Compare(a.seq, b.seq) })
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+
+	return names, files[len(files)-1].seq
+}
+
+// WritePacket 写入数据包, 当前文件超出大小或时长时先切换至新文件, 时长以数据包的时间戳计算
+func (w *rotateWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil || w.expired(ci, len(data)) {
+		if err := w.rotate(ci.Timestamp); err != nil {
+			return err
+		}
+	}
+
+	if err := w.writer.WritePacket(ci, data); err != nil {
+		return errors.WithStack(err)
+	}
+
+	w.size += int64(pcapRecordHeader + len(data))
+
+	return nil
+}
+
+// expired 当前文件已有数据包且写入后超出大小, 或已超出时长
+func (w *rotateWriter) expired(ci gopacket.CaptureInfo, length int) bool {
+	if w.size <= pcapFileHeader {
+		return false
+	}
+
+	return w.maxBytes > 0 && w.size+int64(pcapRecordHeader+length) > w.maxBytes ||
+		w.maxAge > 0 && ci.Timestamp.Sub(w.opened) >= w.maxAge
+}
+
+func (w *rotateWriter) rotate(ts time.Time) error {
+	var (
+		name string
+		file *os.File
+		err  error
+	)
+
+	// 不覆盖已存在的文件, 如其他进程同时写入的同名文件
+	for {
+		w.seq++
+		name = fmt.Sprintf("%s-%04d.pcap", w.prefix, w.seq)
+
+		file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	buffer := bufio.NewWriter(file)
+	writer := pcapgo.NewWriter(buffer)
+
+	if err := writer.WriteFileHeader(uint32(w.snapLen), w.linkType); err != nil {
+		file.Close()
+		os.Remove(name)
+
+		return errors.WithStack(err)
+	}
+
+	w.closeFile()
+
+	w.file, w.buffer, w.writer = file, buffer, writer
+	w.size, w.opened = pcapFileHeader, ts
+	w.files = append(w.files, name)
+
+	for w.maxFiles > 0 && len(w.files) > w.maxFiles {
+		if err := os.Remove(w.files[0]); err != nil && !os.IsNotExist(err) {
+			w.logger.Warn(
+				"remove rotated pcap file failed:",
+				slog.String("file", w.files[0]),
+				slog.Any("error", err),
+			)
+		}
+
+		w.files = w.files[1:]
+	}
+
+	return nil
+}
+
+// close 关闭当前文件, 后续写入时创建新文件
+func (w *rotateWriter) close() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closeFile()
+}
+
+func (w *rotateWriter) closeFile() {
+	if w.file == nil {
+		return
+	}
+
+	err := w.buffer.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		w.logger.Warn(
+			"close rotated pcap file failed:",
+			slog.String("file", w.file.Name()),
+			slog.Any("error", err),
+		)
+	}
+
+	w.file, w.buffer, w.writer = nil, nil, nil
+	w.size = 0
+}
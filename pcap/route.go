@@ -0,0 +1,109 @@
+package pcap
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+)
+
+// filterRoute WithFilterHandler 设置的过滤表达式及对应处理函数, programs 按链路类型缓存编译结果
+type filterRoute struct {
+	expr     string
+	fn       core.DataHandler
+	programs map[layers.LinkType]*libpcap.BPF
+}
+
+func newFilterRoute(expr string, fn core.DataHandler) *filterRoute {
+	return &filterRoute{
+		expr:     expr,
+		fn:       fn,
+		programs: make(map[layers.LinkType]*libpcap.BPF),
+	}
+}
+
+// filterRoutes 按设置顺序在进程内匹配数据包, 会话首个数据包匹配的首个过滤决定其处理函数
+type filterRoutes struct {
+	mu       sync.Mutex
+	routes   []*filterRoute
+	linkType layers.LinkType
+}
+
+// compile 以数据源的链路类型编译全部过滤表达式, 以尽早暴露错误
+func (r *filterRoutes) compile(linkType layers.LinkType) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.linkType = linkType
+
+	for _, route := range r.routes {
+		if _, err := route.program(linkType); err != nil {
+			return errors.Wrapf(err, "invalid bpf filter %q for link type %s", route.expr, linkType)
+		}
+	}
+
+	return nil
+}
+
+// union 各过滤表达式之并, 作为数据源的 BPF 过滤
+func (r *filterRoutes) union() string {
+	if len(r.routes) == 1 {
+		return r.routes[0].expr
+	}
+
+	exprs := make([]string, len(r.routes))
+	for idx, route := range r.routes {
+		exprs[idx] = "(" + route.expr + ")"
+	}
+
+	return strings.Join(exprs, " or ")
+}
+
+// match 返回匹配数据包的首个过滤序号加一, 均不匹配时返回 0
+func (r *filterRoutes) match(ci gopacket.CaptureInfo, data []byte) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	linkType := captureLinkType(ci, r.linkType)
+
+	for idx, route := range r.routes {
+		bpf, err := route.program(linkType)
+		if err != nil {
+			continue
+		}
+
+		if bpf.Matches(ci, data) {
+			return idx + 1
+		}
+	}
+
+	return 0
+}
+
+// handler 返回 match 结果对应的处理函数, 未匹配时返回 nil
+func (r *filterRoutes) handler(route int) core.DataHandler {
+	if r == nil || route <= 0 || route > len(r.routes) {
+		return nil
+	}
+
+	return r.routes[route-1].fn
+}
+
+func (route *filterRoute) program(linkType layers.LinkType) (*libpcap.BPF, error) {
+	if bpf, exist := route.programs[linkType]; exist {
+		return bpf, nil
+	}
+
+	bpf, err := libpcap.NewBPF(linkType, DefaultSnapLen, route.expr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	route.programs[linkType] = bpf
+
+	return bpf, nil
+}
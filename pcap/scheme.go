@@ -0,0 +1,87 @@
+package pcap
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SourceOpener 数据源打开函数, source 为数据源中 "://" 之后的部分, opts 为 CreateHandler 的参数
+type SourceOpener func(source string, opts ...HandlerOption) (PacketSource, error)
+
+var (
+	schemePattern = regexp.MustCompile(`^\w+$`)
+
+	schemesMu sync.RWMutex
+	schemes   = map[string]SourceOpener{
+		"pcap":     builtinScheme(openLive),
+		"afpacket": builtinScheme(openAFPacket),
+		"afxdp":    builtinScheme(openAFXDP),
+		"tcppcap":  builtinScheme(openRemote),
+		"file":     builtinScheme(openFiles),
+	}
+)
+
+// builtinScheme 以内置数据源的打开函数实现 SourceOpener
+func builtinScheme(open func(source string, options *handlerOptions) (PacketSource, error)) SourceOpener {
+	return func(source string, opts ...HandlerOption) (PacketSource, error) {
+		return open(source, newHandlerOptions(opts...))
+	}
+}
+
+// RegisterScheme 注册数据源协议, 此后 CreateHandler 以 open 打开 <scheme>://<source> 形式的数据源,
+// 可覆盖内置协议, open 为 nil 时注销该协议; scheme 仅可包含字母, 数字及下划线.
+// IP 地址形式的数据源仅对内置的 pcap://, afpacket:// 及 afxdp:// 解析为接口名
+func RegisterScheme(scheme string, open SourceOpener) error {
+	if !schemePattern.MatchString(scheme) {
+		return errors.WithStack(fmt.Errorf("%w: invalid scheme %q", ErrInvalidDataSource, scheme))
+	}
+
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+
+	if open == nil {
+		delete(schemes, scheme)
+	} else {
+		schemes[scheme] = open
+	}
+
+	return nil
+}
+
+// Schemes 返回已注册的数据源协议, 按名称排序
+func Schemes() []string {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+
+	names := make([]string, 0, len(schemes))
+	for scheme := range schemes {
+		names = append(names, scheme)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// splitScheme 拆分 <scheme>://<source> 形式的数据源, 任意符合 RegisterScheme 命名规则的协议均可拆分,
+// 协议是否已注册由 lookupScheme 判断
+func splitScheme(dataSrc string) (scheme, source string, ok bool) {
+	if scheme, source, ok = strings.Cut(dataSrc, "://"); !ok || !schemePattern.MatchString(scheme) {
+		return "", "", false
+	}
+
+	return scheme, source, true
+}
+
+func lookupScheme(scheme string) (SourceOpener, bool) {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+
+	open, exist := schemes[scheme]
+
+	return open, exist
+}
@@ -0,0 +1,325 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket/layers"
+)
+
+// SCTP 数据块类型
+const (
+	sctpChunkData             = 0
+	sctpChunkAbort            = 6
+	sctpChunkShutdownComplete = 14
+
+	sctpChunkHeaderLen = 4
+	sctpDataHeaderLen  = 16
+)
+
+// sctpChunk SCTP 数据块, gopacket 仅解码首个 DATA 块, 因此自行遍历全部数据块
+type sctpChunk struct {
+	typ   uint8
+	flags uint8
+	value []byte
+}
+
+// sctpChunks 解析 SCTP 公共头之后的数据块, 长度非法时停止解析
+func sctpChunks(data []byte) (chunks []sctpChunk) {
+	for len(data) >= sctpChunkHeaderLen {
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < sctpChunkHeaderLen || length > len(data) {
+			return
+		}
+
+		chunks = append(chunks, sctpChunk{
+			typ:   data[0],
+			flags: data[1],
+			value: data[sctpChunkHeaderLen:length],
+		})
+
+		// 数据块按4字节对齐, 末尾数据块可省略填充
+		if padded := (length + 3) &^ 3; padded < len(data) {
+			data = data[padded:]
+		} else {
+			return
+		}
+	}
+
+	return
+}
+
+// sctpTSNWindow 已接收 TSN 的跟踪窗口, 超出窗口的 TSN 到达时窗口前移, 窗口之前未到达的数据块视为丢失
+const sctpTSNWindow = 1 << 16
+
+type sctpFragment struct {
+	tsn   uint32
+	flags uint8
+	data  []byte
+}
+
+// sctpAssembler 按流重组 SCTP 分片的用户消息, 消息收齐后才交付;
+// 以累计 TSN 及其后已接收 TSN 的集合识别重传, 乱序到达的数据块仍可正常重组
+type sctpAssembler struct {
+	synced bool
+	// cumTSN 不大于该值的 TSN 均已接收或视为丢失, received 为大于 cumTSN 的已接收 TSN
+	cumTSN   uint32
+	received map[uint32]struct{}
+	// pending 各流未收齐消息的分片, 按 TSN 排序
+	pending map[uint16][]sctpFragment
+	// pendingBytes 未收齐消息的已缓存长度
+	pendingBytes int
+}
+
+// Pending 未收齐消息的已缓存长度
+func (a *sctpAssembler) Pending() int {
+	if a == nil {
+		return 0
+	}
+
+	return a.pendingBytes
+}
+
+// Push 处理 DATA 块, 收齐完整的用户消息时调用 deliver, 重复的数据块返回 false
+func (a *sctpAssembler) Push(flags uint8, value []byte, deliver func(data []byte)) bool {
+	if len(value) < sctpDataHeaderLen-sctpChunkHeaderLen {
+		return false
+	}
+
+	tsn := binary.BigEndian.Uint32(value[0:4])
+	stream := binary.BigEndian.Uint16(value[4:6])
+	payload := value[sctpDataHeaderLen-sctpChunkHeaderLen:]
+
+	if !a.receive(tsn) {
+		return false
+	}
+
+	if flags&0x3 == 0x3 {
+		deliver(payload)
+		return true
+	}
+
+	if a.pending == nil {
+		a.pending = make(map[uint16][]sctpFragment)
+	}
+
+	frags := a.pending[stream]
+	idx, _ := slices.BinarySearchFunc(frags, tsn, func(frag sctpFragment, target uint32) int {
+		return int(seqDiff(frag.tsn, target))
+	})
+
+	a.pending[stream] = slices.Insert(frags, idx, sctpFragment{tsn: tsn, flags: flags, data: slices.Clone(payload)})
+	a.pendingBytes += len(payload)
+
+	a.assemble(stream, deliver)
+
+	return true
+}
+
+// receive 记录已接收的 TSN, 已接收过时返回 false
+func (a *sctpAssembler) receive(tsn uint32) bool {
+	if !a.synced {
+		a.synced, a.cumTSN = true, tsn-1
+		a.received = make(map[uint32]struct{})
+	}
+
+	if a.seen(tsn) {
+		return false
+	}
+
+	if seqDiff(tsn, a.cumTSN) > sctpTSNWindow {
+		a.cumTSN = tsn - sctpTSNWindow
+
+		for received := range a.received {
+			if seqDiff(received, a.cumTSN) <= 0 {
+				delete(a.received, received)
+			}
+		}
+	}
+
+	a.received[tsn] = struct{}{}
+
+	for {
+		if _, exist := a.received[a.cumTSN+1]; !exist {
+			return true
+		}
+
+		a.cumTSN++
+		delete(a.received, a.cumTSN)
+	}
+}
+
+func (a *sctpAssembler) seen(tsn uint32) bool {
+	if seqDiff(tsn, a.cumTSN) <= 0 {
+		return true
+	}
+
+	_, exist := a.received[tsn]
+
+	return exist
+}
+
+// assemble 交付流中已收齐的消息, 消息自 B 分片至 E 分片之间的 TSN 均已接收,
+// 其中不属于该流的 TSN 为其他流的数据块
+func (a *sctpAssembler) assemble(stream uint16, deliver func(data []byte)) {
+	frags := a.pending[stream]
+
+	for begin := 0; begin < len(frags); {
+		end := a.messageEnd(frags, begin)
+		if end < 0 {
+			begin++
+			continue
+		}
+
+		var message []byte
+		for _, frag := range frags[begin : end+1] {
+			message = append(message, frag.data...)
+			a.pendingBytes -= len(frag.data)
+		}
+
+		frags = slices.Delete(frags, begin, end+1)
+		a.pending[stream] = frags
+
+		deliver(message)
+	}
+
+	if len(frags) <= 0 {
+		delete(a.pending, stream)
+	}
+}
+
+// messageEnd 返回自 begin 开始的完整消息的 E 分片序号, begin 不是 B 分片或消息未收齐时返回 -1
+func (a *sctpAssembler) messageEnd(frags []sctpFragment, begin int) int {
+	if frags[begin].flags&0x2 == 0 {
+		return -1
+	}
+
+	for end := begin; end < len(frags); end++ {
+		if end > begin && (frags[end].flags&0x2 != 0 || !a.contiguous(frags[end-1].tsn, frags[end].tsn)) {
+			return -1
+		}
+
+		if frags[end].flags&0x1 != 0 {
+			return end
+		}
+	}
+
+	return -1
+}
+
+// contiguous from 与 to 之间的 TSN 均已接收
+func (a *sctpAssembler) contiguous(from, to uint32) bool {
+	for tsn := from + 1; tsn != to; tsn++ {
+		if !a.seen(tsn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Trim 未收齐消息的缓存超出 limit 时丢弃 TSN 最小的分片, 返回丢弃的数据长度, limit <= 0 时不限制
+func (a *sctpAssembler) Trim(limit int) (dropped int) {
+	if a == nil || limit <= 0 {
+		return 0
+	}
+
+	for a.pendingBytes > limit {
+		var oldest uint16
+
+		first := true
+		for stream, frags := range a.pending {
+			if first || seqDiff(frags[0].tsn, a.pending[oldest][0].tsn) < 0 {
+				oldest, first = stream, false
+			}
+		}
+
+		frags := a.pending[oldest]
+		dropped += len(frags[0].data)
+		a.pendingBytes -= len(frags[0].data)
+
+		if frags = frags[1:]; len(frags) > 0 {
+			a.pending[oldest] = frags
+		} else {
+			delete(a.pending, oldest)
+		}
+	}
+
+	return dropped
+}
+
+// handleSCTP 重组 SCTP DATA 块中的用户消息, 不同流的消息以完整消息为单位合并至会话缓存
+func (c *capturer) handleSCTP(ts time.Time, srcIP, dstIP net.IP, vlans []uint16, sctp *layers.SCTP) error {
+	if sctp == nil {
+		return nil
+	}
+
+	session := &core.Session{
+		Proto:   core.SCTP,
+		SrcIP:   srcIP,
+		SrcPort: int(sctp.SrcPort),
+		DstIP:   dstIP,
+		DstPort: int(sctp.DstPort),
+		VLANs:   vlans,
+	}
+
+	dropped := !c.matchDirection(core.DirectionUnknown) || !c.sampleFlow(session)
+
+	var (
+		f        *flow
+		buffer   []byte
+		overflow bool
+	)
+
+	for _, chunk := range sctpChunks(sctp.Payload) {
+		switch chunk.typ {
+		case sctpChunkData:
+			if dropped {
+				continue
+			}
+
+			if f == nil {
+				f = c.flows.Get(session, time.Now())
+			}
+
+			if f.sctp == nil {
+				f.sctp = &sctpAssembler{}
+			}
+
+			if !f.sctp.Push(chunk.flags, chunk.value, func(data []byte) {
+				if !overflow {
+					buffer, overflow = c.merge(f, data)
+				}
+			}) {
+				c.opts.logger.Debug(
+					"sctp data chunk skipped:",
+					slog.String("session", f.key),
+				)
+			}
+
+			c.skipped(f, f.sctp.Trim(c.opts.reassembly.pending))
+
+			if overflow || c.exceeded(f, 0) {
+				return nil
+			}
+		case sctpChunkAbort:
+			c.flows.Delete(session, CloseRST)
+			c.flows.Delete(session.Reverse(), CloseRST)
+			return nil
+		case sctpChunkShutdownComplete:
+			c.flows.Delete(session, CloseFIN)
+			c.flows.Delete(session.Reverse(), CloseFIN)
+			return nil
+		}
+	}
+
+	if len(buffer) <= 0 {
+		return nil
+	}
+
+	return c.deliver(f, ts, buffer)
+}
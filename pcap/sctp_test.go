@@ -0,0 +1,147 @@
+package pcap
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// sctpData 构造 DATA 块, flags 低两位为 B/E 分片标志
+func sctpData(tsn uint32, stream uint16, flags uint8, payload string) []byte {
+	length := sctpDataHeaderLen + len(payload)
+
+	chunk := make([]byte, (length+3)&^3)
+	chunk[0] = sctpChunkData
+	chunk[1] = flags
+	binary.BigEndian.PutUint16(chunk[2:4], uint16(length))
+	binary.BigEndian.PutUint32(chunk[4:8], tsn)
+	binary.BigEndian.PutUint16(chunk[8:10], stream)
+	binary.BigEndian.PutUint32(chunk[12:16], uint32(layers.SCTPPayloadM3UA))
+	copy(chunk[sctpDataHeaderLen:], payload)
+
+	return chunk
+}
+
+func buildSCTP(t *testing.T, sport, dport int, chunks ...[]byte) gopacket.Packet {
+	t.Helper()
+
+	ip, _, _ := networkLayer(net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2"), layers.IPProtocolSCTP)
+
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint16(data[0:2], uint16(sport))
+	binary.BigEndian.PutUint16(data[2:4], uint16(dport))
+
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+
+	return buildPacket(t, layers.LinkTypeRaw, time.Now(), ip, gopacket.Payload(data))
+}
+
+func TestCaptureSCTP(t *testing.T) {
+	const (
+		begin    = 0x2
+		end      = 0x1
+		complete = begin | end
+	)
+
+	pkts := feedPackets(
+		// 单个数据包携带多个完整消息
+		buildSCTP(t, 2905, 2905, sctpData(1, 0, complete, "m3ua-1"), sctpData(2, 1, complete, "m3ua-2")),
+		// 两个流的分片交错到达
+		buildSCTP(t, 2905, 2905, sctpData(3, 0, begin, "frag-"), sctpData(4, 1, begin, "other-")),
+		buildSCTP(t, 2905, 2905, sctpData(5, 1, end, "stream")),
+		// 重传的数据块
+		buildSCTP(t, 2905, 2905, sctpData(5, 1, end, "stream")),
+		buildSCTP(t, 2905, 2905, sctpData(6, 0, end, "message")),
+		buildSCTP(t, 2905, 2905, []byte{sctpChunkAbort, 0, 0, 4}),
+	)
+
+	var (
+		result []string
+		addrs  []string
+		closed []CloseReason
+	)
+
+	if err := capture(context.TODO(), pkts, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		addrs = append(addrs, session.SrcAddr().Network()+"://"+session.SrcAddr().String())
+		return len(data), nil
+	}, newCaptureOptions(WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+		closed = append(closed, reason)
+	}))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"m3ua-1m3ua-2", "other-stream", "frag-message"}) {
+		t.Fatalf("sctp delivery mismatch: %q", result)
+	}
+
+	if addrs[0] != "sctp://192.168.1.1:2905" {
+		t.Fatalf("sctp address mismatch: %s", addrs[0])
+	}
+
+	if !slices.Equal(closed, []CloseReason{CloseRST}) {
+		t.Fatalf("sctp abort not closing session: %v", closed)
+	}
+}
+
+func TestCaptureSCTPReorder(t *testing.T) {
+	const (
+		begin    = 0x2
+		end      = 0x1
+		complete = begin | end
+	)
+
+	run := func(opts *captureOptions, chunks ...[]byte) (result []string) {
+		pkts := make([]gopacket.Packet, 0, len(chunks))
+		for _, chunk := range chunks {
+			pkts = append(pkts, buildSCTP(t, 2905, 2905, chunk))
+		}
+
+		if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	// 乱序到达的完整消息及分片均可重组, 重复的分片被丢弃
+	if result := run(
+		newCaptureOptions(),
+		sctpData(10, 0, complete, "a"),
+		sctpData(12, 0, complete, "c"),
+		sctpData(11, 0, complete, "b"),
+		sctpData(13, 1, begin, "x-"),
+		sctpData(15, 1, end, "z"),
+		sctpData(14, 1, 0, "y-"),
+		sctpData(14, 1, 0, "y-"),
+		sctpData(11, 0, complete, "b"),
+	); !slices.Equal(result, []string{"a", "c", "b", "x-y-z"}) {
+		t.Fatalf("sctp reorder mismatch: %q", result)
+	}
+
+	var lost uint64
+
+	// 未收齐消息的缓存超出限制时丢弃最早的分片
+	if result := run(
+		newCaptureOptions(WithReassemblyLimit(4, 0), WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			lost += flow.Counters.LostBytes
+		})),
+		sctpData(20, 0, begin, "12345"),
+		sctpData(21, 0, end, "6"),
+		sctpData(22, 0, complete, "ok"),
+	); !slices.Equal(result, []string{"ok"}) || lost != 6 {
+		t.Fatalf("sctp pending limit mismatch: %q lost %d", result, lost)
+	}
+}
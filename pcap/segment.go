@@ -0,0 +1,51 @@
+package pcap
+
+import (
+	"context"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+)
+
+// Segment 重组后的传输层数据
+type Segment struct {
+	Session   *core.Session
+	Timestamp time.Time
+	// 本次新增的按序数据, 为独立副本, 可安全持有
+	Data []byte
+}
+
+// Flows 以通道形式输出重组后的数据, 消费者未及时读取时将阻塞抓包(不丢弃数据),
+// ctx 取消或数据源读取完毕时关闭数据通道, 抓包出错时错误通过错误通道返回
+func Flows(ctx context.Context, handler PacketSource, filter string, opts ...CaptureOption) (<-chan Segment, <-chan error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	segments := make(chan Segment)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(segments)
+
+		if err := StartCapture(ctx, handler, filter, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			select {
+			case <-ctx.Done():
+				return 0, io.EOF
+			case segments <- Segment{
+				Session:   session,
+				Timestamp: ts,
+				Data:      slices.Clone(data),
+			}:
+				return len(data), nil
+			}
+		}, opts...); err != nil {
+			errs <- err
+		}
+	}()
+
+	return segments, errs
+}
@@ -0,0 +1,59 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestFlows(t *testing.T) {
+	var buf bytes.Buffer
+
+	writePcap(t, &buf, "hello", "world")
+
+	handler, err := CreateHandlerFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments, errs := Flows(context.TODO(), handler, "")
+
+	var result []string
+
+	for seg := range segments {
+		result = append(result, string(seg.Data))
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 2 || result[0] != "hello" || result[1] != "world" {
+		t.Fatalf("flow segments mismatch: %v", result)
+	}
+}
+
+func TestFlowsCancel(t *testing.T) {
+	var buf bytes.Buffer
+
+	writePcap(t, &buf, "hello", "world")
+
+	handler, err := CreateHandlerFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	segments, errs := Flows(ctx, handler, "")
+
+	<-segments
+	cancel()
+
+	for range segments {
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,449 @@
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+
+	origin_errors "errors"
+)
+
+// PacketSource 抓包数据源, *libpcap.Handle 及 pcapgo 的读取器均满足该接口
+type PacketSource interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+type bpfSetter interface {
+	SetBPFFilter(expr string) error
+}
+
+var (
+	ngMagic   = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// openFile 打开离线抓包文件, pcapng 格式将使用 pcapgo 读取以支持多接口文件,
+// gzip 压缩的文件将自动解压读取
+func openFile(path string) (PacketSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	reader := bufio.NewReader(file)
+
+	switch {
+	case hasMagic(reader, gzipMagic):
+		unzip, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, errors.WithStack(err)
+		}
+
+		src, err := openStream(unzip, multiCloser{unzip, file})
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return src, nil
+	case hasMagic(reader, ngMagic):
+		src, err := newNgSource(reader, file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return src, nil
+	default:
+		file.Close()
+
+		handle, err := libpcap.OpenOffline(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return handle, nil
+	}
+}
+
+// CreateHandlerFromReader 从内存数据流等任意 io.Reader 创建离线数据源,
+// 自动识别 pcap 及 pcapng 格式
+func CreateHandlerFromReader(r io.Reader) (PacketSource, error) {
+	return openStream(r, io.NopCloser(r))
+}
+
+func openStream(r io.Reader, closer io.Closer) (PacketSource, error) {
+	reader := bufio.NewReader(r)
+
+	if hasMagic(reader, ngMagic) {
+		return newNgSource(reader, closer)
+	}
+
+	src, err := pcapgo.NewReader(reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &streamSource{Reader: src, closer: newOnceCloser(closer)}, nil
+}
+
+func hasMagic(reader *bufio.Reader, magic []byte) bool {
+	head, err := reader.Peek(len(magic))
+
+	return err == nil && bytes.Equal(head, magic)
+}
+
+// onceCloser 保证底层数据流仅关闭一次, 重复关闭返回首次关闭的结果
+type onceCloser struct {
+	once   sync.Once
+	closer io.Closer
+	err    error
+}
+
+func newOnceCloser(closer io.Closer) *onceCloser {
+	return &onceCloser{closer: closer}
+}
+
+func (c *onceCloser) Close() error {
+	c.once.Do(func() {
+		c.err = c.closer.Close()
+	})
+
+	return c.err
+}
+
+// checkSource 检查数据源是否可用, nil 或已关闭的 libpcap 句柄会在 gopacket 内部直接崩溃
+func checkSource(src PacketSource) error {
+	if src == nil {
+		return errors.New("nil packet source")
+	}
+
+	switch v := reflect.ValueOf(src); v.Kind() {
+	case reflect.Pointer, reflect.Chan, reflect.Map, reflect.Func, reflect.Slice:
+		if v.IsNil() {
+			return errors.Errorf("nil packet source: %T", src)
+		}
+	}
+
+	if handle, ok := src.(*libpcap.Handle); ok && handleClosed(handle) {
+		return errors.New("packet source already closed")
+	}
+
+	return nil
+}
+
+// handleClosed 判断 libpcap 句柄是否已关闭, gopacket 未导出句柄状态, 关闭后 cptr 置空,
+// 字段不存在或类型变化时视为未关闭, 交由读取时报错
+func handleClosed(handle *libpcap.Handle) bool {
+	cptr := reflect.ValueOf(handle).Elem().FieldByName("cptr")
+	if !cptr.IsValid() {
+		return false
+	}
+
+	switch cptr.Kind() {
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Uintptr:
+		return cptr.IsZero()
+	default:
+		return false
+	}
+}
+
+// closeSource 关闭数据源, 数据源不支持关闭时忽略
+func closeSource(src PacketSource) error {
+	switch closer := src.(type) {
+	case io.Closer:
+		return closer.Close()
+	case interface{ Close() }:
+		closer.Close()
+	}
+
+	return nil
+}
+
+type multiCloser []io.Closer
+
+func (closers multiCloser) Close() error {
+	var errs []error
+
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return origin_errors.Join(errs...)
+}
+
+func newNgSource(r io.Reader, closer io.Closer) (*ngSource, error) {
+	ng, err := pcapgo.NewNgReader(r, pcapgo.NgReaderOptions{
+		WantMixedLinkType:  true,
+		SkipUnknownVersion: true,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &ngSource{NgReader: ng, closer: newOnceCloser(closer)}, nil
+}
+
+// streamSource 基于数据流的 pcap 数据源
+type streamSource struct {
+	*pcapgo.Reader
+	closer io.Closer
+}
+
+// SnapLen 返回文件头中的抓取长度
+func (src *streamSource) SnapLen() int {
+	return int(src.Snaplen())
+}
+
+func (src *streamSource) Close() error {
+	return src.closer.Close()
+}
+
+// ngSource pcapng 数据源, 文件中的多个接口可具有不同的链路类型
+type ngSource struct {
+	*pcapgo.NgReader
+	closer  io.Closer
+	current layers.LinkType
+}
+
+func (src *ngSource) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	if data, ci, err = src.NgReader.ReadPacketData(); err == nil {
+		src.current = captureLinkType(ci, src.current)
+	}
+
+	return
+}
+
+// LinkType 返回首个接口的链路类型
+func (src *ngSource) LinkType() layers.LinkType {
+	if intf, err := src.Interface(0); err == nil {
+		return intf.LinkType
+	}
+
+	return layers.LinkTypeEthernet
+}
+
+// Decode 按最近读取数据包所属接口的链路类型解码,
+// gopacket.PacketSource 读取与解码顺序执行, 因此可安全使用
+func (src *ngSource) Decode(data []byte, p gopacket.PacketBuilder) error {
+	return linkDecoder(src.current).Decode(data, p)
+}
+
+func (src *ngSource) Close() error {
+	return src.closer.Close()
+}
+
+// captureLinkType 多链路类型数据源在 AncillaryData 中携带数据包的链路类型
+func captureLinkType(ci gopacket.CaptureInfo, fallback layers.LinkType) layers.LinkType {
+	if len(ci.AncillaryData) > 0 {
+		if linkType, ok := ci.AncillaryData[0].(layers.LinkType); ok {
+			return linkType
+		}
+	}
+
+	return fallback
+}
+
+// filterSource 进程内 BPF 过滤, 用于不支持内核过滤的数据源, 过滤表达式可在读取过程中更新
+type filterSource struct {
+	PacketSource
+	mu       sync.Mutex
+	expr     string
+	programs map[layers.LinkType]*libpcap.BPF
+	// vm 预编译过滤程序, 设置后优先于过滤表达式
+	vm *bpf.VM
+	// accepted 及 filtered 为通过及丢弃的数据包数量
+	accepted atomic.Uint64
+	filtered atomic.Uint64
+}
+
+func newFilterSource(src PacketSource) *filterSource {
+	return &filterSource{
+		PacketSource: src,
+		programs:     make(map[layers.LinkType]*libpcap.BPF),
+	}
+}
+
+// SetBPFFilter 更新过滤表达式, expr 为空时不过滤
+func (src *filterSource) SetBPFFilter(expr string) error {
+	programs := make(map[layers.LinkType]*libpcap.BPF)
+
+	// 预先编译以尽早暴露过滤表达式错误
+	if expr != "" {
+		linkType := src.LinkType()
+
+		bpf, err := libpcap.NewBPF(linkType, DefaultSnapLen, expr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		programs[linkType] = bpf
+	}
+
+	src.mu.Lock()
+	src.expr, src.programs, src.vm = expr, programs, nil
+	src.mu.Unlock()
+
+	return nil
+}
+
+// SetBPFInstructionFilter 以预编译的过滤程序替代过滤表达式, 程序在进程内执行, 对全部数据包生效,
+// insts 为空时不过滤
+func (src *filterSource) SetBPFInstructionFilter(insts []libpcap.BPFInstruction) error {
+	var vm *bpf.VM
+
+	if len(insts) > 0 {
+		var err error
+		if vm, err = newBPFVM(insts); err != nil {
+			return err
+		}
+	}
+
+	src.mu.Lock()
+	src.expr, src.programs, src.vm = "", make(map[layers.LinkType]*libpcap.BPF), vm
+	src.mu.Unlock()
+
+	return nil
+}
+
+// machine 返回预编译过滤程序, 未设置时返回 nil
+func (src *filterSource) machine() *bpf.VM {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	return src.vm
+}
+
+// program 返回对应链路层类型的过滤程序, 未设置过滤时返回 nil
+func (src *filterSource) program(linkType layers.LinkType) (*libpcap.BPF, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.expr == "" {
+		return nil, nil
+	}
+
+	if bpf, exist := src.programs[linkType]; exist {
+		return bpf, nil
+	}
+
+	bpf, err := libpcap.NewBPF(linkType, DefaultSnapLen, src.expr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	src.programs[linkType] = bpf
+
+	return bpf, nil
+}
+
+func (src *filterSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(src.PacketSource.ReadPacketData)
+}
+
+// ZeroCopyReadPacketData 零拷贝读取, 数据源不支持零拷贝时退化为普通读取
+func (src *filterSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(zeroCopyRead(src.PacketSource))
+}
+
+func (src *filterSource) read(next packetReader) (data []byte, ci gopacket.CaptureInfo, err error) {
+	for {
+		if data, ci, err = next(); err != nil {
+			return
+		}
+
+		if vm := src.machine(); vm != nil {
+			if n, err := vm.Run(data); err == nil && n > 0 {
+				src.accepted.Add(1)
+				return data, ci, nil
+			}
+
+			src.filtered.Add(1)
+			continue
+		}
+
+		bpf, err := src.program(captureLinkType(ci, src.LinkType()))
+		if err != nil {
+			return nil, ci, err
+		}
+
+		if bpf == nil || bpf.Matches(ci, data) {
+			src.accepted.Add(1)
+			return data, ci, nil
+		}
+
+		src.filtered.Add(1)
+	}
+}
+
+// FilterStats 返回进程内过滤的统计
+func (src *filterSource) FilterStats() (*FilterStats, error) {
+	return &FilterStats{
+		Accepted: src.accepted.Load(),
+		Filtered: src.filtered.Load(),
+	}, nil
+}
+
+// packetDecoder 返回数据源的解码器, 数据源自身实现解码时优先使用
+func packetDecoder(src PacketSource, logger *slog.Logger) gopacket.Decoder {
+	if decoder, ok := src.(gopacket.Decoder); ok {
+		return decoder
+	}
+
+	linkType := src.LinkType()
+
+	if !knownLinkType(linkType) {
+		logger.Warn(
+			"unsupported link type, fallback to ip layer decoding:",
+			slog.Int("link_type", int(linkType)),
+		)
+	}
+
+	return linkDecoder(linkType)
+}
+
+func knownLinkType(linkType layers.LinkType) bool {
+	_, unknown := layers.LinkTypeMetadata[linkType].DecodeWith.(error)
+
+	return !unknown
+}
+
+// linkDecoder 返回链路类型对应的解码器, gopacket 不支持的链路类型按IP报文解码
+func linkDecoder(linkType layers.LinkType) gopacket.Decoder {
+	if !knownLinkType(linkType) {
+		return ipDecoder
+	}
+
+	return linkType
+}
+
+// ipDecoder 按IP版本号解码无链路层的数据包
+var ipDecoder = gopacket.DecodeFunc(func(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) > 0 {
+		switch data[0] >> 4 {
+		case 4:
+			return layers.LayerTypeIPv4.Decode(data, p)
+		case 6:
+			return layers.LayerTypeIPv6.Decode(data, p)
+		}
+	}
+
+	return gopacket.LayerTypePayload.Decode(data, p)
+})
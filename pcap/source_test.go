@@ -0,0 +1,1281 @@
+package pcap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/net/bpf"
+)
+
+func writeNgFile(t *testing.T, pkts ...gopacket.Packet) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	intf := pcapgo.DefaultNgInterface
+	intf.LinkType = layers.LinkTypeEthernet
+
+	writer, err := pcapgo.NewNgWriterInterface(file, intf, pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intf.Name = "intf1"
+	intf.LinkType = layers.LinkTypeRaw
+	rawIntf, err := writer.AddInterface(intf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pkg := range pkts {
+		ci := pkg.Metadata().CaptureInfo
+
+		if pkg.LinkLayer() == nil {
+			ci.InterfaceIndex = rawIntf
+		}
+
+		if err := writer.WritePacket(ci, pkg.Data()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func buildRawUDP(t *testing.T, src, dst string, sport, dport int, payload []byte) gopacket.Packet {
+	t.Helper()
+
+	ip, network, _ := networkLayer(net.ParseIP(src), net.ParseIP(dst), layers.IPProtocolUDP)
+
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(sport),
+		DstPort: layers.UDPPort(dport),
+	}
+	udp.SetNetworkLayerForChecksum(network)
+
+	return buildPacket(t, layers.LinkTypeRaw, time.Now(), ip, udp, gopacket.Payload(payload))
+}
+
+func TestCaptureNgMultiInterface(t *testing.T) {
+	path := writeNgFile(
+		t,
+		buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte("ethernet"), ts: time.Now(),
+		}),
+		buildRawUDP(t, "10.0.0.1", "10.0.0.2", 1000, 2000, []byte("raw")),
+	)
+
+	handler, err := CreateHandler("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := handler.(*ngSource); !ok {
+		t.Fatalf("pcapng file not opened by ng reader: %T", handler)
+	}
+
+	var result []string
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return len(data), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 2 || result[0] != "ethernet" || result[1] != "raw" {
+		t.Fatalf("multi interface delivery mismatch: %v", result)
+	}
+}
+
+func writePcap(t *testing.T, w io.Writer, payloads ...string) {
+	t.Helper()
+
+	writer := pcapgo.NewWriter(w)
+	if err := writer.WriteFileHeader(DefaultSnapLen, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range payloads {
+		pkg := buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte(payload), ts: time.Now(),
+		})
+
+		if err := writer.WritePacket(pkg.Metadata().CaptureInfo, pkg.Data()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func captureAll(t *testing.T, handler PacketSource) []string {
+	t.Helper()
+
+	var result []string
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return len(data), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestCaptureFromReader(t *testing.T) {
+	var buf bytes.Buffer
+
+	writePcap(t, &buf, "hello", "world")
+
+	handler, err := CreateHandlerFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := captureAll(t, handler); slices.Compare(result, []string{"hello", "world"}) != 0 {
+		t.Fatalf("reader delivery mismatch: %v", result)
+	}
+}
+
+func TestCaptureGzipFile(t *testing.T) {
+	payloads := []string{"compressed", "capture", "file"}
+
+	path := filepath.Join(t.TempDir(), "capture.pcap.gz")
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zip := gzip.NewWriter(file)
+	writePcap(t, zip, payloads...)
+	zip.Close()
+	file.Close()
+
+	var info SourceInfo
+
+	handler, err := CreateHandler("file://"+path, WithSourceInfo(&info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(io.Closer).Close()
+
+	if info.Device != "" || info.LinkType != layers.LinkTypeEthernet || info.SnapLen != DefaultSnapLen {
+		t.Errorf("source info mismatch: %+v", info)
+	}
+
+	if result := captureAll(t, handler); slices.Compare(result, payloads) != 0 {
+		t.Fatalf("gzip delivery mismatch: %v", result)
+	}
+}
+
+func writeFrames(t *testing.T, w io.Writer, linkType layers.LinkType, frames ...[]byte) {
+	t.Helper()
+
+	writer := pcapgo.NewWriter(w)
+	if err := writer.WriteFileHeader(DefaultSnapLen, linkType); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, frame := range frames {
+		if err := writer.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     time.Now(),
+			CaptureLength: len(frame),
+			Length:        len(frame),
+		}, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sllHeader linux cooked capture header for an incoming packet
+func sllHeader(proto layers.EthernetType) []byte {
+	hdr := make([]byte, 16)
+	binary.BigEndian.PutUint16(hdr[0:], uint16(layers.LinuxSLLPacketTypeHost))
+	binary.BigEndian.PutUint16(hdr[2:], 1) // ARPHRD_ETHER
+	binary.BigEndian.PutUint16(hdr[4:], 6)
+	copy(hdr[6:], []byte{0, 1, 2, 3, 4, 5})
+	binary.BigEndian.PutUint16(hdr[14:], uint16(proto))
+
+	return hdr
+}
+
+func TestCaptureLinuxSLL(t *testing.T) {
+	var buf bytes.Buffer
+
+	v4 := buildRawUDP(t, "10.0.0.1", "10.0.0.2", 1000, 2000, []byte("sll-v4")).Data()
+	v6 := buildRawUDP(t, "fd00::1", "fd00::2", 1000, 2000, []byte("sll-v6")).Data()
+
+	writeFrames(
+		t, &buf, layers.LinkTypeLinuxSLL,
+		append(sllHeader(layers.EthernetTypeIPv4), v4...),
+		append(sllHeader(layers.EthernetTypeIPv6), v6...),
+	)
+
+	handler, err := CreateHandlerFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := captureAll(t, handler); slices.Compare(result, []string{"sll-v4", "sll-v6"}) != 0 {
+		t.Fatalf("linux sll delivery mismatch: %v", result)
+	}
+}
+
+// loopbackHeader BSD loopback 地址族头部, DLT_NULL 为主机字节序, DLT_LOOP 为网络字节序
+func loopbackHeader(linkType layers.LinkType, family layers.ProtocolFamily) []byte {
+	hdr := make([]byte, 4)
+
+	if linkType == layers.LinkTypeLoop {
+		binary.BigEndian.PutUint32(hdr, uint32(family))
+	} else {
+		binary.LittleEndian.PutUint32(hdr, uint32(family))
+	}
+
+	return hdr
+}
+
+func TestCaptureLoopback(t *testing.T) {
+	v4 := buildRawUDP(t, "127.0.0.1", "127.0.0.1", 1000, 2000, []byte("lo-v4")).Data()
+	v6 := buildRawUDP(t, "::1", "::1", 1000, 2000, []byte("lo-v6")).Data()
+
+	for _, linkType := range []layers.LinkType{layers.LinkTypeNull, layers.LinkTypeLoop} {
+		for _, zeroCopy := range []bool{false, true} {
+			var buf bytes.Buffer
+
+			// macOS 及 FreeBSD 的 lo0 以不同的地址族值标识 IPv6
+			writeFrames(
+				t, &buf, linkType,
+				append(loopbackHeader(linkType, layers.ProtocolFamilyIPv4), v4...),
+				append(loopbackHeader(linkType, layers.ProtocolFamilyIPv6Darwin), v6...),
+				append(loopbackHeader(linkType, layers.ProtocolFamilyIPv6FreeBSD), v6...),
+			)
+
+			handler, err := CreateHandlerFromReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var result []string
+
+			if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+				result = append(result, string(data))
+				return len(data), nil
+			}, WithZeroCopy(zeroCopy)); err != nil {
+				t.Fatal(err)
+			}
+
+			if slices.Compare(result, []string{"lo-v4", "lo-v6", "lo-v6"}) != 0 {
+				t.Fatalf("link type[%d] zero copy[%t] delivery mismatch: %v", linkType, zeroCopy, result)
+			}
+		}
+	}
+}
+
+func TestCaptureFlushOnEOF(t *testing.T) {
+	var packets []gopacket.Packet
+
+	// 抓包文件在连接中途截断, 未收到 FIN
+	start := time.Date(2023, 8, 12, 9, 30, 0, 0, time.UTC)
+	for idx, seg := range []testSegment{
+		{seq: 100, syn: true},
+		{seq: 101, ack: true, payload: []byte("line1\nli")},
+		{seq: 109, ack: true, payload: []byte("ne2\npart")},
+	} {
+		seg.src, seg.dst, seg.sport, seg.dport = "192.168.1.1", "192.168.1.2", 1000, 2000
+		seg.ts = start.Add(time.Duration(idx) * time.Second)
+		packets = append(packets, buildSegment(t, seg))
+	}
+
+	for _, opts := range [][]CaptureOption{
+		{WithZeroCopy(false)}, {WithZeroCopy(true)}, {WithWorkers(2)},
+	} {
+		var buf bytes.Buffer
+
+		writer := pcapgo.NewWriter(&buf)
+		if err := writer.WriteFileHeader(DefaultSnapLen, layers.LinkTypeEthernet); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, pkg := range packets {
+			if err := writer.WritePacket(pkg.Metadata().CaptureInfo, pkg.Data()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		handler, err := CreateHandlerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			mu               sync.Mutex
+			lines, remaining []string
+		)
+
+		if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			lines = append(lines, string(data))
+			return len(data), nil
+		}, append(
+			opts,
+			WithDelimiter([]byte("\n")),
+			WithFlushOnCancel(func(session *core.Session, ts time.Time, data []byte) (int, error) {
+				t.Errorf("cancel flush called on eof: %q", data)
+				return len(data), nil
+			}),
+			WithFlushOnEOF(func(session *core.Session, ts time.Time, data []byte) (int, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				// 剩余数据以最后一个数据包的抓取时间交付
+				if !ts.Equal(packets[len(packets)-1].Metadata().Timestamp) {
+					t.Errorf("unexpected flush timestamp: %v", ts)
+				}
+
+				remaining = append(remaining, string(data))
+				return len(data), nil
+			}),
+		)...); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(lines, []string{"line1\n", "line2\n"}) || !slices.Equal(remaining, []string{"part"}) {
+			t.Fatalf("eof flush mismatch: %q, remaining: %q", lines, remaining)
+		}
+	}
+}
+
+func TestCaptureRawIP(t *testing.T) {
+	for _, linkType := range []layers.LinkType{layers.LinkTypeRaw, layers.LinkTypeIPv4} {
+		var buf bytes.Buffer
+
+		writeFrames(
+			t, &buf, linkType,
+			buildRawUDP(t, "10.0.0.1", "10.0.0.2", 1000, 2000, []byte("raw-v4")).Data(),
+			buildRawUDP(t, "fd00::1", "fd00::2", 1000, 2000, []byte("raw-v6")).Data(),
+		)
+
+		handler, err := CreateHandlerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result := captureAll(t, handler); slices.Compare(result, []string{"raw-v4", "raw-v6"}) != 0 {
+			t.Fatalf("link type[%d] delivery mismatch: %v", linkType, result)
+		}
+	}
+}
+
+// vendorHeader 抓包设备在以太网帧前附加的 4 字节私有头部
+type vendorHeader struct {
+	layers.BaseLayer
+}
+
+var layerTypeVendor = gopacket.RegisterLayerType(12345, gopacket.LayerTypeMetadata{
+	Name: "Vendor",
+	Decoder: gopacket.DecodeFunc(func(data []byte, p gopacket.PacketBuilder) error {
+		if len(data) < 4 {
+			return errors.New("vendor header too short")
+		}
+
+		p.AddLayer(&vendorHeader{layers.BaseLayer{Contents: data[:4], Payload: data[4:]}})
+
+		return p.NextDecoder(layers.LayerTypeEthernet)
+	}),
+})
+
+func (*vendorHeader) LayerType() gopacket.LayerType { return layerTypeVendor }
+
+func TestCaptureCustomDecoder(t *testing.T) {
+	frame := buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("vendor"),
+	}).Data()
+
+	for _, zeroCopy := range []bool{false, true} {
+		var buf bytes.Buffer
+
+		// DLT_USER0
+		writeFrames(t, &buf, layers.LinkType(147), append([]byte{0xde, 0xad, 0xbe, 0xef}, frame...))
+
+		handler, err := CreateHandlerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var result []string
+
+		if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, WithDecoder(layerTypeVendor), WithZeroCopy(zeroCopy), WithLogger(nil)); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(result, []string{"vendor"}) {
+			t.Fatalf("custom decoder delivery mismatch with zero copy %v: %v", zeroCopy, result)
+		}
+	}
+}
+
+func TestCaptureCloseOnExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap.gz")
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zip := gzip.NewWriter(file)
+	writePcap(t, zip, "close")
+	zip.Close()
+	file.Close()
+
+	handler, err := CreateHandler("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, WithCloseOnExit(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := handler.ReadPacketData(); err == nil {
+		t.Fatal("packet source not closed on exit")
+	}
+
+	// double close should be safe
+	if err := handler.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type statsSource struct {
+	PacketSource
+	stats Stats
+}
+
+func (src *statsSource) Stats() (*Stats, error) {
+	stats := src.stats
+	return &stats, nil
+}
+
+func TestCaptureStats(t *testing.T) {
+	var buf bytes.Buffer
+	writePcap(t, &buf, "stats")
+
+	handler, err := CreateHandlerFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := &statsSource{
+		PacketSource: handler,
+		stats:        Stats{PacketsReceived: 10, PacketsDropped: 2, PacketsIfDropped: 1},
+	}
+
+	var reported []Stats
+
+	if err := StartCapture(context.TODO(), src, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, WithStats(time.Hour, func(stats *Stats) {
+		reported = append(reported, *stats)
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reported) != 1 || reported[0] != src.stats {
+		t.Fatalf("final stats not reported: %+v", reported)
+	}
+}
+
+func TestCapturePcapWriter(t *testing.T) {
+	var src bytes.Buffer
+	writePcap(t, &src, "first", "second")
+
+	handler, err := CreateHandlerFromReader(&src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dump bytes.Buffer
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}, WithPcapWriter(&dump)); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := CreateHandlerFromReader(&dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := captureAll(t, replay); !slices.Equal(result, []string{"first", "second"}) {
+		t.Fatalf("tee packets mismatch: %v", result)
+	}
+}
+
+func TestCapturePcapRotation(t *testing.T) {
+	start := time.Now()
+	payloads := []string{"p1", "p2", "p3", "p4", "p5"}
+
+	packets := make([]gopacket.Packet, 0, len(payloads))
+	for idx, payload := range payloads {
+		packets = append(packets, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte(payload), ts: start.Add(time.Duration(idx) * time.Second),
+		}))
+	}
+
+	run := func(t *testing.T, opt CaptureOption) {
+		src := make(chanSource, len(packets))
+		for _, pkg := range packets {
+			src <- pkg
+		}
+		close(src)
+
+		if err := StartCapture(context.TODO(), src, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			return len(data), nil
+		}, opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replay := func(t *testing.T, name string) []string {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		handler, err := CreateHandlerFromReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return captureAll(t, handler)
+	}
+
+	// 每个文件可容纳两个数据包
+	size := int64(pcapFileHeader + 2*(pcapRecordHeader+len(packets[0].Data())))
+
+	for _, tc := range []struct {
+		name   string
+		opt    func(prefix string) CaptureOption
+		expect map[string][]string
+	}{
+		{
+			name: "size",
+			opt:  func(prefix string) CaptureOption { return WithPcapRotation(prefix, size, 0, 2) },
+			expect: map[string][]string{
+				"cap-0002.pcap": {"p3", "p4"},
+				"cap-0003.pcap": {"p5"},
+			},
+		},
+		{
+			name: "age",
+			opt:  func(prefix string) CaptureOption { return WithPcapRotation(prefix, 0, 2*time.Second, 0) },
+			expect: map[string][]string{
+				"cap-0001.pcap": {"p1", "p2"},
+				"cap-0002.pcap": {"p3", "p4"},
+				"cap-0003.pcap": {"p5"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			run(t, tc.opt(filepath.Join(dir, "cap")))
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != len(tc.expect) {
+				t.Fatalf("rotated files mismatch: %v", entries)
+			}
+
+			for name, expect := range tc.expect {
+				if result := replay(t, filepath.Join(dir, name)); !slices.Equal(result, expect) {
+					t.Fatalf("%s packets mismatch: %v", name, result)
+				}
+			}
+		})
+	}
+
+	// 重启后在已有文件之后继续编号, 已有文件计入 maxFiles
+	t.Run("restart", func(t *testing.T) {
+		dir := t.TempDir()
+		prefix := filepath.Join(dir, "cap")
+
+		if err := os.WriteFile(filepath.Join(dir, "cap-extra.pcap"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		run(t, WithPcapRotation(prefix, size, 0, 0))
+		run(t, WithPcapRotation(prefix, size, 0, 4))
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+
+		if !slices.Equal(names, []string{"cap-0003.pcap", "cap-0004.pcap", "cap-0005.pcap", "cap-0006.pcap", "cap-extra.pcap"}) {
+			t.Fatalf("rotated files after restart mismatch: %v", names)
+		}
+
+		if result := replay(t, filepath.Join(dir, "cap-0003.pcap")); !slices.Equal(result, []string{"p5"}) {
+			t.Fatalf("previous file overwritten: %v", result)
+		}
+	})
+}
+
+type chanSource chan gopacket.Packet
+
+func (src chanSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	pkg, ok := <-src
+	if !ok {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	return pkg.Data(), pkg.Metadata().CaptureInfo, nil
+}
+
+func (src chanSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func TestCaptureSetFilter(t *testing.T) {
+	src := make(chanSource)
+
+	c, err := NewCapture(src, "udp port 1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ports := make(chan int)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.Run(context.TODO(), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			ports <- session.DstPort
+			return len(data), nil
+		})
+	}()
+
+	send := func(port int) {
+		src <- buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 3000, dport: port,
+			udp: true, payload: []byte("data"),
+		})
+	}
+
+	send(2000)
+	send(1000)
+
+	if port := <-ports; port != 1000 {
+		t.Fatalf("unexpected port before filter update: %d", port)
+	}
+
+	if err := c.SetFilter("udp port 2000"); err != nil {
+		t.Fatal(err)
+	}
+
+	send(1000)
+	send(2000)
+
+	if port := <-ports; port != 2000 {
+		t.Fatalf("unexpected port after filter update: %d", port)
+	}
+
+	if err := c.SetFilter("invalid filter ((("); err == nil {
+		t.Fatal("invalid filter should fail")
+	}
+
+	close(src)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.FilterStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Kernel || stats.Accepted != 2 || stats.Filtered != 2 {
+		t.Fatalf("filter stats mismatch: %+v", stats)
+	}
+}
+
+// filterRecorder 记录 SetBPFFilter 调用的数据源
+type filterRecorder struct {
+	chanSource
+	exprs []string
+}
+
+func (src *filterRecorder) SetBPFFilter(expr string) error {
+	src.exprs = append(src.exprs, expr)
+	return nil
+}
+
+func TestCaptureEmptyFilter(t *testing.T) {
+	src := filterRecorder{chanSource: make(chanSource, 2)}
+
+	src.chanSource <- buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		seq: 101, ack: true, payload: []byte("tcp"),
+	})
+	src.chanSource <- buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("udp"),
+	})
+	close(src.chanSource)
+
+	if result := captureAll(t, &src); !slices.Equal(result, []string{"tcp", "udp"}) {
+		t.Fatalf("empty filter delivery mismatch: %v", result)
+	}
+
+	if len(src.exprs) > 0 {
+		t.Fatalf("empty filter should not be set: %q", src.exprs)
+	}
+}
+
+func TestCaptureFilterHandler(t *testing.T) {
+	var pkts []gopacket.Packet
+
+	for _, port := range []int{1000, 2000, 3000} {
+		pkts = append(pkts, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 5000, dport: port,
+			udp: true, payload: []byte(strconv.Itoa(port)),
+		}))
+	}
+
+	route := func(name string, result *[]string) CaptureOption {
+		return WithFilterHandler("udp port "+name, func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			*result = append(*result, name+":"+string(data))
+			return len(data), nil
+		})
+	}
+
+	src := filterRecorder{chanSource: make(chanSource, len(pkts))}
+	for _, pkg := range pkts {
+		src.chanSource <- pkg
+	}
+	close(src.chanSource)
+
+	var routed, others []string
+
+	if err := StartCapture(context.TODO(), &src, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		others = append(others, string(data))
+		return len(data), nil
+	}, route("2000", &routed), route("1000", &routed)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(src.exprs, []string{"(udp port 2000) or (udp port 1000)"}) {
+		t.Fatalf("union filter mismatch: %q", src.exprs)
+	}
+
+	// 数据源未按过滤之并过滤时, 未匹配的会话交由默认处理函数
+	if !slices.Equal(routed, []string{"1000:1000", "2000:2000"}) || !slices.Equal(others, []string{"3000"}) {
+		t.Fatalf("filter handler mismatch: %q, others: %q", routed, others)
+	}
+
+	for _, zeroCopy := range []bool{false, true} {
+		routed, others = nil, nil
+
+		if err := StartCapture(context.TODO(), CreateHandlerFromPackets(layers.LinkTypeEthernet, pkts...), "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			others = append(others, string(data))
+			return len(data), nil
+		}, route("2000", &routed), WithZeroCopy(zeroCopy)); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(routed, []string{"2000:2000"}) || len(others) > 0 {
+			t.Fatalf("in-process filter handler mismatch: %q, others: %q", routed, others)
+		}
+	}
+
+	if _, err := NewCapture(make(chanSource), "", WithFilterHandler("invalid (((", func(*core.Session, time.Time, []byte) (int, error) {
+		return 0, nil
+	})); err == nil {
+		t.Fatal("invalid filter handler expression should fail")
+	}
+}
+
+func TestCaptureStop(t *testing.T) {
+	src := make(chanSource)
+	defer close(src)
+
+	for _, zeroCopy := range []bool{false, true} {
+		// Stop 后读取协程仍阻塞于数据源, 各次抓包使用独立的数据源
+		src := make(chanSource)
+		defer close(src)
+
+		var source PacketSource = src
+		if zeroCopy {
+			source = zeroCopyChanSource{src}
+		}
+
+		c, err := NewCapture(source, "", WithZeroCopy(zeroCopy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Stats(); err == nil {
+			t.Error("stats of source without stats should fail")
+		}
+
+		received := make(chan string)
+		done := make(chan error, 1)
+
+		go func() {
+			done <- c.Run(context.TODO(), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+				received <- string(data)
+				return len(data), nil
+			})
+		}()
+
+		src <- buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte("before stop"),
+		})
+
+		if data := <-received; data != "before stop" {
+			t.Fatalf("unexpected data with zero copy %v: %q", zeroCopy, data)
+		}
+
+		// 数据源读取阻塞时 Stop 同样结束抓包
+		c.Stop()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("capture not stopped with zero copy %v", zeroCopy)
+		}
+	}
+
+	var result CaptureResult
+
+	// Stop 先于 Run 时 Run 立即结束
+	stopped, err := NewCapture(src, "", WithCaptureResult(&result))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped.Stop()
+
+	if err := stopped.Run(context.TODO(), nil); err != nil || result.Reason != StopCanceled {
+		t.Fatalf("stopped capture result mismatch: %v, %+v", err, result)
+	}
+}
+
+func TestCaptureInvalidFilter(t *testing.T) {
+	const expr = "tcp port ((("
+
+	err := StartCapture(context.TODO(), make(chanSource), expr, nil)
+	if err == nil {
+		t.Fatal("invalid filter should fail")
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, expr) || !strings.Contains(msg, layers.LinkTypeEthernet.String()) {
+		t.Fatalf("filter error without context: %s", msg)
+	}
+}
+
+// panicSource 模拟状态异常时在 gopacket 内部 panic 的数据源
+type panicSource struct{ chanSource }
+
+func (panicSource) LinkType() layers.LinkType {
+	panic("handle closed")
+}
+
+func TestCaptureInvalidSource(t *testing.T) {
+	// 关闭后的句柄与零值句柄状态相同
+	closed := &libpcap.Handle{}
+
+	for name, src := range map[string]PacketSource{
+		"nil":       nil,
+		"typed nil": (*libpcap.Handle)(nil),
+		"nil chan":  chanSource(nil),
+		"closed":    closed,
+		"panic":     panicSource{make(chanSource)},
+	} {
+		if err := StartCapture(context.TODO(), src, "", nil); err == nil {
+			t.Errorf("%s source should fail", name)
+		} else {
+			t.Logf("%s source: %v", name, err)
+		}
+	}
+}
+
+func TestCaptureFileGlob(t *testing.T) {
+	dir := t.TempDir()
+
+	segments := [][]testSegment{
+		{
+			{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 100, syn: true},
+			{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 106, ack: true, payload: []byte("second")},
+		},
+		{
+			{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 101, ack: true, payload: []byte("first")},
+		},
+	}
+
+	for idx, segs := range segments {
+		file, err := os.Create(filepath.Join(dir, fmt.Sprintf("capture-%d.pcap.gz", idx)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var frames [][]byte
+		for _, seg := range segs {
+			frames = append(frames, buildSegment(t, seg).Data())
+		}
+
+		zip := gzip.NewWriter(file)
+		writeFrames(t, zip, layers.LinkTypeEthernet, frames...)
+		zip.Close()
+		file.Close()
+	}
+
+	handler, err := CreateHandler("file://" + filepath.Join(dir, "capture-*.pcap.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(io.Closer).Close()
+
+	// 乱序数据跨越文件边界, 仍应合并为完整的会话数据
+	if result := captureAll(t, handler); slices.Compare(result, []string{"firstsecond"}) != 0 {
+		t.Fatalf("file chain delivery mismatch: %v", result)
+	}
+
+	// 链路类型不一致的文件使抓包以错误结束, 而非静默停止
+	for idx, linkType := range []layers.LinkType{layers.LinkTypeEthernet, layers.LinkTypeLinuxSLL, layers.LinkTypeEthernet} {
+		file, err := os.Create(filepath.Join(dir, fmt.Sprintf("mixed-%d.pcap.gz", idx)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		zip := gzip.NewWriter(file)
+		writeFrames(t, zip, linkType, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte(fmt.Sprintf("mixed-%d", idx)),
+		}).Data())
+		zip.Close()
+		file.Close()
+	}
+
+	for _, zeroCopy := range []bool{false, true} {
+		mixed, err := CreateHandler("file://" + filepath.Join(dir, "mixed-*.pcap.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var result []string
+
+		err = StartCapture(context.TODO(), mixed, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, WithZeroCopy(zeroCopy))
+
+		if !errors.Is(err, ErrSourceFailed) || !slices.Equal(result, []string{"mixed-0"}) {
+			t.Fatalf("link type mismatch not reported with zero copy %v: %v %v", zeroCopy, err, result)
+		}
+
+		mixed.(io.Closer).Close()
+	}
+
+	if _, err := CreateHandler("file://" + filepath.Join(dir, "missing-*.pcap")); !errors.Is(err, ErrInvalidDataSource) {
+		t.Fatalf("unmatched glob should fail: %v", err)
+	}
+
+	for src, target := range map[string]error{
+		"capture.pcap":   ErrInvalidDataSource,
+		"unknown://eth0": ErrUnknownProtocol,
+	} {
+		if _, err := CreateHandler(src); !errors.Is(err, target) {
+			t.Errorf("data source %q error mismatch: %v", src, err)
+		}
+	}
+
+	if _, err := CreateMultiHandler(nil); !errors.Is(err, ErrInvalidDataSource) {
+		t.Errorf("empty multi data source error mismatch: %v", err)
+	}
+}
+
+func TestRegisterScheme(t *testing.T) {
+	pkg := buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("registered"),
+	})
+
+	var info SourceInfo
+
+	if err := RegisterScheme("memory", func(source string, opts ...HandlerOption) (PacketSource, error) {
+		if source != "replay" || newHandlerOptions(opts...).snapLen != 128 {
+			t.Errorf("scheme opener arguments mismatch: %q", source)
+		}
+
+		return CreateHandlerFromPackets(layers.LinkTypeEthernet, pkg), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterScheme("memory", nil)
+
+	if !slices.Contains(Schemes(), "memory") || !slices.Contains(Schemes(), "file") {
+		t.Fatalf("registered schemes mismatch: %v", Schemes())
+	}
+
+	handler, err := CreateHandler("memory://replay", WithSnapLen(128), WithSourceInfo(&info))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := captureAll(t, handler); !slices.Equal(result, []string{"registered"}) || info.Device != "replay" {
+		t.Fatalf("registered scheme delivery mismatch: %v, info: %+v", result, info)
+	}
+
+	if err := RegisterScheme("bad-scheme", nil); !errors.Is(err, ErrInvalidDataSource) {
+		t.Fatalf("invalid scheme should fail: %v", err)
+	}
+
+	RegisterScheme("memory", nil)
+
+	if _, err := CreateHandler("memory://replay"); !errors.Is(err, ErrUnknownProtocol) {
+		t.Fatalf("unregistered scheme should fail: %v", err)
+	}
+}
+
+func TestCaptureMultiSource(t *testing.T) {
+	intf0, intf1 := make(chanSource), make(chanSource)
+
+	opened := make(chan FlowInfo)
+	done := make(chan error)
+
+	var result []string
+
+	go func() {
+		done <- StartCapture(context.TODO(), newMultiSource(intf0, intf1), "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, WithSessionOpen(func(flow FlowInfo) { opened <- flow }))
+	}()
+
+	intf1 <- buildSegment(t, testSegment{
+		src: "10.0.0.1", dst: "10.0.0.2", sport: 3000, dport: 4000,
+		udp: true, payload: []byte("datagram"), ts: time.Now(),
+	})
+	if flow := <-opened; flow.Interface != 1 {
+		t.Fatalf("udp session interface mismatch: %d", flow.Interface)
+	}
+
+	intf0 <- buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 100, syn: true,
+	})
+	if flow := <-opened; flow.Interface != 0 {
+		t.Fatalf("tcp session interface mismatch: %d", flow.Interface)
+	}
+
+	// 同一会话的数据段由不同接口抓取, 到达顺序不确定
+	intf1 <- buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 106, ack: true, payload: []byte("second"),
+	})
+	intf0 <- buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 101, ack: true, payload: []byte("first"),
+	})
+	close(intf0)
+	close(intf1)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) < 2 || result[0] != "datagram" {
+		t.Fatalf("multi source delivery mismatch: %v", result)
+	}
+
+	if stream := strings.Join(result[1:], ""); stream != "firstsecond" {
+		t.Fatalf("multi source stream mismatch: %s", stream)
+	}
+}
+
+func TestCaptureFromPackets(t *testing.T) {
+	now := time.Now()
+
+	var packets []gopacket.Packet
+	for idx, seg := range []testSegment{
+		{seq: 100, syn: true},
+		{seq: 106, ack: true, payload: []byte("second")},
+		{seq: 101, ack: true, payload: []byte("first")},
+	} {
+		seg.src, seg.dst, seg.sport, seg.dport = "192.168.1.1", "192.168.1.2", 1000, 2000
+		seg.ts = now.Add(time.Duration(idx) * time.Millisecond)
+		packets = append(packets, buildSegment(t, seg))
+	}
+
+	// 乱序数据段经重组后按序交付
+	if result := captureAll(t, CreateHandlerFromPackets(layers.LinkTypeEthernet, packets...)); !slices.Equal(result, []string{"firstsecond"}) {
+		t.Fatalf("packets delivery mismatch: %v", result)
+	}
+
+	var data [][]byte
+	for _, payload := range []string{"hello", "world"} {
+		data = append(data, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+			udp: true, payload: []byte(payload),
+		}).Data())
+	}
+
+	if result := captureAll(t, CreateHandlerFromData(layers.LinkTypeEthernet, data...)); !slices.Equal(result, []string{"hello", "world"}) {
+		t.Fatalf("data delivery mismatch: %v", result)
+	}
+}
+
+func TestCaptureResult(t *testing.T) {
+	var buf bytes.Buffer
+	writePcap(t, &buf, "hello", "world", "again")
+
+	run := func(data []byte, fn core.DataHandler) (result CaptureResult) {
+		handler, err := CreateHandlerFromReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		StartCapture(context.TODO(), handler, "", fn, WithCaptureResult(&result))
+
+		return
+	}
+
+	consume := func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return len(data), nil
+	}
+
+	if result := run(buf.Bytes(), consume); result.Reason != StopEOF || result.Packets != 3 || result.Bytes <= 0 {
+		t.Errorf("complete file result mismatch: %+v", result)
+	}
+
+	if result := run(buf.Bytes()[:buf.Len()-1], consume); result.Reason != StopReadError || result.Packets != 2 || result.Err == nil {
+		t.Errorf("truncated file result mismatch: %+v", result)
+	}
+
+	if result := run(buf.Bytes(), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return 0, io.EOF
+	}); result.Reason != StopHandler {
+		t.Errorf("handler stop result mismatch: %+v", result)
+	}
+
+	if result := run(buf.Bytes(), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		return 0, errors.New("handler failed")
+	}); result.Reason != StopError || result.Err == nil {
+		t.Errorf("handler error result mismatch: %+v", result)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	var result CaptureResult
+
+	if err := StartCapture(ctx, make(chanSource), "", consume, WithCaptureResult(&result)); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Reason != StopCanceled || result.Packets != 0 {
+		t.Errorf("canceled result mismatch: %+v", result)
+	}
+
+	// 无法归类的结束不视为处理函数要求结束
+	newCountSource(make(chanSource)).report(context.TODO(), nil, false, &result)
+	if result.Reason != StopUnknown {
+		t.Errorf("unclassified result mismatch: %+v", result)
+	}
+}
+
+func TestCaptureBPFProgram(t *testing.T) {
+	// udp dst port 2000, 仅匹配无选项的 IPv4 报文头
+	raw, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.EthernetTypeIPv4), SkipFalse: 5},
+		bpf.LoadAbsolute{Off: 23, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.IPProtocolUDP), SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 36, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 2000, SkipFalse: 1},
+		bpf.RetConstant{Val: DefaultSnapLen},
+		bpf.RetConstant{Val: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insts := make([]libpcap.BPFInstruction, len(raw))
+	for idx, inst := range raw {
+		insts[idx] = libpcap.BPFInstruction{Code: inst.Op, Jt: inst.Jt, Jf: inst.Jf, K: inst.K}
+	}
+
+	var data [][]byte
+	for _, dport := range []int{2000, 3000, 2000} {
+		data = append(data, buildSegment(t, testSegment{
+			src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: dport,
+			udp: true, payload: []byte(strconv.Itoa(dport)),
+		}).Data())
+	}
+
+	var result []string
+
+	if err := StartCapture(context.TODO(), CreateHandlerFromData(layers.LinkTypeEthernet, data...), "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		result = append(result, string(data))
+		return len(data), nil
+	}, WithBPFProgram(insts)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result, []string{"2000", "2000"}) {
+		t.Fatalf("bpf program result mismatch: %v", result)
+	}
+
+	if _, err := NewCapture(CreateHandlerFromData(layers.LinkTypeEthernet), "udp", WithBPFProgram(insts)); err == nil {
+		t.Error("bpf filter with bpf program should fail")
+	}
+}
@@ -0,0 +1,92 @@
+package pcap
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	libpcap "github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+)
+
+// Stats 抓包统计信息, PacketsDropped 为内核缓冲区不足导致的丢包,
+// PacketsIfDropped 为网卡驱动层的丢包, 持续增长即表示处理能力不足
+type Stats = libpcap.Stats
+
+// StatsHandler 抓包统计回调, 在独立协程中执行, 不应长时间阻塞
+type StatsHandler func(stats *Stats)
+
+// FilterStats BPF 过滤统计, Accepted 与 Accepted+Filtered 之比即过滤的选择性.
+// 过滤表达式均以 libpcap 优化编译
+type FilterStats struct {
+	// Accepted 通过过滤的数据包数量
+	Accepted uint64
+	// Filtered 被过滤丢弃的数据包数量, 内核过滤时不可获取, 为 0
+	Filtered uint64
+	// Kernel 过滤在内核中进行, Accepted 为内核统计的接收数量
+	Kernel bool
+}
+
+type filterStatsReader interface {
+	FilterStats() (*FilterStats, error)
+}
+
+// Stats 返回数据源的抓包统计, 数据源不支持统计时返回错误
+func (c *Capture) Stats() (*Stats, error) {
+	src, ok := c.source.(statsReader)
+	if !ok {
+		return nil, errors.New("packet source does not support stats")
+	}
+
+	stats, err := src.Stats()
+
+	return stats, errors.WithStack(err)
+}
+
+// FilterStats 返回 BPF 过滤统计, 进程内过滤时可获取丢弃数量,
+// 内核过滤时仅可获取数据源统计中的接收数量, 数据源不支持统计时返回错误
+func (c *Capture) FilterStats() (*FilterStats, error) {
+	if src, ok := c.filter.(filterStatsReader); ok {
+		return src.FilterStats()
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilterStats{Accepted: uint64(stats.PacketsReceived), Kernel: true}, nil
+}
+
+type statsReader interface {
+	Stats() (*libpcap.Stats, error)
+}
+
+// reportStats 按周期读取数据源统计信息, ctx 结束时额外上报一次最终结果
+func reportStats(ctx context.Context, src statsReader, opts *captureOptions) {
+	ticker := time.NewTicker(opts.statsInterval)
+	defer ticker.Stop()
+
+	report := func() {
+		stats, err := src.Stats()
+		if err != nil {
+			opts.logger.Warn(
+				"read capture stats failed:",
+				slog.Any("error", err),
+			)
+			return
+		}
+
+		opts.statsFn(stats)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			report()
+			return
+		case <-ticker.C:
+			report()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package pcap
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pkg/errors"
+)
+
+type snapLener interface {
+	SnapLen() int
+}
+
+// packetWriter 以 pcap 格式写入单个数据包, 由 pcapgo.Writer 及 rotateWriter 实现
+type packetWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// teeSource 读取数据包的同时写入 pcap 文件, 写入失败时停止写入且不影响抓包
+type teeSource struct {
+	PacketSource
+	writer  packetWriter
+	snapLen int
+	logger  *slog.Logger
+}
+
+func newTeeSource(src PacketSource, w io.Writer, logger *slog.Logger) (*teeSource, error) {
+	snapLen := sourceSnapLen(src)
+	writer := pcapgo.NewWriter(w)
+
+	if err := writer.WriteFileHeader(uint32(snapLen), src.LinkType()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &teeSource{
+		PacketSource: src,
+		writer:       writer,
+		snapLen:      snapLen,
+		logger:       logger,
+	}, nil
+}
+
+// newRotateSource 读取数据包的同时按 rotation 轮转写入 pcap 文件, 首个数据包到达时创建文件
+func newRotateSource(src PacketSource, rotation *pcapRotation, logger *slog.Logger) (*teeSource, *rotateWriter) {
+	snapLen := sourceSnapLen(src)
+	writer := newRotateWriter(rotation, snapLen, src.LinkType(), logger)
+
+	return &teeSource{
+		PacketSource: src,
+		writer:       writer,
+		snapLen:      snapLen,
+		logger:       logger,
+	}, writer
+}
+
+func sourceSnapLen(src PacketSource) int {
+	if s, ok := src.(snapLener); ok && s.SnapLen() > 0 {
+		return s.SnapLen()
+	}
+
+	return DefaultSnapLen
+}
+
+func (src *teeSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(src.PacketSource.ReadPacketData)
+}
+
+// ZeroCopyReadPacketData 零拷贝读取, 数据源不支持零拷贝时退化为普通读取
+func (src *teeSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(zeroCopyRead(src.PacketSource))
+}
+
+func (src *teeSource) read(next packetReader) (data []byte, ci gopacket.CaptureInfo, err error) {
+	if data, ci, err = next(); err != nil || src.writer == nil {
+		return
+	}
+
+	dump, info := data, ci
+	if len(dump) > src.snapLen {
+		dump = dump[:src.snapLen]
+		info.CaptureLength = src.snapLen
+	}
+
+	if writeErr := src.writer.WritePacket(info, dump); writeErr != nil {
+		src.logger.Warn(
+			"write packet to pcap writer failed, tee stopped:",
+			slog.Any("error", writeErr),
+		)
+
+		src.writer = nil
+	}
+
+	return
+}
@@ -0,0 +1,44 @@
+package pcap
+
+import (
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// rangeSource 仅读取抓取时间在 [start, end] 区间内的数据包, 离线文件按时间顺序记录,
+// 因此超过 end 后即结束读取
+type rangeSource struct {
+	PacketSource
+	start, end time.Time
+}
+
+func newRangeSource(src PacketSource, start, end time.Time) *rangeSource {
+	return &rangeSource{PacketSource: src, start: start, end: end}
+}
+
+func (src *rangeSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(src.PacketSource.ReadPacketData)
+}
+
+// ZeroCopyReadPacketData 零拷贝读取, 数据源不支持零拷贝时退化为普通读取
+func (src *rangeSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.read(zeroCopyRead(src.PacketSource))
+}
+
+func (src *rangeSource) read(next packetReader) (data []byte, ci gopacket.CaptureInfo, err error) {
+	for {
+		if data, ci, err = next(); err != nil {
+			return
+		}
+
+		if !src.end.IsZero() && ci.Timestamp.After(src.end) {
+			return nil, ci, io.EOF
+		}
+
+		if src.start.IsZero() || !ci.Timestamp.Before(src.start) {
+			return
+		}
+	}
+}
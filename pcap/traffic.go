@@ -0,0 +1,207 @@
+package pcap
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// talkerCapacity 每个 TopN 名额对应的候选会话数量, 候选越多统计越准确
+const talkerCapacity = 8
+
+// ProtoCounters 单个协议的累计数据包数量及抓取长度之和
+type ProtoCounters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// Talker 双向会话的累计流量, Key 为 Canonical 后的会话五元组, ICMP 会话端口为 0
+type Talker struct {
+	Key     core.FlowKey
+	Packets uint64
+	Bytes   uint64
+}
+
+// TrafficStats 流量统计快照, 按最外层 IP 报文的协议分类, 隧道报文计入外层协议;
+// 开启 WithSampling 时仅统计抽样的数据包
+type TrafficStats struct {
+	TCP  ProtoCounters
+	UDP  ProtoCounters
+	ICMP ProtoCounters
+	SCTP ProtoCounters
+	// Other 其他 IP 协议及非 IP 报文
+	Other ProtoCounters
+	// TopTalkers 按字节数降序的前 N 个会话
+	TopTalkers []Talker
+}
+
+// TrafficCollector 流量统计收集器, 以 WithTrafficStats 交由抓包累计, 抓包过程中可随时调用 Stats 获取快照,
+// 可在多次抓包间复用以累计统计.
+// TopN 以 Space-Saving 算法近似统计, 仅保留有限数量的候选会话, 新会话替换流量最小的候选并继承其计数,
+// 因此字节数可能高估, 高估量不超过被替换候选的字节数; 流量显著高于其他会话的 TopN 会话不会被遗漏
+type TrafficCollector struct {
+	mu      sync.Mutex
+	topN    int
+	stats   TrafficStats
+	talkers map[core.FlowKey]*Talker
+}
+
+// NewTrafficCollector 创建流量统计收集器, topN <= 0 时仅统计各协议流量
+func NewTrafficCollector(topN int) *TrafficCollector {
+	return &TrafficCollector{
+		topN:    max(topN, 0),
+		talkers: make(map[core.FlowKey]*Talker),
+	}
+}
+
+// Stats 返回当前统计的快照
+func (tc *TrafficCollector) Stats() *TrafficStats {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	stats := tc.stats
+	stats.TopTalkers = make([]Talker, 0, len(tc.talkers))
+
+	for _, talker := range tc.talkers {
+		stats.TopTalkers = append(stats.TopTalkers, *talker)
+	}
+
+	slices.SortFunc(stats.TopTalkers, func(a, b Talker) int {
+		switch {
+		case a.Bytes > b.Bytes:
+			return -1
+		case a.Bytes < b.Bytes:
+			return 1
+		default:
+			return strings.Compare(a.Key.String(), b.Key.String())
+		}
+	})
+
+	if len(stats.TopTalkers) > tc.topN {
+		stats.TopTalkers = stats.TopTalkers[:tc.topN]
+	}
+
+	return &stats
+}
+
+// add 累计单个数据包, key 无效时仅计入协议统计
+func (tc *TrafficCollector) add(key core.FlowKey, size int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	counters := &tc.stats.Other
+
+	switch key.Proto {
+	case core.TCP:
+		counters = &tc.stats.TCP
+	case core.UDP:
+		counters = &tc.stats.UDP
+	case core.ICMP, core.ICMPv6:
+		counters = &tc.stats.ICMP
+	case core.SCTP:
+		counters = &tc.stats.SCTP
+	}
+
+	counters.Packets++
+	counters.Bytes += uint64(size)
+
+	if tc.topN <= 0 || !key.SrcIP.IsValid() {
+		return
+	}
+
+	key = key.Canonical()
+
+	talker, exist := tc.talkers[key]
+	if !exist {
+		talker = &Talker{Key: key}
+
+		if len(tc.talkers) >= tc.topN*talkerCapacity {
+			var least *Talker
+			for _, candidate := range tc.talkers {
+				if least == nil || candidate.Bytes < least.Bytes {
+					least = candidate
+				}
+			}
+
+			delete(tc.talkers, least.Key)
+			talker.Packets, talker.Bytes = least.Packets, least.Bytes
+		}
+
+		tc.talkers[key] = talker
+	}
+
+	talker.Packets++
+	talker.Bytes += uint64(size)
+}
+
+// countTraffic 以完整解码的数据包累计 WithTrafficStats 的统计
+func (c *capturer) countTraffic(pkg gopacket.Packet) {
+	if c.opts.traffic == nil {
+		return
+	}
+
+	var key core.FlowKey
+
+	switch ip := pkg.NetworkLayer().(type) {
+	case *layers.IPv4:
+		key.Proto, key.SrcIP, key.DstIP = core.TransProto(ip.Protocol), ipAddr(ip.SrcIP), ipAddr(ip.DstIP)
+	case *layers.IPv6:
+		key.SrcIP, key.DstIP = ipAddr(ip.SrcIP), ipAddr(ip.DstIP)
+
+		if transport := pkg.TransportLayer(); transport != nil {
+			key.Proto = transportProto(transport.LayerType())
+		} else if pkg.Layer(layers.LayerTypeICMPv6) != nil {
+			key.Proto = core.ICMPv6
+		}
+	}
+
+	switch transport := pkg.TransportLayer().(type) {
+	case *layers.TCP:
+		key.SrcPort, key.DstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+	case *layers.UDP:
+		key.SrcPort, key.DstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+	case *layers.SCTP:
+		key.SrcPort, key.DstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+	}
+
+	c.opts.traffic.add(key, pkg.Metadata().CaptureLength)
+}
+
+// countRawTraffic 以零拷贝快速解码的 TCP 或 UDP 报文累计 WithTrafficStats 的统计
+func (c *capturer) countRawTraffic(proto core.TransProto, srcIP, dstIP net.IP, srcPort, dstPort uint16, size int) {
+	if c.opts.traffic == nil {
+		return
+	}
+
+	c.opts.traffic.add(core.FlowKey{
+		Proto:   proto,
+		SrcIP:   ipAddr(srcIP),
+		SrcPort: srcPort,
+		DstIP:   ipAddr(dstIP),
+		DstPort: dstPort,
+	}, size)
+}
+
+func ipAddr(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+func transportProto(typ gopacket.LayerType) core.TransProto {
+	switch typ {
+	case layers.LayerTypeTCP:
+		return core.TCP
+	case layers.LayerTypeUDP:
+		return core.UDP
+	case layers.LayerTypeSCTP:
+		return core.SCTP
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,151 @@
+package pcap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// workerQueueSize 单个工作协程的待处理数据包队列长度
+const workerQueueSize = 1024
+
+// captureParallel 按会话哈希将数据包分发至多个工作协程, 各协程独立维护会话缓存表,
+// 任一协程出错或数据处理函数返回 io.EOF 时停止分发
+func captureParallel(ctx context.Context, packets <-chan gopacket.Packet, fn core.DataHandler, opts *captureOptions) error {
+	workers := opts.workers
+
+	workerOpts := *opts
+	if opts.maxSessions > 0 {
+		workerOpts.maxSessions = (opts.maxSessions + workers - 1) / workers
+	}
+
+	// 分片报文仅首个分片携带传输层头, 开启分片重组时仅以网络层分发, 以保证同一数据报的分片由同一协程重组
+	hash := flowHash
+	switch {
+	case opts.defrag > 0:
+		hash = networkHash
+	case len(opts.vxlanPorts) > 0 || opts.gre:
+		hash = tunnelHash(opts)
+	}
+
+	// stop 仅在工作协程退出时取消, ctx 结束时各协程仍需处理完队列中的数据包
+	stop, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		result  error
+		tracked atomic.Int64
+		closed  atomic.Bool
+		queues  = make([]chan gopacket.Packet, workers)
+	)
+
+	for idx := range queues {
+		queue := make(chan gopacket.Packet, workerQueueSize)
+		queues[idx] = queue
+
+		c := newCapturer(fn, &workerOpts)
+		c.tracked = &tracked
+		c.parent = ctx
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := c.run(stop, queue)
+			if err != nil {
+				once.Do(func() { result = err })
+			}
+
+			// 队列关闭前退出表示出错或处理函数要求结束, 通知其余协程停止
+			if err != nil || !closed.Load() {
+				cancel()
+			}
+		}()
+	}
+
+DISPATCH:
+	for {
+		select {
+		case <-ctx.Done():
+			break DISPATCH
+		case <-stop.Done():
+			break DISPATCH
+		case pkg := <-packets:
+			if pkg == nil {
+				break DISPATCH
+			}
+
+			select {
+			case queues[hash(pkg)%uint64(workers)] <- pkg:
+			case <-stop.Done():
+				break DISPATCH
+			}
+		}
+	}
+
+	closed.Store(true)
+
+	for _, queue := range queues {
+		close(queue)
+	}
+
+	wg.Wait()
+
+	return result
+}
+
+// networkHash 计算数据包的网络层哈希, 双向数据包哈希值相同
+func networkHash(pkg gopacket.Packet) uint64 {
+	if network := pkg.NetworkLayer(); network != nil {
+		return network.NetworkFlow().FastHash()
+	}
+
+	return 0
+}
+
+// flowHash 计算数据包的会话哈希, 双向数据包哈希值相同, 不区分隧道报文
+func flowHash(pkg gopacket.Packet) (hash uint64) {
+	hash = networkHash(pkg)
+
+	if transport := pkg.TransportLayer(); transport != nil {
+		hash = hash*31 + transport.TransportFlow().FastHash()
+	}
+
+	return
+}
+
+// tunnelHash 返回按内层会话计算哈希的分发函数, 隧道双向的外层报文头可能不同 (如 VXLAN 外层源端口由各自的 VTEP 生成),
+// 以解封装后的内层报文计算, 保证双向会话由同一协程处理; 解封装规则与抓包处理一致
+func tunnelHash(opts *captureOptions) func(pkg gopacket.Packet) uint64 {
+	var hash func(pkg gopacket.Packet) uint64
+
+	hash = func(pkg gopacket.Packet) uint64 {
+		if opts.gre {
+			if gre, ok := pkg.Layer(layers.LayerTypeGRE).(*layers.GRE); ok {
+				// 不支持嵌套的 GRE 隧道, 仅解封装一层
+				return flowHash(decapsulate(pkg, gre.Payload, gre.Protocol))
+			}
+		}
+
+		if udp, ok := pkg.TransportLayer().(*layers.UDP); ok {
+			if _, exist := opts.vxlanPorts[int(udp.DstPort)]; exist {
+				return hash(decapsulate(pkg, udp.Payload, layers.LayerTypeVXLAN))
+			}
+		}
+
+		return flowHash(pkg)
+	}
+
+	return hash
+}
+
+// sessionHash 以会话地址端口计算哈希, 双向会话哈希值相同, 对非隧道报文与 flowHash 结果一致
+func sessionHash(session *core.Session) uint64 {
+	return session.Key().Hash()
+}
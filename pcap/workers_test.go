@@ -0,0 +1,117 @@
+package pcap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func buildFlows(t *testing.T, flows, segments int) (pkts []gopacket.Packet, expect map[string]string) {
+	expect = make(map[string]string, flows)
+
+	for idx := 0; idx < segments; idx++ {
+		for port := 1000; port < 1000+flows; port++ {
+			payload := fmt.Sprintf("%03d", idx)
+
+			pkg := buildSegment(t, testSegment{
+				src: "192.168.1.1", dst: "192.168.1.2", sport: port, dport: 2000,
+				seq: uint32(100 + idx*len(payload)), ack: true, payload: []byte(payload),
+			})
+			pkts = append(pkts, pkg)
+
+			session := fmt.Sprintf("%d", port)
+			expect[session] += payload
+		}
+	}
+
+	return
+}
+
+func TestCaptureWorkers(t *testing.T) {
+	pkts, expect := buildFlows(t, 16, 20)
+
+	var (
+		mu     sync.Mutex
+		result = make(map[string]string)
+	)
+
+	if err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		result[fmt.Sprintf("%d", session.SrcPort)] += string(data)
+		return len(data), nil
+	}, newCaptureOptions(WithWorkers(4))); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != len(expect) {
+		t.Fatalf("session count mismatch: %d, expect %d", len(result), len(expect))
+	}
+
+	for session, data := range expect {
+		if result[session] != data {
+			t.Errorf("session %s out of order: %q", session, result[session])
+		}
+	}
+}
+
+func TestCaptureWorkersError(t *testing.T) {
+	pkts, _ := buildFlows(t, 8, 10)
+	failed := errors.New("handler failed")
+
+	err := capture(context.TODO(), feedPackets(pkts...), func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		if strings.HasPrefix(string(data), "005") {
+			return 0, failed
+		}
+
+		return len(data), nil
+	}, newCaptureOptions(WithWorkers(4)))
+
+	if !errors.Is(err, failed) {
+		t.Fatalf("worker error not returned: %v", err)
+	}
+}
+
+func TestCaptureWorkersTunnelHash(t *testing.T) {
+	opts := newCaptureOptions(WithWorkers(4), WithVXLAN())
+	hash := tunnelHash(opts)
+
+	tunnel := func(vtep, peer string, sport int, inner gopacket.Packet) gopacket.Packet {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(
+			buf, gopacket.SerializeOptions{},
+			&layers.VXLAN{ValidIDFlag: true, VNI: 42},
+			gopacket.Payload(inner.Data()),
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		return buildSegment(t, testSegment{
+			src: vtep, dst: peer, sport: sport, dport: DefaultVXLANPort,
+			udp: true, payload: buf.Bytes(),
+		})
+	}
+
+	// 双向外层源端口分别由两端 VTEP 按内层报文生成, 互不相同
+	for port := 1000; port < 1016; port++ {
+		request := tunnel("172.16.0.1", "172.16.0.2", 50000+port, buildSegment(t, testSegment{
+			src: "10.0.0.1", dst: "10.0.0.2", sport: port, dport: 80, seq: 1, ack: true,
+		}))
+		response := tunnel("172.16.0.2", "172.16.0.1", 60000-port, buildSegment(t, testSegment{
+			src: "10.0.0.2", dst: "10.0.0.1", sport: 80, dport: port, seq: 1, rst: true,
+		}))
+
+		if hash(request) != hash(response) {
+			t.Fatalf("tunneled session %d hashed to different workers", port)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package pcap
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+)
+
+// zeroCopySource 支持零拷贝读取的数据源, 返回的数据在下次读取时将被覆盖
+type zeroCopySource interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+type packetReader func() ([]byte, gopacket.CaptureInfo, error)
+
+// zeroCopyRead 返回数据源的零拷贝读取函数, 不支持时返回普通读取函数
+func zeroCopyRead(src PacketSource) packetReader {
+	if zc, ok := src.(zeroCopySource); ok {
+		return zc.ZeroCopyReadPacketData
+	}
+
+	return src.ReadPacketData
+}
+
+// DecodeTargets 调用方预分配的解码目标, 以 WithDecodeTargets 交由零拷贝快速解码复用, 未设置的字段使用内部分配的协议层.
+// 复用约定:
+//   - 每个数据包解码前覆盖各协议层及 Decoded, 其内容仅在当前数据包的 fn 及回调同步调用期间有效,
+//     fn 因乱序重组延迟交付的数据可能来自之前的数据包, 此时协议层为触发交付的当前数据包;
+//   - 各协议层的 Contents 及 Payload 引用复用的读取缓冲区, 不可在调用返回后保留或修改;
+//   - 隧道报文, 分片报文及其他链路层类型不经快速解码, 此时 Decoded 为空, 各协议层可能为部分解码或上次解码的内容;
+//   - 解码在抓包协程中进行, 抓包期间不可在其他协程中读写, 亦不可同时交由多个抓包使用
+type DecodeTargets struct {
+	IPv4 *layers.IPv4
+	IPv6 *layers.IPv6
+	TCP  *layers.TCP
+	UDP  *layers.UDP
+	// Decoded 快速解码成功的协议层类型, 链路层在前
+	Decoded *[]gopacket.LayerType
+}
+
+// layerParser 以预分配的协议层解码 Ethernet/Linux SLL/Loopback/802.1Q/MPLS/IP/TCP/UDP 报文, 不可并发使用;
+// IP 及传输层可由 WithDecodeTargets 以调用方分配的协议层替代
+type layerParser struct {
+	parsers map[layers.LinkType]*gopacket.DecodingLayerParser
+	decoded *[]gopacket.LayerType
+
+	eth   layers.Ethernet
+	sll   layers.LinuxSLL
+	lo    layers.Loopback
+	dot1q layers.Dot1Q
+	mpls  mplsStack
+	ip4   *layers.IPv4
+	ip6   *layers.IPv6
+	tcp   *layers.TCP
+	udp   *layers.UDP
+}
+
+func newLayerParser(targets *DecodeTargets) *layerParser {
+	if targets == nil {
+		targets = &DecodeTargets{}
+	}
+
+	p := layerParser{
+		decoded: targets.Decoded,
+		ip4:     cmp.Or(targets.IPv4, &layers.IPv4{}),
+		ip6:     cmp.Or(targets.IPv6, &layers.IPv6{}),
+		tcp:     cmp.Or(targets.TCP, &layers.TCP{}),
+		udp:     cmp.Or(targets.UDP, &layers.UDP{}),
+	}
+
+	if p.decoded == nil {
+		decoded := make([]gopacket.LayerType, 0, 8)
+		p.decoded = &decoded
+	}
+
+	parser := func(first gopacket.LayerType, link gopacket.DecodingLayer) *gopacket.DecodingLayerParser {
+		parser := gopacket.NewDecodingLayerParser(
+			first, link, &p.dot1q, &p.mpls, p.ip4, p.ip6, p.tcp, p.udp,
+		)
+		// 不支持的协议层(如 IPv6 扩展头, GRE)由完整解码处理
+		parser.IgnoreUnsupported = true
+
+		return parser
+	}
+
+	p.parsers = map[layers.LinkType]*gopacket.DecodingLayerParser{
+		layers.LinkTypeEthernet: parser(layers.LayerTypeEthernet, &p.eth),
+		layers.LinkTypeLinuxSLL: parser(layers.LayerTypeLinuxSLL, &p.sll),
+		// BSD/macOS 及 Npcap 的回环接口, 4 字节地址族头部
+		layers.LinkTypeNull: parser(layers.LayerTypeLoopback, &p.lo),
+		layers.LinkTypeLoop: parser(layers.LayerTypeLoopback, &p.lo),
+	}
+
+	return &p
+}
+
+// rawRead 零拷贝读取结果, data 在读取协程收到释放信号前有效
+type rawRead struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	err  error
+}
+
+// zeroCopyReads 在独立协程中逐个读取零拷贝数据包, 每次交付后等待 release 再读取下一个, 保证数据处理期间不被覆盖;
+// 读取出现不可恢复错误或 ctx 结束时退出, 阻塞中的读取不影响抓包协程响应 ctx
+func zeroCopyReads(ctx context.Context, src zeroCopySource) (<-chan rawRead, chan<- struct{}) {
+	// 释放信号带缓冲, 读取协程已退出时抓包协程不阻塞
+	reads, release := make(chan rawRead), make(chan struct{}, 1)
+
+	go func() {
+		for {
+			data, ci, err := src.ZeroCopyReadPacketData()
+
+			select {
+			case reads <- rawRead{data: data, ci: ci, err: err}:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil && readFatal(err) {
+				return
+			}
+
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return reads, release
+}
+
+// runZeroCopy 以零拷贝方式读取数据源, 常见报文无需逐包分配内存,
+// 快速解码无法处理的报文将拷贝后进行完整解码
+func (c *capturer) runZeroCopy(ctx context.Context, src zeroCopySource, linkType layers.LinkType) error {
+	defer c.flows.Clear(CloseCaptureEnd)
+
+	parser := newLayerParser(c.opts.decodeTargets)
+
+	var sweep <-chan time.Time
+	if interval := c.flows.SweepInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		sweep = ticker.C
+	}
+
+	// 抓包返回时结束读取协程
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reads, release := zeroCopyReads(readCtx, src)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return c.flush(c.opts.onFlush)
+		case now := <-sweep:
+			c.flows.Sweep(now)
+		case read := <-reads:
+			if read.err != nil && readFatal(read.err) {
+				return c.flush(c.opts.onEOF)
+			}
+
+			err := c.handleZeroCopy(parser, read, linkType)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					c.stopped()
+					return nil
+				}
+
+				return err
+			}
+
+			release <- struct{}{}
+		}
+	}
+}
+
+// handleZeroCopy 处理一次零拷贝读取结果, 可恢复的读取错误直接跳过
+func (c *capturer) handleZeroCopy(parser *layerParser, read rawRead, linkType layers.LinkType) error {
+	if read.err != nil {
+		return nil
+	}
+
+	data, ci := read.data, read.ci
+
+	if c.opts.rawHook != nil && !c.rawHook(data, ci, captureLinkType(ci, linkType)) {
+		return nil
+	}
+
+	if !c.samplePacket() || c.discard() {
+		return nil
+	}
+
+	c.opts.observer.PacketProcessed(ci.CaptureLength)
+	c.checkTruncated(ci)
+	c.flows.route = c.matchRoute(ci, data)
+
+	err := c.handleRaw(parser, data, ci, captureLinkType(ci, linkType))
+
+	c.opts.observer.SessionsTracked(c.sessions())
+
+	return err
+}
+
+// handleRaw 处理零拷贝读取的原始报文, data 仅在本次调用期间有效
+func (c *capturer) handleRaw(p *layerParser, data []byte, ci gopacket.CaptureInfo, linkType layers.LinkType) error {
+	c.flows.iface, c.flows.captured, c.flows.labels = ci.InterfaceIndex, ci.CaptureLength, nil
+	c.flows.timestamp = ci.Timestamp
+	c.flows.handshake = false
+
+	if parser, exist := p.parsers[linkType]; exist && parser.DecodeLayers(data, p.decoded) == nil && len(*p.decoded) > 0 {
+		var (
+			srcIP, dstIP net.IP
+			vlans        []uint16
+			ip4          *layers.IPv4
+		)
+
+		for _, typ := range *p.decoded {
+			switch typ {
+			case layers.LayerTypeEthernet:
+				vlans = rawVLANs(data, 14, p.eth.EthernetType)
+			case layers.LayerTypeLinuxSLL:
+				vlans = rawVLANs(data, 16, p.sll.EthernetType)
+			case layers.LayerTypeMPLS:
+				c.flows.labels = p.mpls.labels
+			case layers.LayerTypeIPv4:
+				if c.defrag != nil && isFragment(p.ip4) {
+					*p.decoded = (*p.decoded)[:0]
+
+					pkg := cloneRaw(data, ci, linkType)
+					c.countTraffic(pkg)
+
+					return c.handlePacket(pkg)
+				}
+
+				srcIP, dstIP, ip4 = p.ip4.SrcIP, p.ip4.DstIP, p.ip4
+				c.ipID = uint32(p.ip4.Id)
+			case layers.LayerTypeIPv6:
+				srcIP, dstIP = p.ip6.SrcIP, p.ip6.DstIP
+				c.ipID = p.ip6.FlowLabel
+			}
+		}
+
+		switch decoded := *p.decoded; decoded[len(decoded)-1] {
+		case layers.LayerTypeTCP:
+			c.countRawTraffic(core.TCP, srcIP, dstIP, uint16(p.tcp.SrcPort), uint16(p.tcp.DstPort), ci.CaptureLength)
+
+			if ip4 != nil && !c.acceptIPOptions(ip4) || !c.matchVLAN(vlans) {
+				return nil
+			}
+
+			return c.handleTCP(ci.Timestamp, srcIP, dstIP, vlans, p.tcp)
+		case layers.LayerTypeUDP:
+			if c.isVXLAN(p.udp) {
+				break
+			}
+
+			c.countRawTraffic(core.UDP, srcIP, dstIP, uint16(p.udp.SrcPort), uint16(p.udp.DstPort), ci.CaptureLength)
+
+			if ip4 != nil && !c.acceptIPOptions(ip4) || !c.matchVLAN(vlans) {
+				return nil
+			}
+
+			if len(p.udp.Payload) <= 0 {
+				return nil
+			}
+
+			return c.handleDatagram(ci.Timestamp, srcIP, dstIP, vlans, p.udp)
+		}
+	}
+
+	// 未经快速解码的报文清空 Decoded, 避免 DecodeTargets 的使用方误用部分解码的协议层
+	*p.decoded = (*p.decoded)[:0]
+
+	pkg := cloneRaw(data, ci, linkType)
+	c.checkDecoded(pkg)
+	c.countTraffic(pkg)
+
+	return c.handlePacket(pkg)
+}
+
+// rawHook 以延迟解码的数据包调用 WithRawPacketHook 设置的回调, 数据包引用读取缓冲区, 不进行拷贝
+func (c *capturer) rawHook(data []byte, ci gopacket.CaptureInfo, linkType layers.LinkType) bool {
+	pkg := gopacket.NewPacket(data, linkDecoder(linkType), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	ci.AncillaryData = nil
+	pkg.Metadata().CaptureInfo = ci
+
+	return c.opts.rawHook(pkg)
+}
+
+// cloneRaw 拷贝原始报文并完整解码, 读取缓冲区将被复用, 分片重组等需持有数据的处理均需拷贝
+func cloneRaw(data []byte, ci gopacket.CaptureInfo, linkType layers.LinkType) gopacket.Packet {
+	pkg := gopacket.NewPacket(slices.Clone(data), linkDecoder(linkType), gopacket.NoCopy)
+	ci.AncillaryData = nil
+	pkg.Metadata().CaptureInfo = ci
+
+	return pkg
+}
+
+// rawVLANs 从原始报文中读取 802.1Q 标签, offset 为链路层协议类型字段之后的偏移
+func rawVLANs(data []byte, offset int, etherType layers.EthernetType) (vlans []uint16) {
+	for (etherType == layers.EthernetTypeDot1Q || etherType == layers.EthernetTypeQinQ) && len(data) >= offset+4 {
+		vlans = append(vlans, binary.BigEndian.Uint16(data[offset:])&0x0fff)
+		etherType = layers.EthernetType(binary.BigEndian.Uint16(data[offset+2:]))
+		offset += 4
+	}
+
+	return
+}
+
+// readFatal 判断读取错误是否不可恢复, 与 gopacket.PacketSource 的处理保持一致
+func readFatal(err error) bool {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return false
+	}
+
+	if err == syscall.EAGAIN {
+		return false
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF ||
+		err == io.ErrNoProgress || err == io.ErrClosedPipe || err == io.ErrShortBuffer ||
+		err == syscall.EBADF || errors.Is(err, net.ErrClosed) || errors.Is(err, ErrSourceFailed) ||
+		strings.Contains(err.Error(), "use of closed file") {
+		return true
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	return false
+}
@@ -0,0 +1,282 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/frozenpine/pkt4go/core"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestCaptureZeroCopy(t *testing.T) {
+	inner := buildSegment(t, testSegment{
+		src: "10.0.0.1", dst: "10.0.0.2", sport: 3000, dport: 4000,
+		udp: true, payload: []byte("vxlan"),
+	})
+
+	var frames [][]byte
+
+	for _, seg := range []testSegment{
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 100, syn: true},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 106, ack: true, payload: []byte("second")},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 101, ack: true, payload: []byte("first")},
+		{src: "fe80::1", dst: "fe80::2", sport: 1000, dport: 2000, seq: 1, ack: true, payload: []byte("ipv6")},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, udp: true, vlans: []uint16{100, 200}, payload: []byte("qinq")},
+	} {
+		frames = append(frames, buildSegment(t, seg).Data())
+	}
+
+	frames = append(frames, buildVXLAN(t, DefaultVXLANPort, inner).Data())
+
+	run := func(opts ...CaptureOption) (result []string) {
+		var buf bytes.Buffer
+		writeFrames(t, &buf, layers.LinkTypeEthernet, frames...)
+
+		handler, err := CreateHandlerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, session.String()+" "+string(data))
+			return len(data), nil
+		}, append(opts, WithVXLAN())...); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	expect := run()
+	if len(expect) != 4 {
+		t.Fatalf("unexpected packet source result: %v", expect)
+	}
+
+	if result := run(WithZeroCopy(true)); !slices.Equal(result, expect) {
+		t.Fatalf("zero copy result mismatch:\n%v\nexpect:\n%v", result, expect)
+	}
+}
+
+func TestCaptureMPLS(t *testing.T) {
+	var frames [][]byte
+
+	for _, seg := range []testSegment{
+		{seq: 100, syn: true},
+		{seq: 106, ack: true, payload: []byte("second")},
+		{seq: 101, ack: true, payload: []byte("first")},
+	} {
+		seg.src, seg.dst, seg.sport, seg.dport = "192.168.1.1", "192.168.1.2", 1000, 2000
+		frames = append(frames, buildMPLS(t, buildSegment(t, seg), 16, 17).Data())
+	}
+
+	frames = append(frames, buildMPLS(t, buildSegment(t, testSegment{
+		src: "fe80::1", dst: "fe80::2", sport: 1000, dport: 2000, udp: true, payload: []byte("ipv6"),
+	}), 18, 19, 20).Data())
+
+	run := func(opts ...CaptureOption) (result []string) {
+		var buf bytes.Buffer
+		writeFrames(t, &buf, layers.LinkTypeEthernet, frames...)
+
+		handler, err := CreateHandlerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, session.String()+" "+string(data))
+			return len(data), nil
+		}, append(opts, WithVLANKey())...); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	expect := []string{
+		"[tcp] 192.168.1.1:1000 -> 192.168.1.2:2000 firstsecond",
+		"[udp] [fe80::1]:1000 -> [fe80::2]:2000 ipv6",
+	}
+
+	if result := run(); !slices.Equal(result, expect) {
+		t.Fatalf("mpls result mismatch: %v", result)
+	}
+
+	if result := run(WithZeroCopy(true)); !slices.Equal(result, expect) {
+		t.Fatalf("zero copy mpls result mismatch: %v", result)
+	}
+}
+
+func TestCaptureRawPacketHook(t *testing.T) {
+	var frames [][]byte
+
+	for _, seg := range []testSegment{
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 101, ack: true, payload: []byte("tcp")},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, udp: true, payload: []byte("skipped")},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 3000, udp: true, payload: []byte("udp")},
+	} {
+		frames = append(frames, buildSegment(t, seg).Data())
+	}
+
+	// ARP 等非 IP 报文同样经过回调
+	frames = append(frames, buildPacket(t, layers.LinkTypeEthernet, time.Now(), &layers.Ethernet{
+		SrcMAC:       []byte{0, 1, 2, 3, 4, 5},
+		DstMAC:       []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		EthernetType: layers.EthernetTypeARP,
+	}, &layers.ARP{
+		AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4,
+		HwAddressSize: 6, ProtAddressSize: 4, Operation: layers.ARPRequest,
+		SourceHwAddress: []byte{0, 1, 2, 3, 4, 5}, SourceProtAddress: []byte{192, 168, 1, 1},
+		DstHwAddress: make([]byte, 6), DstProtAddress: []byte{192, 168, 1, 2},
+	}).Data())
+
+	for _, zeroCopy := range []bool{false, true} {
+		var buf bytes.Buffer
+		writeFrames(t, &buf, layers.LinkTypeEthernet, frames...)
+
+		handler, err := CreateHandlerFromReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var hooked, result []string
+
+		if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			result = append(result, string(data))
+			return len(data), nil
+		}, WithZeroCopy(zeroCopy), WithRawPacketHook(func(pkg gopacket.Packet) bool {
+			if pkg.Metadata().CaptureLength != len(pkg.Data()) {
+				t.Errorf("hook capture info mismatch: %d", pkg.Metadata().CaptureLength)
+			}
+
+			if pkg.Layer(layers.LayerTypeARP) != nil {
+				hooked = append(hooked, "arp")
+				return true
+			}
+
+			hooked = append(hooked, string(pkg.ApplicationLayer().Payload()))
+
+			return string(pkg.ApplicationLayer().Payload()) != "skipped"
+		})); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(hooked, []string{"tcp", "skipped", "udp", "arp"}) {
+			t.Fatalf("zero copy[%t] hooked packets mismatch: %v", zeroCopy, hooked)
+		}
+
+		if !slices.Equal(result, []string{"tcp", "udp"}) {
+			t.Fatalf("zero copy[%t] delivery mismatch: %v", zeroCopy, result)
+		}
+	}
+}
+
+func TestCaptureDecodeTargets(t *testing.T) {
+	var frames [][]byte
+
+	for _, seg := range []testSegment{
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000, seq: 100, ack: true, payload: []byte("tcp")},
+		{src: "192.168.1.1", dst: "192.168.1.2", sport: 3000, dport: 4000, udp: true, payload: []byte("udp")},
+	} {
+		frames = append(frames, buildSegment(t, seg).Data())
+	}
+
+	frames = append(frames, buildVXLAN(t, DefaultVXLANPort, buildSegment(t, testSegment{
+		src: "10.0.0.1", dst: "10.0.0.2", sport: 5000, dport: 6000, udp: true, payload: []byte("vxlan"),
+	})).Data())
+
+	var buf bytes.Buffer
+	writeFrames(t, &buf, layers.LinkTypeEthernet, frames...)
+
+	handler, err := CreateHandlerFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		decoded []gopacket.LayerType
+		targets = DecodeTargets{
+			IPv4:    &layers.IPv4{},
+			TCP:     &layers.TCP{},
+			UDP:     &layers.UDP{},
+			Decoded: &decoded,
+		}
+		result []string
+	)
+
+	if err := StartCapture(context.TODO(), handler, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+		switch {
+		case len(decoded) == 0:
+			result = append(result, "none "+string(data))
+		case decoded[len(decoded)-1] == layers.LayerTypeTCP:
+			result = append(result, targets.IPv4.SrcIP.String()+" "+strconv.Itoa(int(targets.TCP.SrcPort))+" "+string(data))
+		case decoded[len(decoded)-1] == layers.LayerTypeUDP:
+			result = append(result, targets.IPv4.SrcIP.String()+" "+strconv.Itoa(int(targets.UDP.SrcPort))+" "+string(data))
+		}
+
+		return len(data), nil
+	}, WithZeroCopy(true), WithVXLAN(), WithDecodeTargets(&targets)); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"192.168.1.1 1000 tcp", "192.168.1.1 3000 udp", "none vxlan"}
+	if !slices.Equal(result, expect) {
+		t.Fatalf("decode targets result mismatch:\n%v\nexpect:\n%v", result, expect)
+	}
+}
+
+// zeroCopyChanSource 支持零拷贝读取的 chanSource, 无数据时读取阻塞
+type zeroCopyChanSource struct{ chanSource }
+
+func (src zeroCopyChanSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return src.ReadPacketData()
+}
+
+func TestCaptureZeroCopyCancel(t *testing.T) {
+	src := zeroCopyChanSource{make(chanSource, 1)}
+	defer close(src.chanSource)
+
+	src.chanSource <- buildSegment(t, testSegment{
+		src: "192.168.1.1", dst: "192.168.1.2", sport: 1000, dport: 2000,
+		udp: true, payload: []byte("idle"), ts: time.Now(),
+	})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	idle := make(chan CloseReason, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- StartCapture(ctx, src, "", func(session *core.Session, ts time.Time, data []byte) (int, error) {
+			return len(data), nil
+		}, WithZeroCopy(true), WithIdleTimeout(50*time.Millisecond), WithSessionClose(func(flow FlowInfo, reason CloseReason) {
+			idle <- reason
+		}))
+	}()
+
+	// 读取阻塞期间空闲清理照常进行
+	select {
+	case reason := <-idle:
+		if reason != CloseTimeout {
+			t.Errorf("session close reason mismatch: %s", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle session not swept while read blocked")
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("zero copy capture not stopped after cancel")
+	}
+}